@@ -0,0 +1,440 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "sort"
+    "sync"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/lacp"
+    "github.com/AMD-DC-GPU/ce/netgraph/stp"
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+    "github.com/gopacket/gopacket"
+)
+
+// eventQueueLen is how many decoded frames may wait for the aggregator.
+const eventQueueLen = 4096
+
+// eventSendTimeout is how long a capture worker waits for room in a full queue before it
+// drops the frame. Until then the worker stops reading, so bursts back up into the socket
+// buffer (and show up as kernel drops) rather than into memory.
+const eventSendTimeout = time.Second
+
+// frameEvent is what a capture worker decoded from one frame.
+type frameEvent struct {
+    Iface     string
+    Time      time.Time
+    EtherType uint16 // counted per interface; CDP is counted as its SNAP protocol ID, STP as its LLC SAP, 0 for non-Ethernet frames
+    SrcMAC    string // Ethernet source, to notice our own frames
+    Failed    string // protocol of a frame that could not be decoded
+
+    Edge     *edgeEvent
+    Neighbor *neighborEvent
+    Binding  *bindingEvent
+    Router   *topology.Router // from an IPv6 Router Advertisement
+    LACP     *lacp.PDU
+    STP      *stp.BPDU
+}
+
+// decoded reports whether the frame added anything to the topology.
+func (ev frameEvent) decoded() bool {
+    return ev.Edge != nil || ev.Neighbor != nil || ev.Binding != nil || ev.Router != nil || ev.LACP != nil || ev.STP != nil
+}
+
+// fail marks the frame as one of protocol that could not be decoded.
+func (ev *frameEvent) fail(protocol string) {
+    if debug {
+        log.Printf("NETGRAPH: failed to parse %s frame on %s\n", protocol, ev.Iface)
+    }
+    ev.Failed = protocol
+}
+
+// edgeEvent is an LLDP or CDP advertisement of the link it was received on.
+type edgeEvent struct {
+    Protocol      string
+    Local, Remote topology.Node
+}
+
+// neighborEvent is a neighbor logged under Key (see aggregator.neighbors).
+type neighborEvent struct {
+    Key      string
+    Neighbor topology.Neighbor
+}
+
+// bindingEvent is an IP to MAC binding seen on the wire.
+type bindingEvent struct {
+    IP     net.IP
+    MAC    net.HardwareAddr
+    Source string
+}
+
+// aggregator owns the topology state. Capture workers decode frames into events and send
+// them on a queue; the aggregator applies them one at a time. Readers (reports, the HTTP
+// API, metrics) take consistent copies under mu.
+type aggregator struct {
+    events      chan frameEvent
+    done        chan struct{}
+    sendTimeout time.Duration
+
+    mu sync.Mutex
+    // edges holds the discovered LLDP/CDP edges in discovery order; edgeIndex maps each
+    // link to its position in edges.
+    edges     []topology.Edge
+    edgeIndex map[edgeKey]int
+    // neighbors holds the ARP, ND, CDP, LACP and STP neighbors by "<iface>-<protocol>-<mac>".
+    neighbors map[string]topology.Neighbor
+    // bindings holds every IP to MAC binding seen on the wire, routers every IPv6 router
+    // heard advertising (see l3.go).
+    bindings map[bindingKey]*topology.Binding
+    routers  map[routerKey]*topology.Router
+    // lacpPorts holds the latest LACPDU seen on each local interface (see lag.go).
+    lacpPorts map[string]*lacpPort
+    // stpPorts holds the spanning tree state learned on each local interface (see spantree.go).
+    stpPorts map[string]*topology.STPPort
+    // ownFrames counts frames one captured interface heard from another (see anomaly.go).
+    ownFrames map[ownFrameKey]*ownFrames
+    // ifaces tracks the capture state and counters of every interface we tried to open.
+    ifaces map[string]*topology.InterfaceStatus
+}
+
+// newAggregator returns an empty aggregator whose queue holds queueLen events.
+func newAggregator(queueLen int) *aggregator {
+    return &aggregator{
+        events:      make(chan frameEvent, queueLen),
+        done:        make(chan struct{}),
+        sendTimeout: eventSendTimeout,
+        edgeIndex:   make(map[edgeKey]int),
+        neighbors:   make(map[string]topology.Neighbor),
+        bindings:    make(map[bindingKey]*topology.Binding),
+        routers:     make(map[routerKey]*topology.Router),
+        lacpPorts:   make(map[string]*lacpPort),
+        stpPorts:    make(map[string]*topology.STPPort),
+        ownFrames:   make(map[ownFrameKey]*ownFrames),
+        ifaces:      make(map[string]*topology.InterfaceStatus),
+    }
+}
+
+// run applies queued events until stop is called.
+func (a *aggregator) run() {
+    defer close(a.done)
+    for ev := range a.events {
+        a.apply(ev)
+    }
+}
+
+// stop waits for run to apply the events still queued. Nothing may be sent afterwards.
+func (a *aggregator) stop() {
+    close(a.events)
+    <-a.done
+}
+
+// send queues ev, waiting up to sendTimeout for room. It reports false if the frame had to be
+// dropped, which is counted against the interface.
+func (a *aggregator) send(ctx context.Context, ev frameEvent) bool {
+    select {
+    case a.events <- ev:
+        return true
+    default:
+    }
+    timer := time.NewTimer(a.sendTimeout)
+    defer timer.Stop()
+    select {
+    case a.events <- ev:
+        return true
+    case <-timer.C:
+    case <-ctx.Done():
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if st, ok := a.ifaces[ev.Iface]; ok {
+        st.Dropped++
+    }
+    return false
+}
+
+// processPacket decodes packet and applies it right away, as replaying a capture file does.
+func (a *aggregator) processPacket(deviceName string, packet gopacket.Packet) {
+    a.apply(decodePacket(deviceName, packet))
+}
+
+// apply adds one decoded frame to the topology state and the interface counters.
+// Frames on interfaces that are not being captured (replayed files) are not counted.
+func (a *aggregator) apply(ev frameEvent) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if st, ok := a.ifaces[ev.Iface]; ok {
+        // Our own advertisements, captured on the way out, are not neighbors.
+        if ev.Edge != nil && ev.SrcMAC != "" && ev.SrcMAC == st.MAC {
+            ev.Edge = nil
+        }
+        a.noteOwnFrameLocked(ev, st)
+        // Nor are our own LACPDUs, which bond members see go out unless capture is inbound only.
+        if ev.LACP != nil && a.ownLACPLocked(ev.Iface, ev.SrcMAC, *ev.LACP) {
+            ev.LACP, ev.Neighbor = nil, nil
+        }
+        st.Packets++
+        st.LastPacket = ev.Time
+        if ev.EtherType != 0 {
+            if st.PacketsByEtherType == nil {
+                st.PacketsByEtherType = make(map[string]uint64)
+            }
+            st.PacketsByEtherType[etherTypeKey(ev.EtherType)]++
+        }
+        if ev.Failed != "" {
+            if st.ParseFailures == nil {
+                st.ParseFailures = make(map[string]uint64)
+            }
+            st.ParseFailures[ev.Failed]++
+            st.Errors++
+        }
+        if ev.decoded() {
+            st.Decoded++
+        }
+    }
+
+    if ev.Edge != nil {
+        a.storeEdge(ev.Edge.Protocol, ev.Edge.Local, ev.Edge.Remote, ev.Time)
+    }
+    if ev.Neighbor != nil {
+        a.neighbors[ev.Neighbor.Key] = ev.Neighbor.Neighbor
+    }
+    if ev.Binding != nil {
+        a.recordBinding(ev.Iface, ev.Binding.IP, ev.Binding.MAC, ev.Binding.Source, ev.Time)
+    }
+    if ev.Router != nil {
+        a.recordRouter(ev.Iface, *ev.Router, ev.Time)
+    }
+    if ev.LACP != nil {
+        a.recordLACP(ev.Iface, *ev.LACP, ev.Time)
+    }
+    if ev.STP != nil {
+        a.recordSTP(ev.Iface, *ev.STP, ev.Time)
+    }
+}
+
+// ---- Interface status ----
+
+// statusLocked returns the status of deviceName, creating it on first use. a.mu must be held.
+func (a *aggregator) statusLocked(deviceName string) *topology.InterfaceStatus {
+    st, ok := a.ifaces[deviceName]
+    if !ok {
+        st = &topology.InterfaceStatus{
+            Name:        deviceName,
+            MAC:         getInterfaceMAC(deviceName).String(),
+            Advertising: advertisedIfaces[deviceName],
+            NIC:         readNICInventory(deviceName),
+        }
+        a.ifaces[deviceName] = st
+    }
+    return st
+}
+
+// setInterfaceState records the capture state of an interface. A failure is sticky:
+// a later "stopped" does not hide why the capture ended. A failure also counts as an error.
+func (a *aggregator) setInterfaceState(deviceName, state string, err error) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    st := a.statusLocked(deviceName)
+    if state == ifaceStateStopped && st.State == ifaceStateFailed {
+        return
+    }
+    st.State = state
+    st.Error = ""
+    if err != nil {
+        st.Error = err.Error()
+        st.Errors++
+    }
+}
+
+// recordCaptureStats copies the capture backend's receive/drop counters into the interface
+// status, and logs frames the kernel or libpcap dropped since the last call.
+func (a *aggregator) recordCaptureStats(deviceName string, stats captureStats) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    st, ok := a.ifaces[deviceName]
+    if !ok {
+        return
+    }
+    if lost := stats.Dropped + stats.IfDropped - st.PcapDropped - st.PcapIfDropped; lost > 0 {
+        log.Printf("Capture on %s dropped %d frame(s) (%d by the kernel buffer, %d by the interface in total)\n",
+            deviceName, lost, stats.Dropped, stats.IfDropped)
+    }
+    st.PcapReceived = stats.Received
+    st.PcapDropped = stats.Dropped
+    st.PcapIfDropped = stats.IfDropped
+}
+
+// noteLinkTransition records that ifName's link changed to state at t.
+func (a *aggregator) noteLinkTransition(ifName, state string, t time.Time) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    st := a.statusLocked(ifName)
+    st.LinkTransitions = append(st.LinkTransitions, topology.LinkTransition{Time: t, State: state})
+}
+
+// markSilent flags the named interfaces as silent (see silentPortFindings).
+func (a *aggregator) markSilent(names []string) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for _, name := range names {
+        if st, ok := a.ifaces[name]; ok {
+            st.Silent = true
+        }
+    }
+}
+
+// interfacesSnapshot returns the capture status of every interface, sorted by name.
+func (a *aggregator) interfacesSnapshot() []topology.InterfaceStatus {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    out := make([]topology.InterfaceStatus, 0, len(a.ifaces))
+    for _, st := range a.ifaces {
+        c := *st
+        c.PacketsByEtherType = copyCounts(st.PacketsByEtherType)
+        c.ParseFailures = copyCounts(st.ParseFailures)
+        c.LinkTransitions = append([]topology.LinkTransition(nil), st.LinkTransitions...)
+        if p, ok := a.stpPorts[st.Name]; ok {
+            stpPort := *p
+            c.STP = &stpPort
+        }
+        out = append(out, c)
+    }
+    sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+    return out
+}
+
+// printCaptureCounters prints the frame counters of every interface that was captured on.
+func printCaptureCounters(w io.Writer, statuses []topology.InterfaceStatus) {
+    if len(statuses) == 0 {
+        return
+    }
+    fmt.Fprintln(w, "Capture counters:")
+    for _, st := range statuses {
+        fmt.Fprintf(w, "  %s: %d frames, %d decoded, %d dropped, %d errors, %d dropped by the kernel\n",
+            st.Name, st.Packets, st.Decoded, st.Dropped, st.Errors, st.PcapDropped+st.PcapIfDropped)
+    }
+    fmt.Fprintln(w)
+}
+
+// copyCounts copies a per-key counter map, keeping nil as nil.
+func copyCounts(m map[string]uint64) map[string]uint64 {
+    if m == nil {
+        return nil
+    }
+    out := make(map[string]uint64, len(m))
+    for k, v := range m {
+        out[k] = v
+    }
+    return out
+}
+
+// ---- Edges and neighbors ----
+
+// storeEdge records a discovered link (LLDP or CDP). A repeated advertisement for a known
+// link refreshes it in place: the nodes are replaced with the latest advertisement, LastSeen
+// moves forward and the frame count goes up. a.mu must be held.
+func (a *aggregator) storeEdge(protocol string, local, remote topology.Node, seen time.Time) {
+    key := newEdgeKey(local, remote)
+    // Note when the interface first learned a neighbor, for -until-complete.
+    if st, ok := a.ifaces[local.Interface]; ok && st.FirstNeighbor.IsZero() {
+        st.FirstNeighbor = seen
+    }
+
+    if i, ok := a.edgeIndex[key]; ok {
+        e := &a.edges[i]
+        e.Local = local
+        e.Remote = remote
+        e.Protocol = protocol
+        if seen.After(e.LastSeen) {
+            e.LastSeen = seen
+        }
+        e.Frames++
+        e.Stale = false
+        e.Findings = checkLink(local, remote)
+        return
+    }
+    a.edgeIndex[key] = len(a.edges)
+    a.edges = append(a.edges, topology.Edge{
+        Local:     local,
+        Remote:    remote,
+        Protocol:  protocol,
+        FirstSeen: seen,
+        LastSeen:  seen,
+        Frames:    1,
+        Findings:  checkLink(local, remote),
+    })
+}
+
+// markStaleEdges flags every edge whose advertised TTL ran out before now.
+func (a *aggregator) markStaleEdges(now time.Time) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for i := range a.edges {
+        a.edges[i].Stale = edgeExpired(a.edges[i], now)
+    }
+}
+
+// expireEdges removes every edge whose advertised TTL ran out before now and
+// returns how many were removed. Used by daemon mode, where the table must track
+// the live fabric rather than everything ever seen.
+func (a *aggregator) expireEdges(now time.Time) int {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    var kept []topology.Edge
+    for _, e := range a.edges {
+        if !edgeExpired(e, now) {
+            kept = append(kept, e)
+        }
+    }
+    removed := len(a.edges) - len(kept)
+    if removed == 0 {
+        return 0
+    }
+    a.edges = kept
+    a.edgeIndex = make(map[edgeKey]int, len(a.edges))
+    for i, e := range a.edges {
+        a.edgeIndex[newEdgeKey(e.Local, e.Remote)] = i
+    }
+    return removed
+}
+
+// rawEdges returns a copy of the edges as recorded, in discovery order.
+func (a *aggregator) rawEdges() []topology.Edge {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    return append([]topology.Edge(nil), a.edges...)
+}
+
+// edgesSnapshot returns a copy of the current edges with staleness evaluated at now,
+// the learned IPs and spanning tree state attached and the LAG members bundled.
+func (a *aggregator) edgesSnapshot(now time.Time) []topology.Edge {
+    out := a.rawEdges()
+    for i := range out {
+        out[i].Stale = edgeExpired(out[i], now)
+    }
+    out = attachBindings(out, a.bindingsSnapshot())
+    out = attachSTP(out, a.stpSnapshot())
+    ports := a.lacpSnapshot()
+    out, _ = bundleLAGs(out, ports, localBonds(ports))
+    return out
+}
+
+// neighborsSnapshot returns the ARP, ND, CDP, LACP and STP neighbors sorted by their key.
+func (a *aggregator) neighborsSnapshot() []topology.Neighbor {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    keys := make([]string, 0, len(a.neighbors))
+    for k := range a.neighbors {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    out := make([]topology.Neighbor, 0, len(keys))
+    for _, k := range keys {
+        out = append(out, a.neighbors[k])
+    }
+    return out
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+    "context"
+    "net"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+    "github.com/gopacket/gopacket"
+    "github.com/gopacket/gopacket/layers"
+)
+
+// testAggregator returns an aggregator that is capturing on the given interfaces.
+func testAggregator(statuses ...topology.InterfaceStatus) *aggregator {
+    a := newAggregator(eventQueueLen)
+    for i := range statuses {
+        a.ifaces[statuses[i].Name] = &statuses[i]
+    }
+    return a
+}
+
+func TestAggregator(t *testing.T) {
+    a := testAggregator(topology.InterfaceStatus{Name: "ens1", State: ifaceStateCapturing})
+    go a.run()
+
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    local := topology.Node{Device: "gpu-1", Interface: "ens1"}
+    leaf01 := topology.Node{Device: "leaf01", Interface: "Ethernet1", ChassisID: "02:1c:73:00:00:01", TTL: 120}
+    mac, _ := net.ParseMAC("02:00:00:00:00:01")
+    for _, ev := range []frameEvent{
+        {Iface: "ens1", Time: t0, EtherType: 0x88cc, Edge: &edgeEvent{Protocol: protocolLLDP, Local: local, Remote: leaf01}},
+        {Iface: "ens1", Time: t0.Add(30 * time.Second), EtherType: 0x88cc, Edge: &edgeEvent{Protocol: protocolLLDP, Local: local, Remote: leaf01}},
+        {Iface: "ens1", Time: t0.Add(31 * time.Second), EtherType: 0x0806,
+            Binding:  &bindingEvent{IP: net.ParseIP("10.1.0.1"), MAC: mac, Source: bindingSourceARP},
+            Neighbor: &neighborEvent{Key: "ens1-ARP-02:00:00:00:00:01", Neighbor: topology.Neighbor{InterfaceName: "ens1", Protocol: "ARP"}}},
+        {Iface: "ens1", Time: t0.Add(32 * time.Second), EtherType: 0x88cc, Failed: protocolLLDP},
+        // Frames from interfaces that are not being captured on still make edges, but are not counted.
+        {Iface: "ens9", Time: t0, EtherType: 0x88cc, Edge: &edgeEvent{Protocol: protocolLLDP,
+            Local: topology.Node{Device: "gpu-1", Interface: "ens9"}, Remote: topology.Node{Device: "leaf02", Interface: "Ethernet1"}}},
+    } {
+        if !a.send(context.Background(), ev) {
+            t.Fatalf("send(%+v) dropped the event", ev)
+        }
+    }
+    a.stop()
+
+    edges := a.rawEdges()
+    if len(edges) != 2 || edges[0].Frames != 2 || !edges[0].LastSeen.Equal(t0.Add(30*time.Second)) || edges[1].Remote.Device != "leaf02" {
+        t.Errorf("edges = %+v, want leaf01 seen twice and leaf02", edges)
+    }
+    if n := a.neighborsSnapshot(); len(n) != 1 || n[0].Protocol != "ARP" {
+        t.Errorf("neighborsSnapshot() = %+v, want the ARP neighbor", n)
+    }
+    if b := a.bindingsSnapshot(); len(b) != 1 || b[0].IP != "10.1.0.1" {
+        t.Errorf("bindingsSnapshot() = %+v, want 10.1.0.1", b)
+    }
+
+    statuses := a.interfacesSnapshot()
+    if len(statuses) != 1 {
+        t.Fatalf("interfacesSnapshot() = %+v, want only ens1", statuses)
+    }
+    st := statuses[0]
+    if st.Packets != 4 || st.Decoded != 3 || st.Errors != 1 || st.Dropped != 0 ||
+        !st.FirstNeighbor.Equal(t0) || !st.LastPacket.Equal(t0.Add(32*time.Second)) {
+        t.Errorf("ens1 = %+v", st)
+    }
+    if want := map[string]uint64{"0x88cc": 3, "0x0806": 1}; !reflect.DeepEqual(st.PacketsByEtherType, want) {
+        t.Errorf("PacketsByEtherType = %v, want %v", st.PacketsByEtherType, want)
+    }
+    if want := map[string]uint64{protocolLLDP: 1}; !reflect.DeepEqual(st.ParseFailures, want) {
+        t.Errorf("ParseFailures = %v, want %v", st.ParseFailures, want)
+    }
+}
+
+func TestEdgeExpired(t *testing.T) {
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    tests := []struct {
+        name string
+        ttl  uint16
+        now  time.Time
+        want bool
+    }{
+        {"ttl 0 is a goodbye", 0, t0, true},
+        {"inside the ttl", 120, t0.Add(119 * time.Second), false},
+        {"at the ttl", 120, t0.Add(120 * time.Second), false},
+        {"past the ttl", 120, t0.Add(121 * time.Second), true},
+        {"clock behind the last frame", 120, t0.Add(-time.Minute), false},
+    }
+    for _, tt := range tests {
+        e := topology.Edge{Remote: topology.Node{TTL: tt.ttl}, FirstSeen: t0.Add(-time.Hour), LastSeen: t0}
+        if got := edgeExpired(e, tt.now); got != tt.want {
+            t.Errorf("%s: edgeExpired() = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestExpireEdges(t *testing.T) {
+    a := testAggregator()
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    local := topology.Node{Device: "gpu-1", Interface: "ens1"}
+    leaf := func(name string, ttl uint16) topology.Node {
+        return topology.Node{Device: name, Interface: "Ethernet1", ChassisID: name, TTL: ttl}
+    }
+    a.storeEdge(protocolLLDP, local, leaf("leaf01", 30), t0)                    // expires at t0+30s
+    a.storeEdge(protocolLLDP, local, leaf("leaf02", 120), t0)                   // still valid
+    a.storeEdge(protocolLLDP, local, leaf("leaf03", 0), t0.Add(10*time.Second)) // shutdown LLDPDU
+    a.storeEdge(protocolLLDP, local, leaf("leaf04", 120), t0.Add(50*time.Second))
+
+    if n := a.expireEdges(t0.Add(time.Minute)); n != 2 {
+        t.Errorf("expireEdges() = %d, want 2", n)
+    }
+    var got []string
+    for _, e := range a.rawEdges() {
+        got = append(got, e.Remote.Device)
+    }
+    if want := []string{"leaf02", "leaf04"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("edges after expiry = %v, want %v", got, want)
+    }
+    if n := a.expireEdges(t0.Add(time.Minute)); n != 0 {
+        t.Errorf("second expireEdges() = %d, want 0", n)
+    }
+
+    // The index is rebuilt: a refresh updates the kept edge, an expired one comes back new.
+    a.storeEdge(protocolLLDP, local, leaf("leaf04", 120), t0.Add(70*time.Second))
+    a.storeEdge(protocolLLDP, local, leaf("leaf01", 30), t0.Add(70*time.Second))
+    edges := a.rawEdges()
+    if len(edges) != 3 || edges[1].Remote.Device != "leaf04" || edges[1].Frames != 2 || edges[2].Remote.Device != "leaf01" || edges[2].Frames != 1 {
+        t.Errorf("edges after refresh = %+v", edges)
+    }
+}
+
+func TestExpireEdgesLoop(t *testing.T) {
+    a := testAggregator()
+    // Seen long ago, so expired by the wall clock the loop uses.
+    a.storeEdge(protocolLLDP, topology.Node{Device: "gpu-1", Interface: "ens1"},
+        topology.Node{Device: "leaf01", Interface: "Ethernet1", TTL: 120}, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+    ctx, cancel := context.WithCancel(context.Background())
+    done := make(chan struct{})
+    go func() {
+        expireEdgesLoop(ctx, a, time.Millisecond)
+        close(done)
+    }()
+    deadline := time.Now().Add(5 * time.Second)
+    for len(a.rawEdges()) > 0 && time.Now().Before(deadline) {
+        time.Sleep(time.Millisecond)
+    }
+    cancel()
+    <-done
+    if edges := a.rawEdges(); len(edges) != 0 {
+        t.Errorf("edges = %+v, want the expired edge removed", edges)
+    }
+}
+
+func TestAggregatorBackpressure(t *testing.T) {
+    a := newAggregator(1)
+    a.ifaces["ens1"] = &topology.InterfaceStatus{Name: "ens1", State: ifaceStateCapturing}
+    a.sendTimeout = 10 * time.Millisecond
+
+    // Nothing is draining the queue yet, so the second frame waits, then is dropped.
+    ev := frameEvent{Iface: "ens1", Time: time.Now()}
+    if !a.send(context.Background(), ev) {
+        t.Error("first send dropped the event")
+    }
+    if a.send(context.Background(), ev) {
+        t.Error("send to a full queue did not drop the event")
+    }
+    // A cancelled capture does not wait out the timeout.
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    a.sendTimeout = time.Hour
+    if a.send(ctx, ev) {
+        t.Error("cancelled send did not drop the event")
+    }
+
+    go a.run()
+    a.stop()
+    if st := a.interfacesSnapshot()[0]; st.Packets != 1 || st.Dropped != 2 {
+        t.Errorf("ens1 = %+v, want 1 frame and 2 dropped", st)
+    }
+}
+
+func TestRecordCaptureStats(t *testing.T) {
+    a := testAggregator(topology.InterfaceStatus{Name: "ens1", State: ifaceStateCapturing})
+    a.recordCaptureStats("ens1", captureStats{Received: 100, Dropped: 3, IfDropped: 1})
+    a.recordCaptureStats("ens2", captureStats{Received: 100})
+    statuses := a.interfacesSnapshot()
+    if len(statuses) != 1 || statuses[0].PcapReceived != 100 || statuses[0].PcapDropped != 3 || statuses[0].PcapIfDropped != 1 {
+        t.Errorf("interfacesSnapshot() = %+v", statuses)
+    }
+}
+
+func TestMalformedLLDPMakesNoEdge(t *testing.T) {
+    fakeSysfs(t, nil, nil)
+    a := testAggregator(topology.InterfaceStatus{Name: "ens1", State: ifaceStateCapturing})
+    for i := 0; i < 2; i++ {
+        a.processPacket("ens1", gopacket.NewPacket(lldpFrame(t, "leaf01", ""), layers.LayerTypeEthernet, gopacket.Default))
+    }
+    if edges := a.rawEdges(); len(edges) != 0 {
+        t.Errorf("edges = %+v, want none for LLDPDUs without a port ID", edges)
+    }
+    if st := a.interfacesSnapshot()[0]; st.Decoded != 0 || st.ParseFailures[protocolLLDP] != 2 {
+        t.Errorf("ens1 = %+v, want 2 LLDP parse failures and nothing decoded", st)
+    }
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "net"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/lacp"
+    "github.com/AMD-DC-GPU/ce/netgraph/lldp"
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+// Port anomaly checks, as recorded in topology.Finding.Check.
+const (
+    checkMultipleNeighbors = "multiple-neighbors"
+    checkLoop              = "loop"
+    checkNeighborFlap      = "neighbor-flap"
+)
+
+// ownFrameKey identifies frames sent by one of our interfaces (owner) heard on another (iface).
+type ownFrameKey struct {
+    iface string
+    owner string
+}
+
+// ownFrames counts the frames a captured interface heard from another local interface.
+type ownFrames struct {
+    Iface     string
+    Owner     string
+    MAC       string
+    FirstSeen time.Time
+    LastSeen  time.Time
+    Frames    int
+    Protocols []string
+}
+
+// noteOwnFrameLocked records ev if it was sent by another captured interface: a host hearing
+// its own frames is looped, or two of its ports are cabled to each other. Only link-local
+// protocols that bridges do not forward count; two ports in one broadcast domain always hear
+// each other's ARP and ND. Interfaces sharing a MAC (bond members) can't be told apart and
+// are not checked. a.mu must be held.
+func (a *aggregator) noteOwnFrameLocked(ev frameEvent, st *topology.InterfaceStatus) {
+    if ev.SrcMAC == "" || ev.SrcMAC == st.MAC || !linkLocalEtherType(ev.EtherType) {
+        return
+    }
+    owner := ""
+    for name, other := range a.ifaces {
+        if name != ev.Iface && other.MAC == ev.SrcMAC && (owner == "" || name < owner) {
+            owner = name
+        }
+    }
+    if owner == "" {
+        return
+    }
+    key := ownFrameKey{iface: ev.Iface, owner: owner}
+    o, ok := a.ownFrames[key]
+    if !ok {
+        o = &ownFrames{Iface: ev.Iface, Owner: owner, MAC: ev.SrcMAC, FirstSeen: ev.Time}
+        a.ownFrames[key] = o
+    }
+    if ev.Time.After(o.LastSeen) {
+        o.LastSeen = ev.Time
+    }
+    o.Frames++
+    o.Protocols = appendUnique(o.Protocols, etherTypeName(ev.EtherType))
+}
+
+// ownFramesSnapshot returns a copy of the own-frame sightings sorted by interface and owner.
+func (a *aggregator) ownFramesSnapshot() []ownFrames {
+    a.mu.Lock()
+    out := make([]ownFrames, 0, len(a.ownFrames))
+    for _, o := range a.ownFrames {
+        c := *o
+        c.Protocols = append([]string(nil), o.Protocols...)
+        out = append(out, c)
+    }
+    a.mu.Unlock()
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Iface != out[j].Iface {
+            return out[i].Iface < out[j].Iface
+        }
+        return out[i].Owner < out[j].Owner
+    })
+    return out
+}
+
+// linkLocalEtherType reports whether frames of etherType stay on one link: LLDP, LACP and
+// STP are sent to reserved addresses that bridges do not forward.
+func linkLocalEtherType(etherType uint16) bool {
+    switch etherType {
+    case lldp.EtherType, lacp.EtherType, stpEtherType:
+        return true
+    }
+    return false
+}
+
+// etherTypeName names the EtherTypes netgraph captures, for evidence.
+func etherTypeName(etherType uint16) string {
+    switch etherType {
+    case lldp.EtherType:
+        return protocolLLDP
+    case cdpEtherType:
+        return protocolCDP
+    case arpEtherType:
+        return protocolARP
+    case ipv6EtherType:
+        return protocolND
+    case lacp.EtherType:
+        return protocolLACP
+    case stpEtherType:
+        return protocolSTP
+    }
+    return etherTypeKey(etherType)
+}
+
+// portFindings checks what every local port heard: frames from our own interfaces (a loop),
+// LLDP/CDP from more than one chassis at once (an unmanaged switch or a patching mistake), and
+// a neighbor that changed during the capture (flapping or re-cabling).
+func portFindings(edges []topology.Edge, own []ownFrames) []topology.Finding {
+    var findings []topology.Finding
+    looped := make(map[ownFrameKey]bool)
+    for _, o := range own {
+        looped[ownFrameKey{iface: o.Iface, owner: o.Owner}] = true
+        findings = append(findings, loopFinding(o.Iface, o.Owner, fmt.Sprintf("%d %s frame(s) from %s between %s and %s",
+            o.Frames, strings.Join(o.Protocols, "/"), o.MAC, o.FirstSeen.Format(time.RFC3339), o.LastSeen.Format(time.RFC3339))))
+    }
+
+    byPort := make(map[string][]topology.Edge)
+    for _, e := range edges {
+        if e.Remote.Device == e.Local.Device {
+            // Our own advertisement came back. Without the sender's MAC (a replayed file) this
+            // is the only sign of a loop; the same port is most likely our own outgoing frame.
+            key := ownFrameKey{iface: e.Local.Interface, owner: e.Remote.Interface}
+            if e.Remote.Interface != e.Local.Interface && !looped[key] {
+                looped[key] = true
+                findings = append(findings, loopFinding(key.iface, key.owner, fmt.Sprintf("our %s advertisement for %s heard on %s: %s",
+                    e.Protocol, e.Remote.Interface, e.Local.Interface, neighborEvidence(e))))
+            }
+            continue
+        }
+        byPort[e.Local.Interface] = append(byPort[e.Local.Interface], e)
+    }
+
+    ports := make([]string, 0, len(byPort))
+    for p := range byPort {
+        ports = append(ports, p)
+    }
+    sort.Strings(ports)
+    for _, port := range ports {
+        heard := byPort[port]
+        if len(heard) < 2 {
+            continue
+        }
+        sort.SliceStable(heard, func(i, j int) bool { return heard[i].FirstSeen.Before(heard[j].FirstSeen) })
+        var evidence, devices []string
+        neighbors := 0
+        overlap := false
+        for i, e := range heard {
+            evidence = append(evidence, fmt.Sprintf("%s %s: %s", e.Remote.Device, e.Remote.Interface, neighborEvidence(e)))
+            devices = appendUnique(devices, e.Remote.Device)
+            known := false
+            for _, prev := range heard[:i] {
+                same := sameRemoteSystem(prev.Remote, e.Remote)
+                // LLDP and CDP may name the same port differently.
+                if same && (prev.Remote.Interface == e.Remote.Interface || prev.Protocol != e.Protocol) {
+                    known = true
+                }
+                if !same && !e.FirstSeen.After(prev.LastSeen) {
+                    overlap = true
+                }
+            }
+            if !known {
+                neighbors++
+            }
+        }
+        if neighbors < 2 {
+            continue
+        }
+        if overlap {
+            findings = append(findings, topology.Finding{
+                Check:    checkMultipleNeighbors,
+                Severity: topology.SeverityError,
+                Message: fmt.Sprintf("%s hears %d neighbors at the same time (%s): an unmanaged switch or a patching mistake",
+                    port, neighbors, strings.Join(devices, ", ")),
+                Local:    port,
+                Remote:   strings.Join(devices, ","),
+                Evidence: evidence,
+            })
+            continue
+        }
+        findings = append(findings, topology.Finding{
+            Check:    checkNeighborFlap,
+            Severity: topology.SeverityWarning,
+            Message:  fmt.Sprintf("the neighbor of %s changed %d time(s) during the capture", port, neighbors-1),
+            Local:    port,
+            Remote:   strings.Join(devices, ","),
+            Evidence: evidence,
+        })
+    }
+    return findings
+}
+
+// sameRemoteSystem reports whether two neighbors heard on one port are the same system. The
+// dedup key uses the LLDP chassis ID but the CDP Device ID, so a switch speaking both is
+// matched by the source MAC of its frames or by its name; a CDP Device ID often adds the
+// domain or serial number, as in "leaf01.example.com" or "leaf01(FDO2130X0AB)".
+func sameRemoteSystem(a, b topology.Node) bool {
+    if newEdgeKey(topology.Node{}, a).remoteChassis == newEdgeKey(topology.Node{}, b).remoteChassis {
+        return true
+    }
+    if a.MAC != "" && a.MAC == b.MAC {
+        return true
+    }
+    nameA, nameB := shortSystemName(a.Device), shortSystemName(b.Device)
+    return nameA != "" && nameA == nameB
+}
+
+// shortSystemName strips the serial number and domain from a system name, lowercased.
+// Addresses, used when a system sends no name, are kept whole.
+func shortSystemName(name string) string {
+    if net.ParseIP(name) != nil {
+        return name
+    }
+    name, _, _ = strings.Cut(name, "(")
+    name, _, _ = strings.Cut(name, ".")
+    return strings.ToLower(strings.TrimSpace(name))
+}
+
+// loopFinding reports that port heard frames sent by our own interface owner.
+func loopFinding(port, owner string, evidence string) topology.Finding {
+    msg := fmt.Sprintf("%s hears frames sent by this host's %s: a loop, or the two ports are cabled to each other", port, owner)
+    if port == owner {
+        msg = fmt.Sprintf("%s hears its own frames: the link is looped back", port)
+    }
+    return topology.Finding{
+        Check:    checkLoop,
+        Severity: topology.SeverityError,
+        Message:  msg,
+        Local:    port,
+        Remote:   owner,
+        Evidence: []string{evidence},
+    }
+}
+
+// neighborEvidence summarizes when and how often a link was advertised.
+func neighborEvidence(e topology.Edge) string {
+    chassis := newEdgeKey(e.Local, e.Remote).remoteChassis
+    return fmt.Sprintf("chassis %s, %d frame(s) between %s and %s",
+        chassis, e.Frames, e.FirstSeen.Format(time.RFC3339), e.LastSeen.Format(time.RFC3339))
+}
+
+// printPortFindings prints the port anomalies with their evidence.
+func printPortFindings(w io.Writer, findings []topology.Finding) {
+    if len(findings) == 0 {
+        fmt.Fprint(w, "Port anomalies: no multiple neighbors, loops or neighbor changes\n\n")
+        return
+    }
+    fmt.Fprintf(w, "Port anomalies (%d):\n", len(findings))
+    for _, f := range findings {
+        fmt.Fprintf(w, "  %s %s: %s\n", strings.ToUpper(f.Severity), f.Check, f.Message)
+        for _, ev := range f.Evidence {
+            fmt.Fprintf(w, "      %s\n", ev)
+        }
+    }
+    fmt.Fprintln(w)
+}
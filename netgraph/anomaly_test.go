@@ -0,0 +1,126 @@
+package main
+
+import (
+    "bytes"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/lacp"
+    "github.com/AMD-DC-GPU/ce/netgraph/lldp"
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+func TestPortFindings(t *testing.T) {
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    edge := func(port, device, remotePort, chassis string, first, last time.Duration, frames int) topology.Edge {
+        return topology.Edge{
+            Local:     topology.Node{Device: "gpu-1", Interface: port},
+            Remote:    topology.Node{Device: device, Interface: remotePort, ChassisID: chassis},
+            Protocol:  protocolLLDP,
+            FirstSeen: t0.Add(first),
+            LastSeen:  t0.Add(last),
+            Frames:    frames,
+        }
+    }
+    cdpEdge := func(port, device, remotePort, mac string, first, last time.Duration) topology.Edge {
+        return topology.Edge{
+            Local:     topology.Node{Device: "gpu-1", Interface: port},
+            Remote:    topology.Node{Device: device, Interface: remotePort, MAC: mac},
+            Protocol:  protocolCDP,
+            FirstSeen: t0.Add(first),
+            LastSeen:  t0.Add(last),
+            Frames:    4,
+        }
+    }
+    edges := []topology.Edge{
+        // ens1 is fine.
+        edge("ens1", "leaf01", "Ethernet1", "02:1c:73:00:00:01", 0, 90*time.Second, 4),
+        // ens2 sits behind an unmanaged switch that passes LLDP from two leaves.
+        edge("ens2", "leaf01", "Ethernet2", "02:1c:73:00:00:01", 0, 90*time.Second, 4),
+        edge("ens2", "leaf02", "Ethernet2", "02:1c:73:00:00:02", 10*time.Second, 70*time.Second, 3),
+        // ens3 was re-patched from leaf01 to leaf02 during the capture.
+        edge("ens3", "leaf01", "Ethernet3", "02:1c:73:00:00:01", 0, 30*time.Second, 2),
+        edge("ens3", "leaf02", "Ethernet3", "02:1c:73:00:00:02", 60*time.Second, 90*time.Second, 2),
+        // ens4 hears our own advertisement sent on ens5 (from a replayed file: no MAC sighting).
+        edge("ens4", "gpu-1", "ens5", "gpu-1-machine-id", 0, 60*time.Second, 3),
+        // ens6 hears our advertisement for ens7 and our LACPDUs from it; reported once.
+        edge("ens6", "gpu-1", "ens7", "gpu-1-machine-id", 0, 60*time.Second, 3),
+        // ens9 hears LLDP and CDP from one Nexus switch, which name its port differently.
+        edge("ens9", "leaf03", "Ethernet1/9", "00:3a:9c:00:00:03", 0, 90*time.Second, 4),
+        cdpEdge("ens9", "leaf03.example.com(FDO2130X0AB)", "Eth1/9", "00:3a:9c:00:01:09", 5*time.Second, 95*time.Second),
+        // ens10 hears LLDP from leaf01 and CDP from a Cisco switch behind an unmanaged one.
+        edge("ens10", "leaf01", "Ethernet10", "02:1c:73:00:00:01", 0, 90*time.Second, 4),
+        cdpEdge("ens10", "access01.example.com", "GigabitEthernet1/0/10", "00:3a:9c:00:02:0a", 5*time.Second, 95*time.Second),
+        // The same port: our own outgoing frame in a replayed file.
+        edge("ens8", "gpu-1", "ens8", "gpu-1-machine-id", 0, 60*time.Second, 3),
+    }
+    own := []ownFrames{{Iface: "ens6", Owner: "ens7", MAC: "02:00:00:00:00:07", FirstSeen: t0, LastSeen: t0.Add(time.Minute),
+        Frames: 5, Protocols: []string{protocolLLDP, protocolLACP}}}
+
+    findings := portFindings(edges, own)
+    var got []string
+    for _, f := range findings {
+        got = append(got, f.Check+" "+f.Local+" "+f.Remote)
+    }
+    want := []string{
+        "loop ens6 ens7",
+        "loop ens4 ens5",
+        "multiple-neighbors ens10 leaf01,access01.example.com",
+        "multiple-neighbors ens2 leaf01,leaf02",
+        "neighbor-flap ens3 leaf01,leaf02",
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Fatalf("portFindings() = %v, want %v", got, want)
+    }
+    if ev := findings[0].Evidence; len(ev) != 1 || ev[0] != "5 lldp/lacp frame(s) from 02:00:00:00:00:07 between 2024-05-01T12:00:00Z and 2024-05-01T12:01:00Z" {
+        t.Errorf("loop evidence = %q", ev)
+    }
+    flap := findings[4]
+    if flap.Severity != topology.SeverityWarning || len(flap.Evidence) != 2 ||
+        flap.Evidence[1] != "leaf02 Ethernet3: chassis 02:1c:73:00:00:02, 2 frame(s) between 2024-05-01T12:01:00Z and 2024-05-01T12:01:30Z" {
+        t.Errorf("flap = %+v", flap)
+    }
+
+    var buf bytes.Buffer
+    printPortFindings(&buf, findings)
+    if line := "ERROR multiple-neighbors: ens2 hears 2 neighbors at the same time (leaf01, leaf02)"; !strings.Contains(buf.String(), line) {
+        t.Errorf("report does not contain %q:\n%s", line, buf.String())
+    }
+}
+
+func TestOwnFrames(t *testing.T) {
+    a := testAggregator(
+        topology.InterfaceStatus{Name: "ens1", MAC: "02:00:00:00:00:01", State: ifaceStateCapturing},
+        topology.InterfaceStatus{Name: "ens2", MAC: "02:00:00:00:00:02", State: ifaceStateCapturing},
+        // Bond members share the bond's MAC.
+        topology.InterfaceStatus{Name: "ens3", MAC: "02:00:00:00:00:33", State: ifaceStateCapturing},
+        topology.InterfaceStatus{Name: "ens4", MAC: "02:00:00:00:00:33", State: ifaceStateCapturing},
+    )
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    self := func(iface, port, mac string, at time.Duration) frameEvent {
+        return frameEvent{Iface: iface, Time: t0.Add(at), EtherType: lldp.EtherType, SrcMAC: mac, Edge: &edgeEvent{
+            Protocol: protocolLLDP,
+            Local:    topology.Node{Device: "gpu-1", Interface: iface},
+            Remote:   topology.Node{Device: "gpu-1", Interface: port, ChassisID: "gpu-1-machine-id", MAC: mac, TTL: 120},
+        }}
+    }
+    a.apply(self("ens1", "ens1", "02:00:00:00:00:01", 0))           // captured on the way out
+    a.apply(self("ens1", "ens2", "02:00:00:00:00:02", 0))           // ens1 and ens2 are cabled together
+    a.apply(self("ens1", "ens2", "02:00:00:00:00:02", time.Minute)) // ...
+    a.apply(frameEvent{Iface: "ens1", Time: t0.Add(time.Minute), EtherType: lacp.EtherType, SrcMAC: "02:00:00:00:00:02"})
+    // ARP and ND cross bridges: ports in one broadcast domain hear each other.
+    a.apply(frameEvent{Iface: "ens1", Time: t0.Add(2 * time.Minute), EtherType: arpEtherType, SrcMAC: "02:00:00:00:00:02"})
+    a.apply(frameEvent{Iface: "ens2", Time: t0.Add(2 * time.Minute), EtherType: ipv6EtherType, SrcMAC: "02:00:00:00:00:01"})
+    a.apply(self("ens4", "ens3", "02:00:00:00:00:33", 0)) // flooded back over a bond: can't tell
+
+    if edges := a.rawEdges(); len(edges) != 1 || edges[0].Remote.Interface != "ens2" || edges[0].Frames != 2 {
+        t.Errorf("edges = %+v, want only ens1 <- ens2", edges)
+    }
+    want := []ownFrames{{Iface: "ens1", Owner: "ens2", MAC: "02:00:00:00:00:02", FirstSeen: t0, LastSeen: t0.Add(time.Minute),
+        Frames: 3, Protocols: []string{protocolLLDP, protocolLACP}}}
+    if got := a.ownFramesSnapshot(); !reflect.DeepEqual(got, want) {
+        t.Errorf("ownFramesSnapshot() = %+v, want %+v", got, want)
+    }
+}
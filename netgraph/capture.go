@@ -0,0 +1,120 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "net"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/stp"
+    "github.com/gopacket/gopacket"
+    "github.com/gopacket/gopacket/layers"
+    "golang.org/x/net/bpf"
+)
+
+// Capture backends, chosen with -capture.
+const (
+    captureBackendPcap     = "pcap"     // libpcap through cgo
+    captureBackendAFPacket = "afpacket" // Linux AF_PACKET raw sockets, no cgo or libpcap needed
+)
+
+// captureSnaplen is how much of each frame a capture keeps.
+const captureSnaplen = 65535
+
+// captureReadTimeout bounds how long a read blocks, so captures notice cancellation.
+const captureReadTimeout = time.Second
+
+// captureFilter selects LLDP (0x88cc), CDP, ARP (0x0806), LACP (0x8809), IPv6 neighbor discovery and STP.
+// CDP is an 802.3 LLC/SNAP frame to 01:00:0c:cc:cc:cc with SNAP protocol ID 0x2000,
+// so it has to be matched on the destination MAC and SNAP header rather than EtherType.
+// ICMPv6 types 133-136 are router solicitation/advertisement and neighbor solicitation/advertisement.
+// STP, RSTP and MSTP BPDUs are 802.3 LLC frames to 01:80:c2:00:00:00 with DSAP and SSAP 0x42.
+const captureFilter = "ether proto 0x88cc or ether proto 0x0806 or ether proto 0x8809 or (ether dst 01:00:0c:cc:cc:cc and ether[20:2] = 0x2000)" +
+    " or (icmp6 and ip6[40] >= 133 and ip6[40] <= 136) or (ether dst 01:80:c2:00:00:00 and ether[14:2] = 0x4242)"
+
+// captureFilterProgram is captureFilter as a classic BPF program, for backends that can't
+// compile filter expressions without libpcap.
+var captureFilterProgram = []bpf.Instruction{
+    /* 0 */ bpf.LoadAbsolute{Off: 12, Size: 2}, // EtherType
+    /* 1 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x88cc, SkipTrue: 19},
+    /* 2 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: arpEtherType, SkipTrue: 18},
+    /* 3 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x8809, SkipTrue: 17},
+    /* 4 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: ipv6EtherType, SkipFalse: 5},
+    /* 5 */ bpf.LoadAbsolute{Off: 20, Size: 1}, // IPv6 next header
+    /* 6 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(layers.IPProtocolICMPv6), SkipFalse: 15},
+    /* 7 */ bpf.LoadAbsolute{Off: 54, Size: 1}, // ICMPv6 type
+    /* 8 */ bpf.JumpIf{Cond: bpf.JumpGreaterOrEqual, Val: 133, SkipFalse: 13},
+    /* 9 */ bpf.JumpIf{Cond: bpf.JumpLessOrEqual, Val: 136, SkipTrue: 11, SkipFalse: 12},
+    /* 10 */ bpf.LoadAbsolute{Off: 0, Size: 4}, // destination MAC 01:00:0c:cc:cc:cc
+    /* 11 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x01000ccc, SkipFalse: 4},
+    /* 12 */ bpf.LoadAbsolute{Off: 4, Size: 2},
+    /* 13 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0xcccc, SkipFalse: 8},
+    /* 14 */ bpf.LoadAbsolute{Off: 20, Size: 2}, // SNAP protocol ID
+    /* 15 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: cdpEtherType, SkipTrue: 5, SkipFalse: 6},
+    /* 16 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x0180c200, SkipFalse: 5}, // destination MAC 01:80:c2:00:00:00
+    /* 17 */ bpf.LoadAbsolute{Off: 4, Size: 2},
+    /* 18 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: 0x0000, SkipFalse: 3},
+    /* 19 */ bpf.LoadAbsolute{Off: 14, Size: 2}, // LLC DSAP and SSAP
+    /* 20 */ bpf.JumpIf{Cond: bpf.JumpEqual, Val: stp.LLCSAP<<8 | stp.LLCSAP, SkipFalse: 1},
+    /* 21 */ bpf.RetConstant{Val: captureSnaplen},
+    /* 22 */ bpf.RetConstant{Val: 0},
+}
+
+// errReadTimeout is returned by packetSource.ReadPacketData when no frame arrived
+// within captureReadTimeout.
+var errReadTimeout = errors.New("read timeout")
+
+// captureStats are the receive and drop counters of a packet source.
+type captureStats struct {
+    Received  int // frames that passed the filter
+    Dropped   int // frames dropped for lack of buffer space
+    IfDropped int // frames dropped by the interface (pcap only)
+}
+
+// sourceOptions configure a packetSource.
+type sourceOptions struct {
+    Snaplen      int  // bytes of each frame to keep
+    Promisc      bool // put the interface into promiscuous mode
+    Filter       bool // only receive what captureFilter selects
+    InboundOnly  bool // skip frames we sent ourselves
+    TransmitOnly bool // the source is only written to; backends may not receive at all
+}
+
+// packetSource reads frames from and writes frames to one interface.
+type packetSource interface {
+    ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+    WritePacketData(data []byte) error
+    LinkType() layers.LinkType
+    Stats() (captureStats, error)
+    Close()
+}
+
+// captureBackend is the backend openPacketSource uses, set with -capture.
+var captureBackend = defaultCaptureBackend
+
+// openPacketSource opens the selected capture backend on an interface; tests replace it with a fake.
+var openPacketSource = func(name string, opts sourceOptions) (packetSource, error) {
+    switch captureBackend {
+    case captureBackendPcap:
+        return openPcapSource(name, opts)
+    case captureBackendAFPacket:
+        return openAFPacketSource(name, opts)
+    }
+    return nil, fmt.Errorf("unknown capture backend %q", captureBackend)
+}
+
+// captureDevices lists the interfaces the selected capture backend can capture on.
+func captureDevices() ([]string, error) {
+    if captureBackend == captureBackendPcap {
+        return pcapDevices()
+    }
+    ifaces, err := net.Interfaces()
+    if err != nil {
+        return nil, err
+    }
+    var names []string
+    for _, iface := range ifaces {
+        names = append(names, iface.Name)
+    }
+    return names, nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "net"
+    "time"
+
+    "github.com/gopacket/gopacket"
+    "github.com/gopacket/gopacket/layers"
+    "golang.org/x/net/bpf"
+    "golang.org/x/sys/unix"
+)
+
+// afPacketSource is a packetSource backed by an AF_PACKET raw socket bound to one interface.
+type afPacketSource struct {
+    fd          int
+    ifIndex     int
+    inboundOnly bool
+    buf         []byte
+    // stats accumulates PACKET_STATISTICS, which the kernel resets on every read.
+    stats captureStats
+}
+
+// htons converts a 16-bit value to network byte order, as AF_PACKET protocols are given.
+func htons(v uint16) uint16 {
+    var b [2]byte
+    binary.BigEndian.PutUint16(b[:], v)
+    return binary.NativeEndian.Uint16(b[:])
+}
+
+// openAFPacketSource opens an AF_PACKET socket on name. The filter is attached before the
+// socket is bound, so no unfiltered frames from other interfaces queue up in between.
+func openAFPacketSource(name string, opts sourceOptions) (packetSource, error) {
+    iface, err := net.InterfaceByName(name)
+    if err != nil {
+        return nil, err
+    }
+    fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+    if err != nil {
+        return nil, fmt.Errorf("AF_PACKET socket: %w", err)
+    }
+    s := &afPacketSource{fd: fd, ifIndex: iface.Index, inboundOnly: opts.InboundOnly, buf: make([]byte, opts.Snaplen)}
+    if err := s.setup(opts); err != nil {
+        unix.Close(fd)
+        return nil, err
+    }
+    return s, nil
+}
+
+func (s *afPacketSource) setup(opts sourceOptions) error {
+    if opts.Filter {
+        raw, err := bpf.Assemble(captureFilterProgram)
+        if err != nil {
+            return fmt.Errorf("assembling BPF filter: %w", err)
+        }
+        filter := make([]unix.SockFilter, len(raw))
+        for i, ins := range raw {
+            filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+        }
+        prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+        if err := unix.SetsockoptSockFprog(s.fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog); err != nil {
+            return fmt.Errorf("attaching BPF filter: %w", err)
+        }
+    }
+    // Binding to protocol 0 sends without receiving anything.
+    var protocol uint16
+    if !opts.TransmitOnly {
+        protocol = htons(unix.ETH_P_ALL)
+    }
+    if err := unix.Bind(s.fd, &unix.SockaddrLinklayer{Protocol: protocol, Ifindex: s.ifIndex}); err != nil {
+        return fmt.Errorf("binding AF_PACKET socket: %w", err)
+    }
+    if opts.Promisc {
+        mreq := unix.PacketMreq{Ifindex: int32(s.ifIndex), Type: unix.PACKET_MR_PROMISC}
+        if err := unix.SetsockoptPacketMreq(s.fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+            return fmt.Errorf("enabling promiscuous mode: %w", err)
+        }
+    }
+    tv := unix.NsecToTimeval(captureReadTimeout.Nanoseconds())
+    if err := unix.SetsockoptTimeval(s.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+        return fmt.Errorf("setting read timeout: %w", err)
+    }
+    return nil
+}
+
+func (s *afPacketSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+    for {
+        n, from, err := unix.Recvfrom(s.fd, s.buf, unix.MSG_TRUNC)
+        if err != nil {
+            // ENETDOWN is reported once when the link goes down; the socket stays bound
+            // and receives again once it comes back up.
+            if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) || errors.Is(err, unix.ENETDOWN) {
+                return nil, gopacket.CaptureInfo{}, errReadTimeout
+            }
+            return nil, gopacket.CaptureInfo{}, err
+        }
+        if ll, ok := from.(*unix.SockaddrLinklayer); ok && s.inboundOnly && ll.Pkttype == unix.PACKET_OUTGOING {
+            continue
+        }
+        ci := gopacket.CaptureInfo{
+            Timestamp:      time.Now(),
+            CaptureLength:  min(n, len(s.buf)),
+            Length:         n,
+            InterfaceIndex: s.ifIndex,
+        }
+        data := make([]byte, ci.CaptureLength)
+        copy(data, s.buf)
+        return data, ci, nil
+    }
+}
+
+func (s *afPacketSource) WritePacketData(data []byte) error {
+    if len(data) < 14 {
+        return fmt.Errorf("frame too short (%d bytes)", len(data))
+    }
+    to := &unix.SockaddrLinklayer{
+        Protocol: htons(binary.BigEndian.Uint16(data[12:14])),
+        Ifindex:  s.ifIndex,
+        Halen:    6,
+    }
+    copy(to.Addr[:], data[:6])
+    return unix.Sendto(s.fd, data, 0, to)
+}
+
+// LinkType is always Ethernet: AF_PACKET raw sockets hand out frames with their link-layer header.
+func (s *afPacketSource) LinkType() layers.LinkType {
+    return layers.LinkTypeEthernet
+}
+
+func (s *afPacketSource) Stats() (captureStats, error) {
+    st, err := unix.GetsockoptTpacketStats(s.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+    if err != nil {
+        return s.stats, err
+    }
+    s.stats.Received += int(st.Packets)
+    s.stats.Dropped += int(st.Drops)
+    return s.stats, nil
+}
+
+func (s *afPacketSource) Close() {
+    unix.Close(s.fd)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// openAFPacketSource is only implemented on Linux.
+func openAFPacketSource(name string, opts sourceOptions) (packetSource, error) {
+    return nil, errors.New("the afpacket capture backend is only available on Linux")
+}
@@ -0,0 +1,18 @@
+//go:build !cgo
+
+package main
+
+import "errors"
+
+// defaultCaptureBackend is AF_PACKET, since libpcap needs cgo.
+const defaultCaptureBackend = captureBackendAFPacket
+
+var errNoPcap = errors.New("netgraph was built without cgo, so without libpcap; use -capture afpacket")
+
+func openPcapSource(name string, opts sourceOptions) (packetSource, error) {
+    return nil, errNoPcap
+}
+
+func pcapDevices() ([]string, error) {
+    return nil, errNoPcap
+}
@@ -0,0 +1,67 @@
+//go:build cgo
+
+package main
+
+import (
+    "log"
+
+    "github.com/gopacket/gopacket"
+    "github.com/gopacket/gopacket/pcap"
+)
+
+// defaultCaptureBackend is libpcap whenever it can be linked in.
+const defaultCaptureBackend = captureBackendPcap
+
+// pcapSource is a packetSource backed by a libpcap handle.
+type pcapSource struct {
+    *pcap.Handle
+}
+
+// openPcapSource opens a libpcap handle on name.
+func openPcapSource(name string, opts sourceOptions) (packetSource, error) {
+    handle, err := pcap.OpenLive(name, int32(opts.Snaplen), opts.Promisc, captureReadTimeout)
+    if err != nil {
+        return nil, err
+    }
+    if opts.Filter {
+        if err := handle.SetBPFFilter(captureFilter); err != nil {
+            handle.Close()
+            return nil, err
+        }
+    }
+    if opts.InboundOnly {
+        if err := handle.SetDirection(pcap.DirectionIn); err != nil {
+            log.Printf("SetDirection failed on %s: %v", name, err)
+        }
+    }
+    return pcapSource{handle}, nil
+}
+
+func (s pcapSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+    data, ci, err := s.Handle.ReadPacketData()
+    if err == pcap.NextErrorTimeoutExpired {
+        err = errReadTimeout
+    }
+    return data, ci, err
+}
+
+func (s pcapSource) Stats() (captureStats, error) {
+    stats, err := s.Handle.Stats()
+    if err != nil {
+        return captureStats{}, err
+    }
+    return captureStats{Received: stats.PacketsReceived, Dropped: stats.PacketsDropped, IfDropped: stats.PacketsIfDropped}, nil
+}
+
+// pcapDevices lists the devices libpcap can capture on.
+func pcapDevices() ([]string, error) {
+    devices, err := pcap.FindAllDevs()
+    if err != nil {
+        return nil, err
+    }
+    var names []string
+    for _, dev := range devices {
+        names = append(names, dev.Name)
+    }
+    return names, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "io"
+    "net"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/lacp"
+    "github.com/AMD-DC-GPU/ce/netgraph/lldp"
+    "github.com/gopacket/gopacket"
+    "github.com/gopacket/gopacket/layers"
+    "golang.org/x/net/bpf"
+)
+
+// fakeSource is a packetSource that replays frames, timing out between them, then reports err.
+type fakeSource struct {
+    frames    [][]byte
+    err       error // returned once the frames are used up
+    reads     int
+    delivered int
+    closed    bool
+}
+
+func (s *fakeSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+    s.reads++
+    if s.reads%2 == 1 {
+        return nil, gopacket.CaptureInfo{}, errReadTimeout
+    }
+    if len(s.frames) == 0 {
+        return nil, gopacket.CaptureInfo{}, s.err
+    }
+    data := s.frames[0]
+    s.frames = s.frames[1:]
+    s.delivered++
+    ts := time.Date(2024, 5, 1, 12, 0, s.reads, 0, time.UTC)
+    return data, gopacket.CaptureInfo{Timestamp: ts, CaptureLength: len(data), Length: len(data)}, nil
+}
+
+func (s *fakeSource) WritePacketData(data []byte) error { return nil }
+func (s *fakeSource) LinkType() layers.LinkType         { return layers.LinkTypeEthernet }
+func (s *fakeSource) Close()                            { s.closed = true }
+
+func (s *fakeSource) Stats() (captureStats, error) {
+    return captureStats{Received: s.delivered, Dropped: 1}, nil
+}
+
+// useSource makes openPacketSource hand out src (or fail with err) for a test.
+func useSource(t *testing.T, src packetSource, err error) {
+    old := openPacketSource
+    openPacketSource = func(name string, opts sourceOptions) (packetSource, error) {
+        if !opts.Filter || !opts.Promisc || opts.Snaplen != captureSnaplen {
+            t.Errorf("openPacketSource(%s, %+v): want a filtered promiscuous capture", name, opts)
+        }
+        return src, err
+    }
+    t.Cleanup(func() { openPacketSource = old })
+}
+
+func lldpFrame(t *testing.T, sysName, port string) []byte {
+    t.Helper()
+    mac, _ := net.ParseMAC("02:1c:73:00:00:01")
+    return lldp.BuildFrame(mac, lldp.Advertisement{
+        ChassisIDSubtype: lldp.ChassisIDSubtypeMACAddress,
+        ChassisID:        mac,
+        PortID:           port,
+        SystemName:       sysName,
+        TTL:              120,
+    })
+}
+
+func TestCapturePackets(t *testing.T) {
+    fakeSysfs(t, nil, nil)
+    a := testAggregator()
+    go a.run()
+
+    src := &fakeSource{
+        frames: [][]byte{
+            lldpFrame(t, "leaf01", "Ethernet1"),
+            arpPacket(t, layers.ARPRequest, "02:00:00:00:00:01", "10.1.0.1", "10.1.0.9", time.Time{}).Data(),
+        },
+        err: io.EOF,
+    }
+    useSource(t, src, nil)
+    capturePackets(context.Background(), a, "ens1")
+    a.stop()
+
+    if !src.closed {
+        t.Error("source was not closed")
+    }
+    st := a.interfacesSnapshot()
+    if len(st) != 1 || st[0].State != ifaceStateStopped || st[0].Packets != 2 || st[0].Decoded != 2 || st[0].PcapReceived != 2 || st[0].PcapDropped != 1 ||
+        st[0].PacketsByEtherType["0x88cc"] != 1 || st[0].PacketsByEtherType["0x0806"] != 1 {
+        t.Errorf("interfacesSnapshot() = %+v", st)
+    }
+    got := a.rawEdges()
+    if len(got) != 1 || got[0].Remote.Device != "leaf01" || got[0].Remote.Interface != "Ethernet1" || got[0].Local.Interface != "ens1" {
+        t.Errorf("edges = %+v, want one to leaf01 Ethernet1", got)
+    }
+    if len(a.bindingsSnapshot()) != 1 {
+        t.Errorf("bindingsSnapshot() = %+v, want the ARP sender", a.bindingsSnapshot())
+    }
+}
+
+func TestCapturePacketsErrors(t *testing.T) {
+    fakeSysfs(t, nil, nil)
+    a := testAggregator()
+    go a.run()
+
+    useSource(t, nil, errors.New("no such device"))
+    capturePackets(context.Background(), a, "ens1")
+    useSource(t, &fakeSource{err: errors.New("network is down")}, nil)
+    capturePackets(context.Background(), a, "ens2")
+
+    // A cancelled capture stops at the next read timeout.
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    src := &fakeSource{frames: [][]byte{lldpFrame(t, "leaf01", "Ethernet3")}}
+    useSource(t, src, nil)
+    capturePackets(ctx, a, "ens3")
+    a.stop()
+
+    want := map[string]string{"ens1": "failed: no such device", "ens2": "failed: network is down", "ens3": "stopped: "}
+    for _, st := range a.interfacesSnapshot() {
+        if got := st.State + ": " + st.Error; got != want[st.Name] {
+            t.Errorf("%s = %q, want %q", st.Name, got, want[st.Name])
+        }
+    }
+    if len(src.frames) != 1 {
+        t.Errorf("cancelled capture read %d frames", 1-len(src.frames))
+    }
+}
+
+func TestCaptureFilterProgram(t *testing.T) {
+    vm, err := bpf.NewVM(captureFilterProgram)
+    if err != nil {
+        t.Fatal(err)
+    }
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    ns := &layers.ICMPv6NeighborSolicitation{TargetAddress: net.ParseIP("fe80::2")}
+    mac, _ := net.ParseMAC("02:00:00:00:00:01")
+    cdp := append([]byte{0x01, 0x00, 0x0c, 0xcc, 0xcc, 0xcc}, mac...)
+    cdp = append(cdp, 0x00, 0x20, 0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00, 0x02, 0xb4)
+    vtp := append([]byte(nil), cdp...)
+    vtp[21] = 0x03 // SNAP protocol 0x2003 (VTP) to the same multicast address
+    bpdu := stpPacket(t, "02:1c:73:00:00:11", stpAlternate, 0x8011, t0).Data()
+    otherLLC := append([]byte(nil), bpdu...)
+    otherLLC[14], otherLLC[15] = 0xfe, 0xfe // IS-IS to the same multicast address
+    ipv4 := append(append([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, mac...), 0x08, 0x00)
+    ipv4 = append(ipv4, make([]byte, 40)...)
+
+    for _, tc := range []struct {
+        name  string
+        frame []byte
+        want  bool
+    }{
+        {"lldp", lldpFrame(t, "leaf01", "Ethernet1"), true},
+        {"arp", arpPacket(t, layers.ARPRequest, "02:00:00:00:00:01", "10.1.0.1", "10.1.0.9", t0).Data(), true},
+        {"lacp", lacpPacket(t, "02:1c:73:00:00:0a", lacp.Port{}, lacp.Port{}, lacpUp, lacpUp).Data(), true},
+        {"cdp", cdp, true},
+        {"nd", ndPacket(t, "02:00:00:00:00:01", "fe80::1", ndHopLimit, layers.ICMPv6TypeNeighborSolicitation, ns, t0).Data(), true},
+        {"icmpv6 echo", ndPacket(t, "02:00:00:00:00:01", "fe80::1", 64, layers.ICMPv6TypeEchoRequest, &layers.ICMPv6Echo{}, t0).Data(), false},
+        {"stp", bpdu, true},
+        {"vtp", vtp, false},
+        {"other llc", otherLLC, false},
+        {"ipv4", ipv4, false},
+        {"runt", []byte{0x01, 0x02}, false},
+    } {
+        n, err := vm.Run(tc.frame)
+        if err != nil {
+            t.Fatalf("%s: %v", tc.name, err)
+        }
+        if got := n > 0; got != tc.want {
+            t.Errorf("%s: accepted = %v, want %v", tc.name, got, tc.want)
+        }
+    }
+}
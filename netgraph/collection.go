@@ -0,0 +1,156 @@
+package main
+
+import (
+    "os"
+    rdebug "runtime/debug"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+// version is the netgraph release, set at build time with -ldflags "-X main.version=...".
+// When it is not set the module version or VCS revision from the build info is used.
+var version string
+
+// machineIDPath is the systemd machine ID file; tests point it elsewhere.
+var machineIDPath = "/etc/machine-id"
+
+var (
+    // collection describes the running capture, for the snapshot envelope.
+    collection   topology.Collection
+    collectionMu sync.Mutex
+)
+
+// toolVersion returns the netgraph version recorded in snapshots.
+func toolVersion() string {
+    if version != "" {
+        return version
+    }
+    info, ok := rdebug.ReadBuildInfo()
+    if !ok {
+        return "unknown"
+    }
+    if v := info.Main.Version; v != "" && v != "(devel)" {
+        return v
+    }
+    var revision, dirty string
+    for _, s := range info.Settings {
+        switch s.Key {
+        case "vcs.revision":
+            revision = s.Value
+        case "vcs.modified":
+            if s.Value == "true" {
+                dirty = "-dirty"
+            }
+        }
+    }
+    if revision == "" {
+        return "devel"
+    }
+    if len(revision) > 12 {
+        revision = revision[:12]
+    }
+    return "devel+" + revision + dirty
+}
+
+// readMachineID returns the systemd machine ID, or "" if there is none.
+func readMachineID() string {
+    data, err := os.ReadFile(machineIDPath)
+    if err != nil {
+        return ""
+    }
+    return strings.TrimSpace(string(data))
+}
+
+// startCollection records the start of a live capture on ifaces with filter.
+func startCollection(ifaces []string, filter string, start time.Time) {
+    collectionMu.Lock()
+    defer collectionMu.Unlock()
+    collection.Source = "live"
+    collection.MachineID = readMachineID()
+    collection.Interfaces = append([]string(nil), ifaces...)
+    sort.Strings(collection.Interfaces)
+    collection.BPFFilter = filter
+    collection.Backend = captureBackend
+    collection.CaptureStart = start
+}
+
+// endCollection records the end of a live capture.
+func endCollection(end time.Time) {
+    collectionMu.Lock()
+    defer collectionMu.Unlock()
+    collection.CaptureEnd = end
+}
+
+// startReplayCollection records that the snapshot comes from replaying the capture file path.
+func startReplayCollection(path string) {
+    collectionMu.Lock()
+    defer collectionMu.Unlock()
+    collection.Source = path
+}
+
+// noteReplayedPacket extends the collection of a replayed capture file to cover a packet
+// read on ifName at ts.
+func noteReplayedPacket(ifName string, ts time.Time) {
+    collectionMu.Lock()
+    defer collectionMu.Unlock()
+    if collection.CaptureStart.IsZero() || ts.Before(collection.CaptureStart) {
+        collection.CaptureStart = ts
+    }
+    if ts.After(collection.CaptureEnd) {
+        collection.CaptureEnd = ts
+    }
+    addCollectionInterfaceLocked(ifName)
+}
+
+// addCollectionInterface records that ifName was captured on, e.g. once it was hot-plugged.
+func addCollectionInterface(ifName string) {
+    collectionMu.Lock()
+    defer collectionMu.Unlock()
+    addCollectionInterfaceLocked(ifName)
+}
+
+// addCollectionInterfaceLocked adds ifName to the sorted interface list; collectionMu must be held.
+func addCollectionInterfaceLocked(ifName string) {
+    i := sort.SearchStrings(collection.Interfaces, ifName)
+    if i == len(collection.Interfaces) || collection.Interfaces[i] != ifName {
+        collection.Interfaces = append(collection.Interfaces, "")
+        copy(collection.Interfaces[i+1:], collection.Interfaces[i:])
+        collection.Interfaces[i] = ifName
+    }
+}
+
+// currentCollection returns the collection metadata, stamped with the tool and host.
+func currentCollection() topology.Collection {
+    collectionMu.Lock()
+    c := collection
+    c.Interfaces = append([]string(nil), collection.Interfaces...)
+    collectionMu.Unlock()
+    c.Tool = "netgraph"
+    c.ToolVersion = toolVersion()
+    c.Hostname = localHostname
+    return c
+}
+
+// currentSnapshot wraps edges with the collection metadata, neighbors, interface states,
+// the port anomalies found on edges and interfaces, and the L3 report.
+func currentSnapshot(a *aggregator, edges []topology.Edge) topology.Snapshot {
+    c := currentCollection()
+    interfaces := a.interfacesSnapshot()
+    findings := append(portFindings(edges, a.ownFramesSnapshot()), stpFindings(a.stpSnapshot(), edges)...)
+    findings = append(findings, silentFindings(interfaces, c.CaptureEnd.Sub(c.CaptureStart))...)
+    s := topology.Snapshot{
+        Collection: c,
+        Edges:      edges,
+        Neighbors:  a.neighborsSnapshot(),
+        Interfaces: interfaces,
+        Findings:   findings,
+    }
+    if l3 := currentL3Report(a); len(l3.Bindings) > 0 || len(l3.Routers) > 0 {
+        s.L3 = &l3
+    }
+    return s
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+// resetCollection clears the collection metadata for a test.
+func resetCollection(t *testing.T) {
+    collectionMu.Lock()
+    old := collection
+    collection = topology.Collection{}
+    collectionMu.Unlock()
+    t.Cleanup(func() {
+        collectionMu.Lock()
+        collection = old
+        collectionMu.Unlock()
+    })
+}
+
+func TestReplayCollection(t *testing.T) {
+    resetCollection(t)
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+    startReplayCollection("fabric.pcapng")
+    noteReplayedPacket("ens2", t0.Add(time.Second))
+    noteReplayedPacket("ens1", t0)
+    noteReplayedPacket("ens2", t0.Add(time.Minute))
+
+    c := currentCollection()
+    want := topology.Collection{
+        Tool:         "netgraph",
+        ToolVersion:  toolVersion(),
+        Hostname:     localHostname,
+        Source:       "fabric.pcapng",
+        CaptureStart: t0,
+        CaptureEnd:   t0.Add(time.Minute),
+        Interfaces:   []string{"ens1", "ens2"},
+    }
+    if !reflect.DeepEqual(c, want) {
+        t.Errorf("currentCollection() =\n%+v\nwant\n%+v", c, want)
+    }
+}
+
+func TestLiveCollection(t *testing.T) {
+    resetCollection(t)
+    path := filepath.Join(t.TempDir(), "machine-id")
+    if err := os.WriteFile(path, []byte("0123456789abcdef\n"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    oldPath := machineIDPath
+    machineIDPath = path
+    t.Cleanup(func() { machineIDPath = oldPath })
+
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    startCollection([]string{"ens2", "ens1"}, "ether proto 0x88cc", t0)
+    endCollection(t0.Add(90 * time.Second))
+
+    c := currentCollection()
+    if c.Source != "live" || c.MachineID != "0123456789abcdef" || c.BPFFilter != "ether proto 0x88cc" ||
+        !reflect.DeepEqual(c.Interfaces, []string{"ens1", "ens2"}) || c.CaptureEnd.Sub(c.CaptureStart) != 90*time.Second {
+        t.Errorf("currentCollection() = %+v", c)
+    }
+    if c.ToolVersion == "" {
+        t.Error("ToolVersion is empty")
+    }
+}
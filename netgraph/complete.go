@@ -0,0 +1,137 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "path/filepath"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+// completeCheckInterval is how often -until-complete checks whether every port has a neighbor.
+const completeCheckInterval = time.Second
+
+// arphrdLoopback is the /sys/class/net/<if>/type of loopback devices, which never have neighbors.
+const arphrdLoopback = 772
+
+// hasCarrier reports whether ifName has link, from /sys/class/net/<if>/carrier
+// (which can't even be read while the interface is administratively down).
+func hasCarrier(ifName string) bool {
+    v, err := readSysfsInt(filepath.Join(sysfsRoot, "class", "net", ifName, "carrier"))
+    return err == nil && v == 1
+}
+
+// isLoopback reports whether ifName is a loopback device.
+func isLoopback(ifName string) bool {
+    v, err := readSysfsInt(filepath.Join(sysfsRoot, "class", "net", ifName, "type"))
+    return err == nil && v == arphrdLoopback
+}
+
+// waitingPorts returns the devices that are capturing (or stopped capturing without failing,
+// as every capture has by the time the final report runs), have carrier and have not learned
+// a neighbor yet. ready is false while some device has not started capturing or failed yet,
+// so the capture isn't declared complete before it began.
+func waitingPorts(devices []string, statuses []topology.InterfaceStatus, carrier func(string) bool) (waiting []string, ready bool) {
+    byName := make(map[string]topology.InterfaceStatus, len(statuses))
+    for _, st := range statuses {
+        byName[st.Name] = st
+    }
+    ready = true
+    for _, name := range devices {
+        st, ok := byName[name]
+        if !ok {
+            ready = false
+            continue
+        }
+        if (st.State != ifaceStateCapturing && st.State != ifaceStateStopped) || !st.FirstNeighbor.IsZero() || isLoopback(name) || !carrier(name) {
+            continue
+        }
+        waiting = append(waiting, name)
+    }
+    return waiting, ready
+}
+
+// untilCompleteLoop cancels the capture as soon as no device is waiting for a neighbor.
+func untilCompleteLoop(ctx context.Context, a *aggregator, cancel context.CancelFunc, devices []string, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if waiting, ready := waitingPorts(devices, a.interfacesSnapshot(), hasCarrier); ready && len(waiting) == 0 {
+                log.Println("Every interface with carrier has a neighbor, stopping...")
+                cancel()
+                return
+            }
+        }
+    }
+}
+
+// silentPortFindings returns a finding for every device that had carrier while capturing but
+// never learned a neighbor within waited, and marks those interfaces silent.
+func silentPortFindings(a *aggregator, devices []string, carrier func(string) bool, waited time.Duration) []topology.Finding {
+    waiting, _ := waitingPorts(devices, a.interfacesSnapshot(), carrier)
+    var findings []topology.Finding
+    for _, name := range waiting {
+        findings = append(findings, silentPortFinding(name, waited))
+    }
+    a.markSilent(waiting)
+    return findings
+}
+
+// silentPortFinding reports that name had carrier but learned no neighbor within waited.
+func silentPortFinding(name string, waited time.Duration) topology.Finding {
+    return topology.Finding{
+        Check:    "silent-port",
+        Severity: topology.SeverityWarning,
+        Message:  fmt.Sprintf("%s has carrier but saw no LLDP or CDP neighbor in %s", name, waited.Round(time.Second)),
+        Local:    name,
+    }
+}
+
+// silentFindings returns the silent-port findings of the interfaces marked silent, for the
+// snapshot of a collection that lasted waited.
+func silentFindings(statuses []topology.InterfaceStatus, waited time.Duration) []topology.Finding {
+    var findings []topology.Finding
+    for _, st := range statuses {
+        if st.Silent {
+            findings = append(findings, silentPortFinding(st.Name, waited))
+        }
+    }
+    return findings
+}
+
+// printCompletionReport prints, for every device, how long it took to learn a neighbor
+// or why it never did.
+func printCompletionReport(w io.Writer, devices []string, statuses []topology.InterfaceStatus, carrier func(string) bool, start time.Time) {
+    byName := make(map[string]topology.InterfaceStatus, len(statuses))
+    for _, st := range statuses {
+        byName[st.Name] = st
+    }
+    fmt.Fprintf(w, "Neighbor discovery per interface (%s):\n", time.Since(start).Round(time.Second))
+    for _, name := range devices {
+        st := byName[name]
+        var status string
+        switch {
+        case !st.FirstNeighbor.IsZero():
+            status = fmt.Sprintf("neighbor after %s", st.FirstNeighbor.Sub(start).Round(time.Second))
+        case st.State == ifaceStateFailed:
+            status = "capture failed: " + st.Error
+        case isLoopback(name):
+            status = "loopback"
+        case st.Silent:
+            status = "SILENT: carrier but no LLDP or CDP neighbor"
+        case !carrier(name):
+            status = "no carrier"
+        default:
+            status = "no neighbor"
+        }
+        fmt.Fprintf(w, "  %s: %s\n", name, status)
+    }
+    fmt.Fprintln(w)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+    "bytes"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+func TestUntilComplete(t *testing.T) {
+    fakeSysfs(t, map[string]string{
+        "class/net/lo/type":      "772\n",
+        "class/net/lo/carrier":   "1\n",
+        "class/net/ens1/carrier": "1\n",
+        "class/net/ens2/carrier": "1\n",
+        "class/net/ens3/carrier": "0\n",
+        // ens4 is administratively down: no readable carrier.
+    }, nil)
+    start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    devices := []string{"ens1", "ens2", "ens3", "ens4", "lo"}
+    a := testAggregator(
+        topology.InterfaceStatus{Name: "ens1", State: ifaceStateCapturing},
+        topology.InterfaceStatus{Name: "ens2", State: ifaceStateCapturing},
+        topology.InterfaceStatus{Name: "ens3", State: ifaceStateCapturing},
+        topology.InterfaceStatus{Name: "lo", State: ifaceStateCapturing},
+    )
+
+    // ens4 has not started capturing yet.
+    if waiting, ready := waitingPorts(devices, a.interfacesSnapshot(), hasCarrier); ready || !reflect.DeepEqual(waiting, []string{"ens1", "ens2"}) {
+        t.Errorf("waitingPorts() = %v, %v; want [ens1 ens2], not ready", waiting, ready)
+    }
+
+    a.setInterfaceState("ens4", ifaceStateFailed, nil)
+    a.apply(frameEvent{Iface: "ens1", Time: start.Add(3 * time.Second), Edge: &edgeEvent{Protocol: protocolLLDP,
+        Local: topology.Node{Interface: "ens1"}, Remote: topology.Node{Device: "leaf01", Interface: "Ethernet1"}}})
+    if waiting, ready := waitingPorts(devices, a.interfacesSnapshot(), hasCarrier); !ready || !reflect.DeepEqual(waiting, []string{"ens2"}) {
+        t.Errorf("waitingPorts() = %v, %v; want [ens2], ready", waiting, ready)
+    }
+
+    // Give up on ens2: the captures stop, then it is reported silent.
+    for _, name := range devices {
+        a.setInterfaceState(name, ifaceStateStopped, nil)
+    }
+    findings := silentPortFindings(a, devices, hasCarrier, 2*time.Minute)
+    want := []topology.Finding{{
+        Check:    "silent-port",
+        Severity: topology.SeverityWarning,
+        Message:  "ens2 has carrier but saw no LLDP or CDP neighbor in 2m0s",
+        Local:    "ens2",
+    }}
+    if !reflect.DeepEqual(findings, want) {
+        t.Errorf("silentPortFindings() = %+v, want %+v", findings, want)
+    }
+    if st := a.interfacesSnapshot(); !st[1].Silent || st[1].State != ifaceStateStopped || st[0].Silent {
+        t.Errorf("interfacesSnapshot() = %+v, want only ens2 silent", st)
+    }
+
+    // The snapshot carries the finding too.
+    resetCollection(t)
+    startCollection(devices, captureFilter, start)
+    endCollection(start.Add(2 * time.Minute))
+    if got := currentSnapshot(a, a.rawEdges()).Findings; !reflect.DeepEqual(got, want) {
+        t.Errorf("snapshot findings = %+v, want %+v", got, want)
+    }
+
+    var buf bytes.Buffer
+    printCompletionReport(&buf, devices, a.interfacesSnapshot(), hasCarrier, start)
+    for _, line := range []string{
+        "ens1: neighbor after 3s",
+        "ens2: SILENT: carrier but no LLDP or CDP neighbor",
+        "ens3: no carrier",
+        "ens4: capture failed",
+        "lo: loopback",
+    } {
+        if !strings.Contains(buf.String(), line) {
+            t.Errorf("report does not contain %q:\n%s", line, buf.String())
+        }
+    }
+}
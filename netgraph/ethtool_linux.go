@@ -0,0 +1,22 @@
+package main
+
+import (
+    "strings"
+
+    "golang.org/x/sys/unix"
+)
+
+// ethtoolFirmware returns the firmware version the driver reports for ifName (as in `ethtool -i`),
+// or "" if it can't be queried. It is a variable so tests can stub it out.
+var ethtoolFirmware = func(ifName string) string {
+    fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+    if err != nil {
+        return ""
+    }
+    defer unix.Close(fd)
+    info, err := unix.IoctlGetEthtoolDrvinfo(fd, ifName)
+    if err != nil {
+        return ""
+    }
+    return strings.TrimRight(string(info.Fw_version[:]), "\x00")
+}
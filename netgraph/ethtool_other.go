@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// ethtoolFirmware is only implemented on Linux.
+var ethtoolFirmware = func(ifName string) string {
+    return ""
+}
@@ -1,34 +1,15 @@
 package main
 
 import (
-    "encoding/json"
     "fmt"
     "io/ioutil"
     "log"
     "path/filepath"
     "sort"
     "strings"
-)
-
-// Edge represents one LLDP edge (local -> remote).
-type Edge struct {
-    Local  Node `json:"local"`
-    Remote Node `json:"remote"`
-}
-
-// Node is one end of an LLDP link.
-type Node struct {
-    Device    string `json:"device"`
-    Interface string `json:"interface"`
-    MAC       string `json:"mac"`
-}
 
-// DeviceInfo holds the data for each device from devices.json.
-type DeviceInfo struct {
-    Device  string `json:"device"`
-    Type    string `json:"type"`
-    Subtype string `json:"subtype,omitempty"`
-}
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
 
 func main() {
     // 1) Parse devices.json to build a map of device -> DeviceInfo
@@ -45,23 +26,30 @@ func main() {
     }
 
     // We'll store all edges in a slice:
-    var allEdges []Edge
+    var allEdges []topology.Edge
 
     for _, f := range files {
         if f.IsDir() {
             continue
         }
-        if !strings.HasSuffix(f.Name(), ".json") {
+        if !strings.HasSuffix(f.Name(), ".json") || f.Name() == "devices.json" {
             continue
         }
         fullPath := filepath.Join(inputDir, f.Name())
-        edges, err := parseEdgesFromJSON(fullPath)
+        edges, err := topology.LoadEdges(fullPath)
         if err != nil {
             log.Printf("Skipping file %s due to parse error: %v\n", f.Name(), err)
             continue
         }
+        if err := topology.ValidateEdges(edges); err != nil {
+            log.Printf("Warning: %s: %v\n", f.Name(), err)
+        }
         allEdges = append(allEdges, edges...)
     }
+    // The same IP on different MACs across hosts' outputs is an addressing conflict.
+    for _, f := range topology.FindDuplicateIPs(topology.NodeBindings(allEdges)) {
+        log.Printf("Warning: %s: %v\n", f.Message, f.Evidence)
+    }
 
     // 3) Build sets of device interfaces so we know which interfaces/ports belong to each device.
     //    Use nested maps: device -> map[interfaceName]bool
@@ -85,45 +73,25 @@ func main() {
     fmt.Println(dot)
 }
 
-// parseEdgesFromJSON opens a JSON file with an array of Edge objects and returns them.
-func parseEdgesFromJSON(filePath string) ([]Edge, error) {
-    data, err := ioutil.ReadFile(filePath)
-    if err != nil {
-        return nil, err
-    }
-    var edges []Edge
-    if err := json.Unmarshal(data, &edges); err != nil {
-        return nil, err
-    }
-    return edges, nil
-}
-
 // parseDevicesInfo opens the devices.json file, parses it, and builds a map from device name to DeviceInfo.
-func parseDevicesInfo(filePath string) (map[string]DeviceInfo, error) {
-    // Read the devices.json file
-    data, err := ioutil.ReadFile(filePath)
+func parseDevicesInfo(filePath string) (map[string]topology.DeviceInfo, error) {
+    deviceList, err := topology.LoadDevices(filePath)
     if err != nil {
         return nil, err
     }
-
-    var deviceList []DeviceInfo
-    if err := json.Unmarshal(data, &deviceList); err != nil {
-        return nil, err
+    if err := topology.ValidateDevices(deviceList); err != nil {
+        log.Printf("Warning: %s: %v\n", filePath, err)
     }
 
     // Convert slice to map for easy lookups by device name
-    deviceMap := make(map[string]DeviceInfo)
-    for _, d := range deviceList {
-        deviceMap[d.Device] = d
-    }
-    return deviceMap, nil
+    return topology.DeviceMap(deviceList), nil
 }
 
 // generateDOT returns a string containing the Graphviz DOT for all devices and edges.
 func generateDOT(
-    deviceMap map[string]DeviceInfo,
+    deviceMap map[string]topology.DeviceInfo,
     deviceInterfaces map[string]map[string]bool,
-    edges []Edge,
+    edges []topology.Edge,
 ) string {
     var sb strings.Builder
 
@@ -210,7 +178,7 @@ func generateDOT(
 
 // generateRecordNode creates the DOT record-based label for one device node.
 func generateRecordNode(
-    deviceMap map[string]DeviceInfo,
+    deviceMap map[string]topology.DeviceInfo,
     device string,
     ifaceMap map[string]bool,
 ) string {
@@ -1,47 +1,26 @@
 package main
 
 import (
-    "encoding/json"
     "flag"
     "fmt"
-    "io/ioutil"
     "log"
     "os"
     "path/filepath"
     "sort"
     "strings"
-)
-
-// Node represents one end of an LLDP link.
-type Node struct {
-    Device    string `json:"device"`
-    Interface string `json:"interface"`
-    MAC       string `json:"mac"`
-}
-
-// Edge represents one LLDP edge (local -> remote).
-type Edge struct {
-    Local  Node `json:"local"`
-    Remote Node `json:"remote"`
-}
 
-// DeviceInfo holds the data for each device from devices.json.
-type DeviceInfo struct {
-    Device  string `json:"device"`
-    Type    string `json:"type"`
-    Subtype string `json:"subtype,omitempty"`
-    Rack    string `json:"rack,omitempty"`
-}
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
 
 // PositionedDevice holds a DeviceInfo along with its (x,y) coordinates.
 type PositionedDevice struct {
-    DeviceInfo
+    topology.DeviceInfo
     X float64
     Y float64
 }
 
 // deviceRow decides vertical placement based on Type/Subtype.
-func deviceRow(d DeviceInfo) int {
+func deviceRow(d topology.DeviceInfo) int {
     switch d.Type {
     case "server":
         return 1
@@ -56,7 +35,7 @@ func deviceRow(d DeviceInfo) int {
 }
 
 // buildAdjacency builds undirected adjacency from edges.
-func buildAdjacency(edges []Edge) map[string][]string {
+func buildAdjacency(edges []topology.Edge) map[string][]string {
     adj := make(map[string][]string)
     for _, e := range edges {
         adj[e.Local.Device] = append(adj[e.Local.Device], e.Remote.Device)
@@ -66,8 +45,8 @@ func buildAdjacency(edges []Edge) map[string][]string {
 }
 
 // assignRacksByConnectivity labels connected components as racks.
-func assignRacksByConnectivity(adj map[string][]string, devInfos []DeviceInfo) {
-    deviceMap := make(map[string]*DeviceInfo)
+func assignRacksByConnectivity(adj map[string][]string, devInfos []topology.DeviceInfo) {
+    deviceMap := make(map[string]*topology.DeviceInfo)
     for i := range devInfos {
         deviceMap[devInfos[i].Device] = &devInfos[i]
     }
@@ -116,29 +95,31 @@ func main() {
     if err != nil {
         log.Fatalf("Error globbing LLDP JSON files (%s): %v", pattern, err)
     }
-    var edges []Edge
+    var edges []topology.Edge
     for _, f := range edgeFiles {
-        data, err := ioutil.ReadFile(f)
+        tmp, err := topology.LoadEdges(f)
         if err != nil {
-            log.Fatalf("Read error %s: %v", f, err)
+            log.Fatalf("Error loading edges: %v", err)
         }
-        var tmp []Edge
-        if err := json.Unmarshal(data, &tmp); err != nil {
-            log.Fatalf("Unmarshal error %s: %v", f, err)
+        if err := topology.ValidateEdges(tmp); err != nil {
+            log.Printf("Warning: %s: %v", f, err)
         }
         edges = append(edges, tmp...)
     }
     log.Printf("Parsed %d edges", len(edges))
+    // The same IP on different MACs across hosts' outputs is an addressing conflict.
+    for _, f := range topology.FindDuplicateIPs(topology.NodeBindings(edges)) {
+        log.Printf("Warning: %s: %v", f.Message, f.Evidence)
+    }
 
     // Load devices
     devPath := filepath.Join(*dataDir, "devices.json")
-    devData, err := ioutil.ReadFile(devPath)
+    devInfos, err := topology.LoadDevices(devPath)
     if err != nil {
         log.Fatalf("Error reading devices.json (%s): %v", devPath, err)
     }
-    var devInfos []DeviceInfo
-    if err := json.Unmarshal(devData, &devInfos); err != nil {
-        log.Fatalf("Unmarshal devices.json: %v", err)
+    if err := topology.ValidateDevices(devInfos); err != nil {
+        log.Printf("Warning: %s: %v", devPath, err)
     }
 
     // Assign racks by connectivity
@@ -146,7 +127,7 @@ func main() {
     assignRacksByConnectivity(adj, devInfos)
 
     // Group devices into rows
-    rowMap := make(map[int][]DeviceInfo)
+    rowMap := make(map[int][]topology.DeviceInfo)
     for _, d := range devInfos {
         rowMap[deviceRow(d)] = append(rowMap[deviceRow(d)], d)
     }
@@ -4,9 +4,12 @@ go 1.22.0
 
 toolchain go1.22.2
 
-require github.com/gopacket/gopacket v1.3.1
+require (
+	github.com/gopacket/gopacket v1.3.1
+	golang.org/x/net v0.28.0
+	golang.org/x/sys v0.24.0
+)
 
 require (
-	golang.org/x/sys v0.24.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
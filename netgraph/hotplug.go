@@ -0,0 +1,211 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "sync"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+// Link states recorded in topology.LinkTransition.
+const (
+    linkStateUp      = "up"
+    linkStateDown    = "down"
+    linkStateRemoved = "removed"
+)
+
+// linkEvent is a netlink RTM_NEWLINK or RTM_DELLINK notification.
+type linkEvent struct {
+    Name    string
+    Index   int
+    Up      bool // administratively up and running (IFF_UP and IFF_RUNNING)
+    Removed bool // RTM_DELLINK
+    Time    time.Time
+}
+
+// state is the link state the event leaves the interface in.
+func (ev linkEvent) state() string {
+    switch {
+    case ev.Removed:
+        return linkStateRemoved
+    case ev.Up:
+        return linkStateUp
+    }
+    return linkStateDown
+}
+
+// runningCapture is one capture goroutine of a captureSet.
+type runningCapture struct {
+    cancel  context.CancelFunc
+    stopped bool          // cancelled, but possibly still closing its packet source
+    done    chan struct{} // closed once the goroutine returned
+}
+
+// captureSet runs one capture goroutine per interface, so captures can be started and
+// stopped as links come and go.
+type captureSet struct {
+    ctx     context.Context
+    agg     *aggregator
+    wg      *sync.WaitGroup
+    capture func(ctx context.Context, deviceName string) // capturePackets into agg; tests replace it
+
+    mu      sync.Mutex
+    running map[string]*runningCapture
+}
+
+func newCaptureSet(ctx context.Context, a *aggregator, wg *sync.WaitGroup) *captureSet {
+    return &captureSet{
+        ctx: ctx,
+        agg: a,
+        wg:  wg,
+        capture: func(ctx context.Context, deviceName string) {
+            capturePackets(ctx, a, deviceName)
+        },
+        running: make(map[string]*runningCapture),
+    }
+}
+
+// start begins capturing on name unless a capture is already running there, and reports
+// whether it started one. A capture that is still shutting down is waited for first, so the
+// two never share the interface status.
+func (cs *captureSet) start(name string) bool {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    prev := cs.running[name]
+    if (prev != nil && !prev.stopped) || cs.ctx.Err() != nil {
+        return false
+    }
+    ctx, cancel := context.WithCancel(cs.ctx)
+    rc := &runningCapture{cancel: cancel, done: make(chan struct{})}
+    cs.running[name] = rc
+    cs.wg.Add(1)
+    go func() {
+        defer cs.wg.Done()
+        defer close(rc.done)
+        defer cancel()
+        if prev != nil {
+            <-prev.done
+        }
+        cs.capture(ctx, name)
+        cs.mu.Lock()
+        if cs.running[name] == rc {
+            delete(cs.running, name)
+        }
+        cs.mu.Unlock()
+    }()
+    return true
+}
+
+// stop cancels the capture on name, if any. It does not wait for it to finish.
+func (cs *captureSet) stop(name string) {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    if rc := cs.running[name]; rc != nil && !rc.stopped {
+        rc.stopped = true
+        rc.cancel()
+    }
+}
+
+// isRunning reports whether a capture on name is running and not being stopped.
+func (cs *captureSet) isRunning(name string) bool {
+    cs.mu.Lock()
+    defer cs.mu.Unlock()
+    rc := cs.running[name]
+    return rc != nil && !rc.stopped
+}
+
+// linkFollower starts and stops captures as links appear, come up, go down or disappear,
+// and records the link transitions. Its methods are called from a single goroutine.
+type linkFollower struct {
+    captures *captureSet
+    sel      interfaceSelection
+    selected map[string]bool   // whether an interface passed the selection, decided once per appearance
+    states   map[string]string // last known link state of the selected interfaces
+}
+
+// newLinkFollower starts following the devices captures were started on, taking their
+// current link state as the baseline.
+func newLinkFollower(captures *captureSet, sel interfaceSelection, devices []string) *linkFollower {
+    f := &linkFollower{
+        captures: captures,
+        sel:      sel,
+        selected: make(map[string]bool),
+        states:   make(map[string]string),
+    }
+    for _, name := range devices {
+        f.selected[name] = true
+        if iface, err := net.InterfaceByName(name); err == nil {
+            f.states[name] = linkStateDown
+            if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagRunning != 0 {
+                f.states[name] = linkStateUp
+            }
+        }
+    }
+    return f
+}
+
+// handle applies one link event.
+func (f *linkFollower) handle(ev linkEvent) {
+    selected, known := f.selected[ev.Name]
+    if !known {
+        selected = len(f.sel.filter([]string{ev.Name})) == 1
+        f.selected[ev.Name] = selected
+    }
+    // The link's speed, MTU, addresses or bond may have changed with it.
+    forgetLocalNode(ev.Name)
+    if ev.Removed {
+        // Decide again should an interface by that name come back.
+        delete(f.selected, ev.Name)
+    }
+    if !selected {
+        return
+    }
+
+    if state := ev.state(); f.states[ev.Name] != state {
+        f.states[ev.Name] = state
+        log.Printf("Link %s is %s\n", ev.Name, state)
+        f.captures.agg.noteLinkTransition(ev.Name, state, ev.Time)
+    }
+    if !ev.Up {
+        f.captures.stop(ev.Name)
+        return
+    }
+    if f.captures.start(ev.Name) {
+        addCollectionInterface(ev.Name)
+    }
+}
+
+// followLinks keeps the captures in step with the links until ctx is done.
+func followLinks(ctx context.Context, captures *captureSet, sel interfaceSelection, devices []string) {
+    f := newLinkFollower(captures, sel, devices)
+    if err := watchLinks(ctx, f.handle); err != nil {
+        log.Printf("Not following link changes: %v\n", err)
+    }
+}
+
+// printLinkTransitions lists the link changes seen during the capture, if there were any.
+func printLinkTransitions(w io.Writer, statuses []topology.InterfaceStatus) {
+    var printed bool
+    for _, st := range statuses {
+        if len(st.LinkTransitions) == 0 {
+            continue
+        }
+        if !printed {
+            fmt.Fprintln(w, "Link transitions during the capture:")
+            printed = true
+        }
+        fmt.Fprintf(w, "  %s:", st.Name)
+        for _, tr := range st.LinkTransitions {
+            fmt.Fprintf(w, " %s %s", tr.Time.Format(time.TimeOnly), tr.State)
+        }
+        fmt.Fprintln(w)
+    }
+    if printed {
+        fmt.Fprintln(w)
+    }
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+    "context"
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "log"
+    "strings"
+    "syscall"
+    "time"
+
+    "golang.org/x/sys/unix"
+)
+
+// watchLinks calls handle for every RTM_NEWLINK and RTM_DELLINK notification until ctx is done.
+func watchLinks(ctx context.Context, handle func(linkEvent)) error {
+    fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+    if err != nil {
+        return fmt.Errorf("netlink socket: %w", err)
+    }
+    defer unix.Close(fd)
+    if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK, Groups: unix.RTMGRP_LINK}); err != nil {
+        return fmt.Errorf("subscribing to link events: %w", err)
+    }
+    // Wake up every read timeout to notice cancellation.
+    tv := unix.NsecToTimeval(captureReadTimeout.Nanoseconds())
+    if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+        return fmt.Errorf("setting read timeout: %w", err)
+    }
+
+    buf := make([]byte, 1<<16)
+    for ctx.Err() == nil {
+        n, _, err := unix.Recvfrom(fd, buf, 0)
+        if err != nil {
+            switch {
+            case errors.Is(err, unix.EAGAIN), errors.Is(err, unix.EINTR):
+                continue
+            case errors.Is(err, unix.ENOBUFS):
+                log.Println("Netlink socket overflowed, some link changes were missed")
+                continue
+            }
+            return fmt.Errorf("reading link events: %w", err)
+        }
+        events, err := parseLinkMessages(buf[:n], time.Now())
+        if err != nil {
+            log.Printf("Bad netlink message: %v\n", err)
+            continue
+        }
+        for _, ev := range events {
+            handle(ev)
+        }
+    }
+    return nil
+}
+
+// parseLinkMessages decodes the RTM_NEWLINK and RTM_DELLINK messages in one netlink read.
+func parseLinkMessages(b []byte, now time.Time) ([]linkEvent, error) {
+    msgs, err := syscall.ParseNetlinkMessage(b)
+    if err != nil {
+        return nil, err
+    }
+    var events []linkEvent
+    for i := range msgs {
+        m := &msgs[i]
+        if (m.Header.Type != unix.RTM_NEWLINK && m.Header.Type != unix.RTM_DELLINK) || len(m.Data) < unix.SizeofIfInfomsg {
+            continue
+        }
+        attrs, err := syscall.ParseNetlinkRouteAttr(m)
+        if err != nil {
+            return events, err
+        }
+        ev := linkEvent{
+            // struct ifinfomsg: family, pad, type, index, flags, change.
+            Index:   int(int32(binary.NativeEndian.Uint32(m.Data[4:8]))),
+            Removed: m.Header.Type == unix.RTM_DELLINK,
+            Time:    now,
+        }
+        flags := binary.NativeEndian.Uint32(m.Data[8:12])
+        ev.Up = flags&unix.IFF_UP != 0 && flags&unix.IFF_RUNNING != 0
+        for _, a := range attrs {
+            if a.Attr.Type == unix.IFLA_IFNAME {
+                ev.Name = strings.TrimRight(string(a.Value), "\x00")
+            }
+        }
+        if ev.Name != "" {
+            events = append(events, ev)
+        }
+    }
+    return events, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+    "encoding/binary"
+    "reflect"
+    "testing"
+    "time"
+
+    "golang.org/x/sys/unix"
+)
+
+// linkMessage builds an RTM_NEWLINK/RTM_DELLINK message as the kernel sends it.
+func linkMessage(typ uint16, index int32, flags uint32, name string) []byte {
+    attr := make([]byte, unix.SizeofRtAttr, unix.SizeofRtAttr+len(name)+1)
+    attr = append(attr, name...)
+    attr = append(attr, 0)
+    binary.NativeEndian.PutUint16(attr[0:2], uint16(len(attr)))
+    binary.NativeEndian.PutUint16(attr[2:4], unix.IFLA_IFNAME)
+    for len(attr)%4 != 0 {
+        attr = append(attr, 0)
+    }
+
+    msg := make([]byte, unix.SizeofNlMsghdr+unix.SizeofIfInfomsg)
+    binary.NativeEndian.PutUint16(msg[4:6], typ)
+    info := msg[unix.SizeofNlMsghdr:]
+    binary.NativeEndian.PutUint32(info[4:8], uint32(index))
+    binary.NativeEndian.PutUint32(info[8:12], flags)
+    msg = append(msg, attr...)
+    binary.NativeEndian.PutUint32(msg[0:4], uint32(len(msg)))
+    return msg
+}
+
+func TestParseLinkMessages(t *testing.T) {
+    now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    var b []byte
+    b = append(b, linkMessage(unix.RTM_NEWLINK, 4, unix.IFF_UP|unix.IFF_RUNNING|unix.IFF_LOWER_UP, "ens1np0")...)
+    b = append(b, linkMessage(unix.RTM_NEWLINK, 5, unix.IFF_UP, "ens2np0")...)
+    b = append(b, linkMessage(unix.RTM_NEWADDR, 5, 0, "ens2np0")...)
+    b = append(b, linkMessage(unix.RTM_DELLINK, 6, 0, "veth1")...)
+
+    events, err := parseLinkMessages(b, now)
+    if err != nil {
+        t.Fatal(err)
+    }
+    want := []linkEvent{
+        {Name: "ens1np0", Index: 4, Up: true, Time: now},
+        {Name: "ens2np0", Index: 5, Time: now},
+        {Name: "veth1", Index: 6, Removed: true, Time: now},
+    }
+    if !reflect.DeepEqual(events, want) {
+        t.Errorf("parseLinkMessages() =\n%+v\nwant\n%+v", events, want)
+    }
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+    "context"
+    "errors"
+)
+
+// watchLinks is only implemented on Linux, where link changes come from netlink.
+func watchLinks(ctx context.Context, handle func(linkEvent)) error {
+    return errors.New("link events are only available on Linux")
+}
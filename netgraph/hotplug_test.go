@@ -0,0 +1,140 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "reflect"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+// fakeCaptures is a captureSet whose captures only log when they start and stop.
+type fakeCaptures struct {
+    mu     sync.Mutex
+    events []string
+}
+
+func (fc *fakeCaptures) log(event string) {
+    fc.mu.Lock()
+    defer fc.mu.Unlock()
+    fc.events = append(fc.events, event)
+}
+
+func (fc *fakeCaptures) logged() []string {
+    fc.mu.Lock()
+    defer fc.mu.Unlock()
+    return append([]string(nil), fc.events...)
+}
+
+func newFakeCaptureSet(ctx context.Context, a *aggregator, wg *sync.WaitGroup) (*captureSet, *fakeCaptures) {
+    fc := &fakeCaptures{}
+    cs := newCaptureSet(ctx, a, wg)
+    cs.capture = func(ctx context.Context, name string) {
+        fc.log("start " + name)
+        <-ctx.Done()
+        // Closing the packet source takes a moment.
+        time.Sleep(10 * time.Millisecond)
+        fc.log("stop " + name)
+    }
+    return cs, fc
+}
+
+func TestCaptureSet(t *testing.T) {
+    ctx, cancel := context.WithCancel(context.Background())
+    var wg sync.WaitGroup
+    cs, fc := newFakeCaptureSet(ctx, testAggregator(), &wg)
+
+    if !cs.start("ens1") || cs.start("ens1") {
+        t.Error("start(ens1) twice should start one capture")
+    }
+    cs.stop("ens1")
+    if cs.isRunning("ens1") {
+        t.Error("ens1 still running after stop")
+    }
+    // Restarting right away waits for the old capture to finish.
+    if !cs.start("ens1") {
+        t.Error("start(ens1) after stop did not start a capture")
+    }
+    cancel()
+    wg.Wait()
+    if cs.start("ens2") {
+        t.Error("start(ens2) after cancellation started a capture")
+    }
+
+    want := []string{"start ens1", "stop ens1", "start ens1", "stop ens1"}
+    if got := fc.logged(); !reflect.DeepEqual(got, want) {
+        t.Errorf("captures = %v, want %v", got, want)
+    }
+    if len(cs.running) != 0 {
+        t.Errorf("running = %v, want none", cs.running)
+    }
+}
+
+func TestLinkFollower(t *testing.T) {
+    fakeSysfs(t, nil, nil)
+    a := testAggregator()
+    resetCollection(t)
+    ctx, cancel := context.WithCancel(context.Background())
+    var wg sync.WaitGroup
+    cs, fc := newFakeCaptureSet(ctx, a, &wg)
+
+    exclude, _ := parseInterfacePatterns("docker*")
+    cs.start("ens1")
+    f := newLinkFollower(cs, interfaceSelection{Exclude: exclude}, []string{"ens1"})
+    f.states["ens1"] = linkStateUp
+    newLocalNode("ens1")
+
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    for _, ev := range []linkEvent{
+        {Name: "ens1", Up: true, Time: t0},                            // no change
+        {Name: "ens1", Time: t0.Add(time.Second)},                     // firmware update resets the NIC
+        {Name: "ens1", Time: t0.Add(2 * time.Second)},                 // still down
+        {Name: "ens1", Up: true, Time: t0.Add(30 * time.Second)},      // back
+        {Name: "docker0", Up: true, Time: t0.Add(31 * time.Second)},   // not selected
+        {Name: "ens5", Time: t0.Add(40 * time.Second)},                // hot-plugged, no carrier yet
+        {Name: "ens5", Up: true, Time: t0.Add(45 * time.Second)},      // comes up
+        {Name: "ens5", Removed: true, Time: t0.Add(50 * time.Second)}, // unplugged
+    } {
+        f.handle(ev)
+        // Let the fake captures log in order.
+        time.Sleep(50 * time.Millisecond)
+    }
+    cancel()
+    wg.Wait()
+
+    want := []string{"start ens1", "stop ens1", "start ens1", "start ens5", "stop ens5", "stop ens1"}
+    if got := fc.logged(); !reflect.DeepEqual(got, want) {
+        t.Errorf("captures = %v, want %v", got, want)
+    }
+    transitions := make(map[string][]topology.LinkTransition)
+    for _, st := range a.interfacesSnapshot() {
+        transitions[st.Name] = st.LinkTransitions
+    }
+    wantTransitions := map[string][]topology.LinkTransition{
+        "ens1": {{Time: t0.Add(time.Second), State: "down"}, {Time: t0.Add(30 * time.Second), State: "up"}},
+        "ens5": {{Time: t0.Add(40 * time.Second), State: "down"}, {Time: t0.Add(45 * time.Second), State: "up"},
+            {Time: t0.Add(50 * time.Second), State: "removed"}},
+    }
+    if !reflect.DeepEqual(transitions, wantTransitions) {
+        t.Errorf("transitions = %+v, want %+v", transitions, wantTransitions)
+    }
+    localNodesMu.Lock()
+    _, cached := localNodes["ens1"]
+    localNodesMu.Unlock()
+    if cached {
+        t.Error("ens1's local node is still cached after its link changed")
+    }
+    if got := currentCollection().Interfaces; !reflect.DeepEqual(got, []string{"ens1", "ens5"}) {
+        t.Errorf("collection interfaces = %v, want ens1 and the hot-plugged ens5", got)
+    }
+
+    var buf bytes.Buffer
+    printLinkTransitions(&buf, a.interfacesSnapshot())
+    if line := "ens1: 12:00:01 down 12:00:30 up"; !strings.Contains(buf.String(), line) {
+        t.Errorf("report does not contain %q:\n%s", line, buf.String())
+    }
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "net"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+// Binding sources, as recorded in topology.Binding.Source.
+const (
+    bindingSourceARP   = "arp"
+    bindingSourceGARP  = "garp"
+    bindingSourceND    = "nd"
+    bindingSourceLocal = "local"
+)
+
+// minInferredPrefix is the shortest prefix inferSubnet will report; neighbors spread
+// wider than that have no meaningful common subnet.
+const minInferredPrefix = 16
+
+// bindingKey identifies an IP to MAC binding seen on one local interface.
+type bindingKey struct {
+    iface string
+    ip    string
+    mac   string
+}
+
+// routerKey identifies an IPv6 router heard on one local interface.
+type routerKey struct {
+    iface string
+    ip    string
+}
+
+// interfaceAddrs returns the addresses configured on a local interface; tests replace it.
+var interfaceAddrs = func(ifName string) []*net.IPNet {
+    iface, err := net.InterfaceByName(ifName)
+    if err != nil {
+        return nil
+    }
+    addrs, err := iface.Addrs()
+    if err != nil {
+        return nil
+    }
+    var nets []*net.IPNet
+    for _, a := range addrs {
+        if n, ok := a.(*net.IPNet); ok {
+            nets = append(nets, n)
+        }
+    }
+    return nets
+}
+
+// recordBinding stores (or refreshes) the binding of ip to mac seen on ifName. a.mu must be held.
+func (a *aggregator) recordBinding(ifName string, ip net.IP, mac net.HardwareAddr, source string, seen time.Time) {
+    if ip.IsUnspecified() || len(mac) == 0 {
+        return
+    }
+    key := bindingKey{iface: ifName, ip: ip.String(), mac: mac.String()}
+    if b, ok := a.bindings[key]; ok {
+        if seen.After(b.LastSeen) {
+            b.LastSeen = seen
+        }
+        b.Count++
+        // Once a host announced itself gratuitously, keep saying so.
+        if source == bindingSourceGARP {
+            b.Source = source
+        }
+        return
+    }
+    a.bindings[key] = &topology.Binding{
+        IP:        key.ip,
+        MAC:       key.mac,
+        Interface: ifName,
+        Device:    localHostname,
+        Source:    source,
+        FirstSeen: seen,
+        LastSeen:  seen,
+        Count:     1,
+    }
+}
+
+// bindingsSnapshot returns a copy of all bindings sorted by interface, IP and MAC.
+func (a *aggregator) bindingsSnapshot() []topology.Binding {
+    a.mu.Lock()
+    out := make([]topology.Binding, 0, len(a.bindings))
+    for _, b := range a.bindings {
+        out = append(out, *b)
+    }
+    a.mu.Unlock()
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Interface != out[j].Interface {
+            return out[i].Interface < out[j].Interface
+        }
+        if out[i].IP != out[j].IP {
+            return out[i].IP < out[j].IP
+        }
+        return out[i].MAC < out[j].MAC
+    })
+    return out
+}
+
+// recordRouter stores (or refreshes) a router from its latest Router Advertisement on ifName.
+// The advertised parameters and prefixes are replaced with the latest ones. a.mu must be held.
+func (a *aggregator) recordRouter(ifName string, r topology.Router, seen time.Time) {
+    key := routerKey{iface: ifName, ip: r.IP}
+    r.Interface = ifName
+    r.Device = localHostname
+    r.FirstSeen, r.LastSeen, r.Count = seen, seen, 1
+    if old, ok := a.routers[key]; ok {
+        r.FirstSeen, r.Count = old.FirstSeen, old.Count+1
+        if old.LastSeen.After(seen) {
+            r.LastSeen = old.LastSeen
+        }
+    }
+    a.routers[key] = &r
+}
+
+// routersSnapshot returns a copy of all routers sorted by interface and IP.
+func (a *aggregator) routersSnapshot() []topology.Router {
+    a.mu.Lock()
+    out := make([]topology.Router, 0, len(a.routers))
+    for _, r := range a.routers {
+        out = append(out, *r)
+    }
+    a.mu.Unlock()
+    sort.Slice(out, func(i, j int) bool {
+        if out[i].Interface != out[j].Interface {
+            return out[i].Interface < out[j].Interface
+        }
+        return out[i].IP < out[j].IP
+    })
+    return out
+}
+
+// localIPs formats the addresses configured on ifName.
+func localIPs(ifName string) []string {
+    var ips []string
+    for _, n := range interfaceAddrs(ifName) {
+        ips = append(ips, n.IP.String())
+    }
+    return ips
+}
+
+// attachBindings fills in the IPs of each edge's remote node from the bindings seen on the
+// edge's local interface for the remote port MAC (or MAC chassis ID, which routed switch ports often use).
+func attachBindings(edges []topology.Edge, bs []topology.Binding) []topology.Edge {
+    byIfaceMAC := make(map[string]map[string][]string)
+    for _, b := range bs {
+        if byIfaceMAC[b.Interface] == nil {
+            byIfaceMAC[b.Interface] = make(map[string][]string)
+        }
+        byIfaceMAC[b.Interface][b.MAC] = append(byIfaceMAC[b.Interface][b.MAC], b.IP)
+    }
+    for i := range edges {
+        e := &edges[i]
+        macs := byIfaceMAC[e.Local.Interface]
+        if macs == nil {
+            continue
+        }
+        var ips []string
+        ips = append(ips, macs[e.Remote.MAC]...)
+        if e.Remote.ChassisIDSubtype == "mac-address" && e.Remote.ChassisID != e.Remote.MAC {
+            ips = append(ips, macs[e.Remote.ChassisID]...)
+        }
+        if len(ips) > 0 {
+            sort.Strings(ips)
+            e.Remote.IPs = ips
+        }
+    }
+    return edges
+}
+
+// buildL3Report infers subnets and flags duplicate and off-subnet IPs. configured maps each
+// interface to its local addresses; they count as bindings of the interface's own MAC so
+// another host answering for one of our IPs shows up as a duplicate. On-link prefixes the
+// routers advertise count as subnets of the interface for the IPv6 off-subnet check.
+func buildL3Report(bs []topology.Binding, rs []topology.Router, configured map[string][]*net.IPNet, localMACs map[string]string) topology.L3Report {
+    if rs == nil {
+        rs = []topology.Router{}
+    }
+    report := topology.L3Report{Bindings: bs, Routers: rs, Subnets: []topology.Subnet{}, Findings: []topology.Finding{}}
+
+    all := append([]topology.Binding(nil), bs...)
+    for iface, nets := range configured {
+        if localMACs[iface] == "" {
+            continue
+        }
+        for _, n := range nets {
+            all = append(all, topology.Binding{
+                IP: n.IP.String(), MAC: localMACs[iface], Interface: iface, Device: localHostname, Source: bindingSourceLocal,
+            })
+        }
+    }
+    report.Findings = append(report.Findings, topology.FindDuplicateIPs(all)...)
+
+    byIface := make(map[string][]topology.Binding)
+    for _, b := range bs {
+        byIface[b.Interface] = append(byIface[b.Interface], b)
+    }
+    advertised := make(map[string][]*net.IPNet)
+    for _, r := range rs {
+        for _, p := range r.Prefixes {
+            if _, n, err := net.ParseCIDR(p.Prefix); err == nil && p.OnLink && !containedIn(n.IP, advertised[r.Interface]) {
+                advertised[r.Interface] = append(advertised[r.Interface], n)
+            }
+        }
+    }
+    for _, m := range []map[string][]*net.IPNet{configured, advertised} {
+        for iface := range m {
+            if _, ok := byIface[iface]; !ok {
+                byIface[iface] = nil
+            }
+        }
+    }
+    ifaces := make([]string, 0, len(byIface))
+    for iface := range byIface {
+        ifaces = append(ifaces, iface)
+    }
+    sort.Strings(ifaces)
+
+    for _, iface := range ifaces {
+        subnet := topology.Subnet{Interface: iface}
+        var v4Nets, v6Nets []*net.IPNet
+        for _, n := range configured[iface] {
+            subnet.Configured = append(subnet.Configured, n.String())
+            if n.IP.To4() != nil {
+                v4Nets = append(v4Nets, n)
+            } else if !n.IP.IsLinkLocalUnicast() {
+                v6Nets = append(v6Nets, n)
+            }
+        }
+        for _, n := range advertised[iface] {
+            subnet.Advertised = append(subnet.Advertised, n.String())
+            v6Nets = append(v6Nets, n)
+        }
+
+        var v4 []net.IP
+        seen := make(map[string]bool)
+        for _, b := range byIface[iface] {
+            ip := net.ParseIP(b.IP)
+            if ip == nil || seen[b.IP] {
+                continue
+            }
+            seen[b.IP] = true
+            subnet.Neighbors++
+            nets := v6Nets
+            if ip.To4() != nil {
+                v4 = append(v4, ip)
+                nets = v4Nets
+            } else if ip.IsLinkLocalUnicast() {
+                // Every IPv6 interface has a link-local address; there is no subnet to check.
+                continue
+            }
+            if len(nets) > 0 && !containedIn(ip, nets) {
+                subnet.OffSubnet++
+                var subnets []string
+                for _, n := range nets {
+                    subnets = append(subnets, n.String())
+                }
+                report.Findings = append(report.Findings, topology.Finding{
+                    Check:    "off-subnet",
+                    Severity: topology.SeverityWarning,
+                    Message:  fmt.Sprintf("%s (%s) seen on %s is outside its subnets %s", b.IP, b.MAC, iface, strings.Join(subnets, ", ")),
+                    Local:    strings.Join(subnets, ","),
+                    Remote:   b.IP,
+                })
+            }
+        }
+        subnet.Inferred = inferSubnet(v4)
+        report.Subnets = append(report.Subnets, subnet)
+    }
+    return report
+}
+
+// containedIn reports whether ip is in any of nets.
+func containedIn(ip net.IP, nets []*net.IPNet) bool {
+    for _, n := range nets {
+        if n.Contains(ip) {
+            return true
+        }
+    }
+    return false
+}
+
+// inferSubnet returns the smallest IPv4 prefix covering all ips, or "" if there are fewer
+// than two of them or they share less than minInferredPrefix bits.
+func inferSubnet(ips []net.IP) string {
+    if len(ips) < 2 {
+        return ""
+    }
+    first := ips[0].To4()
+    prefix := 32
+    for _, ip := range ips[1:] {
+        ip4 := ip.To4()
+        for bits := 0; bits < prefix; bits++ {
+            mask := byte(0x80 >> uint(bits%8))
+            if first[bits/8]&mask != ip4[bits/8]&mask {
+                prefix = bits
+                break
+            }
+        }
+    }
+    if prefix < minInferredPrefix {
+        return ""
+    }
+    n := net.IPNet{IP: first.Mask(net.CIDRMask(prefix, 32)), Mask: net.CIDRMask(prefix, 32)}
+    return n.String()
+}
+
+// currentL3Report builds the L3 report from the live bindings and the local interface addresses.
+func currentL3Report(a *aggregator) topology.L3Report {
+    configured := make(map[string][]*net.IPNet)
+    localMACs := make(map[string]string)
+    if !offlineReplay {
+        for _, st := range a.interfacesSnapshot() {
+            configured[st.Name] = interfaceAddrs(st.Name)
+            localMACs[st.Name] = st.MAC
+        }
+    }
+    return buildL3Report(a.bindingsSnapshot(), a.routersSnapshot(), configured, localMACs)
+}
+
+// printL3Report prints the per-interface subnets, the IPv6 routers and the IP findings.
+func printL3Report(w io.Writer, report topology.L3Report) {
+    if len(report.Bindings) == 0 && len(report.Routers) == 0 {
+        return
+    }
+    fmt.Fprintf(w, "L3 neighbors (%d IP/MAC bindings):\n", len(report.Bindings))
+    for _, s := range report.Subnets {
+        configured := "-"
+        if len(s.Configured) > 0 {
+            configured = strings.Join(s.Configured, ",")
+        }
+        inferred := s.Inferred
+        if inferred == "" {
+            inferred = "-"
+        }
+        fmt.Fprintf(w, "  %s: %d neighbors, configured %s, inferred %s", s.Interface, s.Neighbors, configured, inferred)
+        if len(s.Advertised) > 0 {
+            fmt.Fprintf(w, ", advertised %s", strings.Join(s.Advertised, ","))
+        }
+        if s.OffSubnet > 0 {
+            fmt.Fprintf(w, ", %d off-subnet", s.OffSubnet)
+        }
+        fmt.Fprintln(w)
+    }
+    for _, r := range report.Routers {
+        role := "default router"
+        if r.Lifetime == 0 {
+            role = "not a default router"
+        }
+        fmt.Fprintf(w, "  router %s (%s) on %s: %s", r.IP, r.MAC, r.Interface, role)
+        if r.Managed {
+            fmt.Fprint(w, ", managed (DHCPv6)")
+        }
+        if r.MTU > 0 {
+            fmt.Fprintf(w, ", MTU %d", r.MTU)
+        }
+        fmt.Fprintln(w)
+    }
+    for _, f := range report.Findings {
+        fmt.Fprintf(w, "  %s %s: %s\n", strings.ToUpper(f.Severity), f.Check, f.Message)
+        for _, ev := range f.Evidence {
+            fmt.Fprintf(w, "      %s\n", ev)
+        }
+    }
+    fmt.Fprintln(w)
+}
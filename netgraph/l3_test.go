@@ -0,0 +1,309 @@
+package main
+
+import (
+    "net"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+    "github.com/gopacket/gopacket"
+    "github.com/gopacket/gopacket/layers"
+)
+
+// arpPacket builds an ARP packet as it would come off the wire.
+func arpPacket(t *testing.T, op uint16, srcMAC string, srcIP, dstIP string, ts time.Time) gopacket.Packet {
+    t.Helper()
+    mac, _ := net.ParseMAC(srcMAC)
+    eth := &layers.Ethernet{SrcMAC: mac, DstMAC: layers.EthernetBroadcast, EthernetType: layers.EthernetTypeARP}
+    arp := &layers.ARP{
+        AddrType:          layers.LinkTypeEthernet,
+        Protocol:          layers.EthernetTypeIPv4,
+        HwAddressSize:     6,
+        ProtAddressSize:   4,
+        Operation:         op,
+        SourceHwAddress:   mac,
+        SourceProtAddress: net.ParseIP(srcIP).To4(),
+        DstHwAddress:      make([]byte, 6),
+        DstProtAddress:    net.ParseIP(dstIP).To4(),
+    }
+    buf := gopacket.NewSerializeBuffer()
+    if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, arp); err != nil {
+        t.Fatal(err)
+    }
+    packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+    packet.Metadata().Timestamp = ts
+    return packet
+}
+
+func TestARPBindings(t *testing.T) {
+    a := testAggregator()
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+    a.processPacket("ens1", arpPacket(t, layers.ARPRequest, "02:00:00:00:00:01", "10.1.0.1", "10.1.0.9", t0))
+    a.processPacket("ens1", arpPacket(t, layers.ARPRequest, "02:00:00:00:00:01", "10.1.0.1", "10.1.0.8", t0.Add(time.Second)))
+    a.processPacket("ens1", arpPacket(t, layers.ARPReply, "02:00:00:00:00:02", "10.1.0.2", "10.1.0.2", t0))  // gratuitous
+    a.processPacket("ens1", arpPacket(t, layers.ARPRequest, "02:00:00:00:00:03", "0.0.0.0", "10.1.0.3", t0)) // probe
+
+    want := []topology.Binding{
+        {IP: "10.1.0.1", MAC: "02:00:00:00:00:01", Interface: "ens1", Device: localHostname, Source: "arp",
+            FirstSeen: t0, LastSeen: t0.Add(time.Second), Count: 2},
+        {IP: "10.1.0.2", MAC: "02:00:00:00:00:02", Interface: "ens1", Device: localHostname, Source: "garp",
+            FirstSeen: t0, LastSeen: t0, Count: 1},
+    }
+    if got := a.bindingsSnapshot(); !reflect.DeepEqual(got, want) {
+        t.Errorf("bindingsSnapshot() =\n%+v\nwant\n%+v", got, want)
+    }
+}
+
+// ndPacket builds an ICMPv6 neighbor discovery packet around msg (one of the ND message layers).
+func ndPacket(t *testing.T, srcMAC, srcIP string, hopLimit uint8, typ uint8, msg gopacket.SerializableLayer, ts time.Time) gopacket.Packet {
+    t.Helper()
+    mac, _ := net.ParseMAC(srcMAC)
+    dstMAC, _ := net.ParseMAC("33:33:00:00:00:01")
+    eth := &layers.Ethernet{SrcMAC: mac, DstMAC: dstMAC, EthernetType: layers.EthernetTypeIPv6}
+    ip6 := &layers.IPv6{
+        Version:    6,
+        NextHeader: layers.IPProtocolICMPv6,
+        HopLimit:   hopLimit,
+        SrcIP:      net.ParseIP(srcIP),
+        DstIP:      net.ParseIP("ff02::1"),
+    }
+    icmp := &layers.ICMPv6{TypeCode: layers.CreateICMPv6TypeCode(typ, 0)}
+    buf := gopacket.NewSerializeBuffer()
+    if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, ip6, icmp, msg); err != nil {
+        t.Fatal(err)
+    }
+    packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+    packet.Metadata().Timestamp = ts
+    return packet
+}
+
+// linkAddrOption is a source or target link-layer address ND option.
+func linkAddrOption(typ layers.ICMPv6Opt, mac string) layers.ICMPv6Option {
+    hw, _ := net.ParseMAC(mac)
+    return layers.ICMPv6Option{Type: typ, Data: hw}
+}
+
+func TestNDBindings(t *testing.T) {
+    a := testAggregator()
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+    // Neighbor solicitation from a host, and a DAD probe that binds nothing.
+    a.processPacket("ens1", ndPacket(t, "02:00:00:00:00:01", "fe80::1", 255, layers.ICMPv6TypeNeighborSolicitation,
+        &layers.ICMPv6NeighborSolicitation{
+            TargetAddress: net.ParseIP("fe80::fe"),
+            Options:       layers.ICMPv6Options{linkAddrOption(layers.ICMPv6OptSourceAddress, "02:00:00:00:00:01")},
+        }, t0))
+    a.processPacket("ens1", ndPacket(t, "02:00:00:00:00:03", "::", 255, layers.ICMPv6TypeNeighborSolicitation,
+        &layers.ICMPv6NeighborSolicitation{TargetAddress: net.ParseIP("2001:db8:1::3")}, t0))
+    // Neighbor advertisement for a global address; the target option wins over the frame source.
+    a.processPacket("ens1", ndPacket(t, "02:00:00:00:00:aa", "fe80::2", 255, layers.ICMPv6TypeNeighborAdvertisement,
+        &layers.ICMPv6NeighborAdvertisement{
+            Flags:         0x60, // solicited, override
+            TargetAddress: net.ParseIP("2001:db8:1::2"),
+            Options:       layers.ICMPv6Options{linkAddrOption(layers.ICMPv6OptTargetAddress, "02:00:00:00:00:02")},
+        }, t0))
+    // Forwarded from off-link: ignored.
+    a.processPacket("ens1", ndPacket(t, "02:00:00:00:00:09", "fe80::9", 64, layers.ICMPv6TypeNeighborAdvertisement,
+        &layers.ICMPv6NeighborAdvertisement{TargetAddress: net.ParseIP("2001:db8:9::9")}, t0))
+
+    prefix := make([]byte, 30)
+    prefix[0], prefix[1] = 64, 0xc0                              // /64, on-link and autonomous
+    copy(prefix[2:], []byte{0, 0, 0x0e, 0x10, 0, 0, 0x07, 0x08}) // valid 3600s, preferred 1800s
+    copy(prefix[14:], net.ParseIP("2001:db8:1::"))
+    ra := &layers.ICMPv6RouterAdvertisement{
+        HopLimit:       64,
+        Flags:          0x80, // managed
+        RouterLifetime: 1800,
+        Options: layers.ICMPv6Options{
+            linkAddrOption(layers.ICMPv6OptSourceAddress, "02:00:00:00:00:fe"),
+            {Type: layers.ICMPv6OptMTU, Data: []byte{0, 0, 0, 0, 0x23, 0x28}},
+            {Type: layers.ICMPv6OptPrefixInfo, Data: prefix},
+        },
+    }
+    a.processPacket("ens1", ndPacket(t, "02:00:00:00:00:fe", "fe80::fe", 255, layers.ICMPv6TypeRouterAdvertisement, ra, t0))
+    a.processPacket("ens1", ndPacket(t, "02:00:00:00:00:fe", "fe80::fe", 255, layers.ICMPv6TypeRouterAdvertisement, ra, t0.Add(time.Minute)))
+
+    want := []topology.Binding{
+        {IP: "2001:db8:1::2", MAC: "02:00:00:00:00:02", Interface: "ens1", Device: localHostname, Source: "nd",
+            FirstSeen: t0, LastSeen: t0, Count: 1},
+        {IP: "fe80::1", MAC: "02:00:00:00:00:01", Interface: "ens1", Device: localHostname, Source: "nd",
+            FirstSeen: t0, LastSeen: t0, Count: 1},
+        {IP: "fe80::fe", MAC: "02:00:00:00:00:fe", Interface: "ens1", Device: localHostname, Source: "nd",
+            FirstSeen: t0, LastSeen: t0.Add(time.Minute), Count: 2},
+    }
+    if got := a.bindingsSnapshot(); !reflect.DeepEqual(got, want) {
+        t.Errorf("bindingsSnapshot() =\n%+v\nwant\n%+v", got, want)
+    }
+
+    wantRouters := []topology.Router{{
+        Interface: "ens1", Device: localHostname, IP: "fe80::fe", MAC: "02:00:00:00:00:fe",
+        Lifetime: 1800, HopLimit: 64, Managed: true, MTU: 9000,
+        Prefixes: []topology.Prefix{{Prefix: "2001:db8:1::/64", OnLink: true, Autonomous: true,
+            ValidLifetime: 3600, PreferredLifetime: 1800}},
+        FirstSeen: t0, LastSeen: t0.Add(time.Minute), Count: 2,
+    }}
+    if got := a.routersSnapshot(); !reflect.DeepEqual(got, wantRouters) {
+        t.Errorf("routersSnapshot() =\n%+v\nwant\n%+v", got, wantRouters)
+    }
+}
+
+func TestBuildL3ReportIPv6(t *testing.T) {
+    rs := []topology.Router{{Interface: "ens1", IP: "fe80::fe", Prefixes: []topology.Prefix{
+        {Prefix: "2001:db8:1::/64", OnLink: true},
+        {Prefix: "2001:db8:ff::/64"}, // not on-link: says nothing about this segment
+    }}}
+    bs := []topology.Binding{
+        {IP: "2001:db8:1::2", MAC: "02:00:00:00:00:02", Interface: "ens1", Source: "nd"},
+        {IP: "2001:db8:2::7", MAC: "02:00:00:00:00:07", Interface: "ens1", Source: "nd"},
+        {IP: "fe80::fe", MAC: "02:00:00:00:00:fe", Interface: "ens1", Source: "nd"},
+    }
+
+    report := buildL3Report(bs, rs, nil, nil)
+
+    wantSubnets := []topology.Subnet{
+        {Interface: "ens1", Advertised: []string{"2001:db8:1::/64"}, Neighbors: 3, OffSubnet: 1},
+    }
+    if !reflect.DeepEqual(report.Subnets, wantSubnets) {
+        t.Errorf("Subnets = %+v, want %+v", report.Subnets, wantSubnets)
+    }
+    if len(report.Findings) != 1 || report.Findings[0].Check != "off-subnet" || report.Findings[0].Remote != "2001:db8:2::7" {
+        t.Errorf("Findings = %+v, want one off-subnet finding for 2001:db8:2::7", report.Findings)
+    }
+    if !reflect.DeepEqual(report.Routers, rs) {
+        t.Errorf("Routers = %+v", report.Routers)
+    }
+}
+
+func TestAttachBindings(t *testing.T) {
+    edges := []topology.Edge{
+        {Local: topology.Node{Interface: "ens1"}, Remote: topology.Node{Device: "leaf01", MAC: "02:00:00:00:00:aa"}},
+        {Local: topology.Node{Interface: "ens2"}, Remote: topology.Node{Device: "leaf02", MAC: "02:00:00:00:00:bb",
+            ChassisID: "02:00:00:00:00:cc", ChassisIDSubtype: "mac-address"}},
+        {Local: topology.Node{Interface: "ens3"}, Remote: topology.Node{Device: "leaf03", MAC: "02:00:00:00:00:dd"}},
+    }
+    bs := []topology.Binding{
+        {IP: "10.1.0.254", MAC: "02:00:00:00:00:aa", Interface: "ens1"},
+        {IP: "10.2.0.254", MAC: "02:00:00:00:00:cc", Interface: "ens2"}, // routed port answering with the system MAC
+        {IP: "10.3.0.254", MAC: "02:00:00:00:00:dd", Interface: "ens1"}, // seen on another interface
+    }
+    edges = attachBindings(edges, bs)
+    for i, want := range [][]string{{"10.1.0.254"}, {"10.2.0.254"}, nil} {
+        if got := edges[i].Remote.IPs; !reflect.DeepEqual(got, want) {
+            t.Errorf("edge %d remote IPs = %v, want %v", i, got, want)
+        }
+    }
+}
+
+func TestBuildL3Report(t *testing.T) {
+    _, ens1Net, _ := net.ParseCIDR("10.1.0.0/24")
+    ens1Net.IP = net.ParseIP("10.1.0.5").To4()
+    configured := map[string][]*net.IPNet{"ens1": {ens1Net}, "ens2": nil}
+    localMACs := map[string]string{"ens1": "02:00:00:00:00:05", "ens2": "02:00:00:00:00:06"}
+    bs := []topology.Binding{
+        {IP: "10.1.0.1", MAC: "02:00:00:00:00:01", Interface: "ens1", Source: "arp"},
+        {IP: "10.1.0.2", MAC: "02:00:00:00:00:02", Interface: "ens1", Source: "arp"},
+        {IP: "10.1.0.5", MAC: "02:00:00:00:00:99", Interface: "ens1", Source: "garp"}, // someone else claims our IP
+        {IP: "10.9.0.7", MAC: "02:00:00:00:00:07", Interface: "ens1", Source: "arp"},  // wrong IP on this rail
+    }
+
+    report := buildL3Report(bs, nil, configured, localMACs)
+
+    wantSubnets := []topology.Subnet{
+        {Interface: "ens1", Configured: []string{"10.1.0.5/24"}, Neighbors: 4, OffSubnet: 1},
+        {Interface: "ens2"},
+    }
+    if !reflect.DeepEqual(report.Subnets, wantSubnets) {
+        t.Errorf("Subnets = %+v, want %+v", report.Subnets, wantSubnets)
+    }
+    checks := make(map[string]topology.Finding)
+    for _, f := range report.Findings {
+        checks[f.Check] = f
+    }
+    if f, ok := checks["duplicate-ip"]; !ok || f.Remote != "10.1.0.5" || len(f.Evidence) != 2 {
+        t.Errorf("duplicate-ip finding = %+v", f)
+    }
+    if f, ok := checks["off-subnet"]; !ok || f.Remote != "10.9.0.7" {
+        t.Errorf("off-subnet finding = %+v", f)
+    }
+    if len(report.Findings) != 2 {
+        t.Errorf("Findings = %+v, want 2", report.Findings)
+    }
+}
+
+func TestInferSubnet(t *testing.T) {
+    ips := func(s ...string) []net.IP {
+        var out []net.IP
+        for _, v := range s {
+            out = append(out, net.ParseIP(v))
+        }
+        return out
+    }
+    tests := []struct {
+        ips  []net.IP
+        want string
+    }{
+        {ips("10.1.0.1"), ""},
+        {ips("10.1.0.1", "10.1.0.2"), "10.1.0.0/30"},
+        {ips("10.1.0.1", "10.1.0.200", "10.1.0.77"), "10.1.0.0/24"},
+        {ips("10.1.0.1", "10.1.255.1"), "10.1.0.0/16"},
+        {ips("10.1.0.1", "10.2.0.1"), ""},
+    }
+    for _, tt := range tests {
+        if got := inferSubnet(tt.ips); got != tt.want {
+            t.Errorf("inferSubnet(%v) = %q, want %q", tt.ips, got, tt.want)
+        }
+    }
+}
+
+func TestSnapshotL3(t *testing.T) {
+    a := testAggregator()
+    if s := currentSnapshot(a, nil); s.L3 != nil {
+        t.Errorf("L3 = %+v, want none without bindings or routers", s.L3)
+    }
+
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    a.processPacket("ens1", arpPacket(t, layers.ARPRequest, "02:00:00:00:00:01", "10.1.0.1", "10.1.0.9", t0))
+    a.processPacket("ens1", arpPacket(t, layers.ARPRequest, "02:00:00:00:00:02", "10.1.0.1", "10.1.0.9", t0))
+    s := currentSnapshot(a, nil)
+    if s.L3 == nil || len(s.L3.Bindings) != 2 || len(s.L3.Subnets) != 1 {
+        t.Fatalf("L3 = %+v, want both bindings and the subnet of ens1", s.L3)
+    }
+    if f := s.L3.Findings; len(f) != 1 || f[0].Check != "duplicate-ip" || f[0].Remote != "10.1.0.1" {
+        t.Errorf("L3 findings = %+v, want 10.1.0.1 as a duplicate", f)
+    }
+}
+
+func TestSnapshotRouters(t *testing.T) {
+    a := testAggregator()
+    prefix := make([]byte, 30)
+    prefix[0], prefix[1] = 64, 0x80 // /64, on-link
+    copy(prefix[14:], net.ParseIP("2001:db8:1::"))
+    ra := &layers.ICMPv6RouterAdvertisement{
+        RouterLifetime: 1800,
+        Options:        layers.ICMPv6Options{{Type: layers.ICMPv6OptPrefixInfo, Data: prefix}},
+    }
+    a.processPacket("ens1", ndPacket(t, "02:00:00:00:00:fe", "fe80::fe", 255, layers.ICMPv6TypeRouterAdvertisement, ra, time.Now()))
+
+    // Routers are in the snapshot even where they are the only L3 neighbors, and survive a save and load.
+    path := filepath.Join(t.TempDir(), "snapshot.json")
+    if err := topology.SaveSnapshot(path, currentSnapshot(a, nil)); err != nil {
+        t.Fatal(err)
+    }
+    s, err := topology.LoadSnapshot(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s.L3 == nil || len(s.L3.Routers) != 1 {
+        t.Fatalf("L3 = %+v, want the router", s.L3)
+    }
+    r := s.L3.Routers[0]
+    if r.Interface != "ens1" || r.IP != "fe80::fe" || r.Lifetime != 1800 || len(r.Prefixes) != 1 || r.Prefixes[0].Prefix != "2001:db8:1::/64" {
+        t.Errorf("router = %+v", r)
+    }
+    if len(s.L3.Subnets) != 1 || !reflect.DeepEqual(s.L3.Subnets[0].Advertised, []string{"2001:db8:1::/64"}) {
+        t.Errorf("subnets = %+v, want 2001:db8:1::/64 advertised on ens1", s.L3.Subnets)
+    }
+}
@@ -0,0 +1,168 @@
+// Package lacp decodes IEEE 802.1AX (formerly 802.3ad) Link Aggregation Control Protocol data units.
+//
+// LACPDUs are Slow Protocols frames: EtherType 0x8809 with subtype 1, sent to 01:80:c2:00:00:02.
+// Parse decodes the Actor, Partner and Collector information TLVs of a version 1 LACPDU.
+package lacp
+
+import (
+    "encoding/binary"
+    "errors"
+    "fmt"
+    "net"
+    "strings"
+)
+
+// EtherType is the Slow Protocols Ethernet type shared by LACP, Marker and OAM.
+const EtherType = 0x8809
+
+// SubtypeLACP is the Slow Protocols subtype of LACP.
+const SubtypeLACP = 1
+
+// MulticastMAC is the Slow Protocols destination address (01:80:c2:00:00:02).
+var MulticastMAC = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x02}
+
+// TLV types of a LACPDU.
+const (
+    TLVTerminator = 0
+    TLVActor      = 1
+    TLVPartner    = 2
+    TLVCollector  = 3
+)
+
+// Actor and Partner information TLVs are 20 bytes including the type and length.
+const portInfoLen = 20
+
+// ErrNotLACP is returned for Slow Protocols frames of another subtype (Marker, OAM, ...).
+var ErrNotLACP = errors.New("lacp: not a LACPDU")
+
+// PDU is a decoded LACPDU. Actor is the sender; Partner is what the sender knows of its peer.
+type PDU struct {
+    Version           int  `json:"version"`
+    Actor             Port `json:"actor"`
+    Partner           Port `json:"partner"`
+    CollectorMaxDelay int  `json:"collector_max_delay,omitempty"` // tens of microseconds
+}
+
+// Port is the Actor or Partner information of a LACPDU. System and Key identify the
+// aggregation the port belongs to; ports with the same System and Key may be bundled.
+type Port struct {
+    SystemPriority int    `json:"system_priority"`
+    System         string `json:"system"` // MAC address
+    Key            int    `json:"key"`
+    PortPriority   int    `json:"port_priority"`
+    Port           int    `json:"port"`
+    State          State  `json:"state"`
+}
+
+// State is the decoded Actor_State/Partner_State octet.
+type State struct {
+    Active       bool `json:"active,omitempty"`        // LACP activity: active rather than passive
+    ShortTimeout bool `json:"short_timeout,omitempty"` // 1s rather than 30s LACPDU interval
+    Aggregatable bool `json:"aggregatable,omitempty"`
+    InSync       bool `json:"in_sync,omitempty"` // attached to the right aggregator
+    Collecting   bool `json:"collecting,omitempty"`
+    Distributing bool `json:"distributing,omitempty"`
+    Defaulted    bool `json:"defaulted,omitempty"` // using administrative defaults: no LACPDUs from the peer
+    Expired      bool `json:"expired,omitempty"`
+}
+
+// decodeState unpacks the state bits (bit 0 is LACP activity).
+func decodeState(b byte) State {
+    return State{
+        Active:       b&0x01 != 0,
+        ShortTimeout: b&0x02 != 0,
+        Aggregatable: b&0x04 != 0,
+        InSync:       b&0x08 != 0,
+        Collecting:   b&0x10 != 0,
+        Distributing: b&0x20 != 0,
+        Defaulted:    b&0x40 != 0,
+        Expired:      b&0x80 != 0,
+    }
+}
+
+// Up reports whether the port is in sync and both collecting and distributing,
+// i.e. actually carrying traffic as a member of its aggregation.
+func (s State) Up() bool {
+    return s.InSync && s.Collecting && s.Distributing
+}
+
+// String lists the set state flags, e.g. "active,aggregatable,in-sync,collecting,distributing".
+func (s State) String() string {
+    var flags []string
+    for _, f := range []struct {
+        set  bool
+        name string
+    }{
+        {s.Active, "active"},
+        {s.ShortTimeout, "short-timeout"},
+        {s.Aggregatable, "aggregatable"},
+        {s.InSync, "in-sync"},
+        {s.Collecting, "collecting"},
+        {s.Distributing, "distributing"},
+        {s.Defaulted, "defaulted"},
+        {s.Expired, "expired"},
+    } {
+        if f.set {
+            flags = append(flags, f.name)
+        }
+    }
+    if len(flags) == 0 {
+        return "none"
+    }
+    return strings.Join(flags, ",")
+}
+
+// Parse decodes a LACPDU from the Slow Protocols payload that follows the Ethernet header.
+func Parse(payload []byte) (PDU, error) {
+    var pdu PDU
+    if len(payload) < 2 {
+        return pdu, fmt.Errorf("lacp: short frame (%d bytes)", len(payload))
+    }
+    if payload[0] != SubtypeLACP {
+        return pdu, ErrNotLACP
+    }
+    pdu.Version = int(payload[1])
+
+    var haveActor, havePartner bool
+    for tlvs := payload[2:]; len(tlvs) >= 2; {
+        tlvType, tlvLen := tlvs[0], int(tlvs[1])
+        if tlvType == TLVTerminator {
+            break
+        }
+        if tlvLen < 2 || tlvLen > len(tlvs) {
+            return pdu, fmt.Errorf("lacp: TLV %d has bad length %d", tlvType, tlvLen)
+        }
+        value := tlvs[2:tlvLen]
+        tlvs = tlvs[tlvLen:]
+
+        switch tlvType {
+        case TLVActor, TLVPartner:
+            if tlvLen != portInfoLen {
+                return pdu, fmt.Errorf("lacp: TLV %d has length %d, want %d", tlvType, tlvLen, portInfoLen)
+            }
+            // [system priority x2][system x6][key x2][port priority x2][port x2][state][reserved x3]
+            port := Port{
+                SystemPriority: int(binary.BigEndian.Uint16(value[0:2])),
+                System:         net.HardwareAddr(value[2:8]).String(),
+                Key:            int(binary.BigEndian.Uint16(value[8:10])),
+                PortPriority:   int(binary.BigEndian.Uint16(value[10:12])),
+                Port:           int(binary.BigEndian.Uint16(value[12:14])),
+                State:          decodeState(value[14]),
+            }
+            if tlvType == TLVActor {
+                pdu.Actor, haveActor = port, true
+            } else {
+                pdu.Partner, havePartner = port, true
+            }
+        case TLVCollector:
+            // [max delay x2][reserved x12]
+            if len(value) >= 2 {
+                pdu.CollectorMaxDelay = int(binary.BigEndian.Uint16(value[0:2]))
+            }
+        }
+    }
+    if !haveActor || !havePartner {
+        return pdu, errors.New("lacp: missing actor or partner information")
+    }
+    return pdu, nil
+}
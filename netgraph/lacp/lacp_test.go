@@ -0,0 +1,72 @@
+package lacp
+
+import (
+    "errors"
+    "testing"
+)
+
+// portTLV builds an Actor or Partner information TLV.
+func portTLV(tlvType byte, system [6]byte, key, port uint16, state byte) []byte {
+    tlv := []byte{tlvType, portInfoLen, 0x80, 0x00}
+    tlv = append(tlv, system[:]...)
+    tlv = append(tlv, byte(key>>8), byte(key), 0x00, 0xff, byte(port>>8), byte(port), state, 0, 0, 0)
+    return tlv
+}
+
+// lacpdu builds a version 1 LACPDU as carried after the Ethernet header.
+func lacpdu(actor, partner []byte) []byte {
+    pdu := []byte{SubtypeLACP, 1}
+    pdu = append(pdu, actor...)
+    pdu = append(pdu, partner...)
+    pdu = append(pdu, TLVCollector, 16, 0x00, 0x05)
+    pdu = append(pdu, make([]byte, 12)...)
+    pdu = append(pdu, TLVTerminator, 0)
+    return append(pdu, make([]byte, 50)...)
+}
+
+func TestParse(t *testing.T) {
+    sw := [6]byte{0x02, 0x1c, 0x73, 0x00, 0x00, 0x01}
+    host := [6]byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+    pdu, err := Parse(lacpdu(portTLV(TLVActor, sw, 1001, 17, 0x3d), portTLV(TLVPartner, host, 15, 1, 0x0f)))
+    if err != nil {
+        t.Fatalf("Parse: %v", err)
+    }
+    want := PDU{
+        Version: 1,
+        Actor: Port{SystemPriority: 32768, System: "02:1c:73:00:00:01", Key: 1001, PortPriority: 255, Port: 17,
+            State: State{Active: true, Aggregatable: true, InSync: true, Collecting: true, Distributing: true}},
+        Partner: Port{SystemPriority: 32768, System: "02:00:00:00:00:01", Key: 15, PortPriority: 255, Port: 1,
+            State: State{Active: true, ShortTimeout: true, Aggregatable: true, InSync: true}},
+        CollectorMaxDelay: 5,
+    }
+    if pdu != want {
+        t.Errorf("Parse() =\n%+v\nwant\n%+v", pdu, want)
+    }
+    if !pdu.Actor.State.Up() || pdu.Partner.State.Up() {
+        t.Errorf("Up() = %v/%v, want true/false", pdu.Actor.State.Up(), pdu.Partner.State.Up())
+    }
+    if got, want := pdu.Partner.State.String(), "active,short-timeout,aggregatable,in-sync"; got != want {
+        t.Errorf("State.String() = %q, want %q", got, want)
+    }
+}
+
+func TestParseErrors(t *testing.T) {
+    sys := [6]byte{0x02, 0, 0, 0, 0, 1}
+    actor := portTLV(TLVActor, sys, 1, 1, 0)
+    tests := []struct {
+        name    string
+        payload []byte
+    }{
+        {"short", []byte{SubtypeLACP}},
+        {"no partner", lacpdu(actor, nil)},
+        {"bad length", append([]byte{SubtypeLACP, 1, TLVActor, 40}, actor[2:]...)},
+    }
+    for _, tt := range tests {
+        if _, err := Parse(tt.payload); err == nil {
+            t.Errorf("%s: Parse succeeded, want error", tt.name)
+        }
+    }
+    if _, err := Parse([]byte{2, 1, 0, 0}); !errors.Is(err, ErrNotLACP) {
+        t.Errorf("Parse(marker) error = %v, want ErrNotLACP", err)
+    }
+}
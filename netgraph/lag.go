@@ -0,0 +1,229 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/lacp"
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+// lacpPort is the latest LACPDU received on a local interface.
+type lacpPort struct {
+    PDU      lacp.PDU
+    LastSeen time.Time
+    Frames   int
+}
+
+// recordLACP stores pdu as the latest LACPDU received on ifName. a.mu must be held.
+func (a *aggregator) recordLACP(ifName string, pdu lacp.PDU, seen time.Time) {
+    p, ok := a.lacpPorts[ifName]
+    if !ok {
+        p = &lacpPort{}
+        a.lacpPorts[ifName] = p
+    }
+    if seen.Before(p.LastSeen) {
+        p.Frames++
+        return
+    }
+    p.PDU, p.LastSeen = pdu, seen
+    p.Frames++
+}
+
+// ownLACPLocked reports whether pdu, received on ifName from srcMAC, was sent by this host:
+// it comes from the MAC of a captured interface, or its actor is a local MAC (the bond's
+// system ID) or the system the switch last reported as its partner on ifName. a.mu must be held.
+func (a *aggregator) ownLACPLocked(ifName, srcMAC string, pdu lacp.PDU) bool {
+    for _, st := range a.ifaces {
+        if st.MAC != "" && (st.MAC == srcMAC || st.MAC == pdu.Actor.System) {
+            return true
+        }
+    }
+    p, ok := a.lacpPorts[ifName]
+    return ok && p.PDU.Partner.System != "" && p.PDU.Partner.System == pdu.Actor.System
+}
+
+// lacpSnapshot returns a copy of the LACP state of every interface.
+func (a *aggregator) lacpSnapshot() map[string]lacpPort {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    out := make(map[string]lacpPort, len(a.lacpPorts))
+    for name, p := range a.lacpPorts {
+        out[name] = *p
+    }
+    return out
+}
+
+// readBond returns the bond master ifName is enslaved to, from /sys/class/net/<if>/master.
+func readBond(ifName string) string {
+    target, err := os.Readlink(filepath.Join(sysfsRoot, "class", "net", ifName, "master"))
+    if err != nil {
+        return ""
+    }
+    return filepath.Base(target)
+}
+
+// localBonds maps each interface that received LACPDUs to its bond master, if known.
+func localBonds(ports map[string]lacpPort) map[string]string {
+    bonds := make(map[string]string)
+    if offlineReplay {
+        return bonds
+    }
+    for name := range ports {
+        if bond := readBond(name); bond != "" {
+            bonds[name] = bond
+        }
+    }
+    return bonds
+}
+
+// bundleLAGs groups the interfaces that speak LACP into logical LAGs and annotates the
+// member edges with their LACP information, LAG and findings. Members are grouped by
+// bond master, or by the LACP system and key the remote ports hold for us when the bond
+// is unknown (e.g. in a replay). edges is modified in place and returned.
+func bundleLAGs(edges []topology.Edge, ports map[string]lacpPort, bonds map[string]string) ([]topology.Edge, []topology.LAG) {
+    groups := make(map[string]*topology.LAG)
+    memberOf := make(map[string]*topology.LAG)
+    for name, p := range ports {
+        id := bonds[name]
+        if id == "" {
+            id = fmt.Sprintf("%s/%d", p.PDU.Partner.System, p.PDU.Partner.Key)
+        }
+        lag, ok := groups[id]
+        if !ok {
+            lag = &topology.LAG{
+                ID:     id,
+                Device: localHostname,
+                Bond:   bonds[name],
+                System: p.PDU.Partner.System,
+                Key:    p.PDU.Partner.Key,
+            }
+            groups[id] = lag
+        }
+        lag.Members = append(lag.Members, name)
+        lag.PartnerSystems = appendUnique(lag.PartnerSystems, p.PDU.Actor.System)
+        memberOf[name] = lag
+    }
+    for _, e := range edges {
+        if lag := memberOf[e.Local.Interface]; lag != nil {
+            lag.RemoteDevices = appendUnique(lag.RemoteDevices, e.Remote.Device)
+        }
+    }
+
+    lags := make([]topology.LAG, 0, len(groups))
+    for _, lag := range groups {
+        sort.Strings(lag.Members)
+        sort.Strings(lag.PartnerSystems)
+        sort.Strings(lag.RemoteDevices)
+        lag.MLAG = len(lag.PartnerSystems) > 1 || len(lag.RemoteDevices) > 1
+        lags = append(lags, *lag)
+    }
+    sort.Slice(lags, func(i, j int) bool { return lags[i].ID < lags[j].ID })
+
+    for i := range edges {
+        e := &edges[i]
+        lag := memberOf[e.Local.Interface]
+        if lag == nil {
+            continue
+        }
+        p := ports[e.Local.Interface]
+        actor, partner := p.PDU.Actor, p.PDU.Partner
+        e.Remote.LACP = &actor
+        e.Local.LACP = &partner
+        e.Local.Bond = lag.Bond
+        e.LAG = lag
+        if findings := lagFindings(*lag, e.Local.Interface, p); len(findings) > 0 {
+            // Copy so the findings of the stored edge are not appended to.
+            e.Findings = append(append([]topology.Finding(nil), e.Findings...), findings...)
+        }
+    }
+    return edges, lags
+}
+
+// lagFindings checks one member of lag: it must be in sync, collecting and distributing on
+// both ends, and all members must be aggregated with the same partner system.
+func lagFindings(lag topology.LAG, member string, p lacpPort) []topology.Finding {
+    var findings []topology.Finding
+    actor, partner := p.PDU.Actor.State, p.PDU.Partner.State
+    if !actor.Up() || !partner.Up() {
+        findings = append(findings, topology.Finding{
+            Check:    "lacp-sync",
+            Severity: topology.SeverityError,
+            Message:  fmt.Sprintf("%s member %s is not in sync, collecting and distributing", lag.ID, member),
+            Local:    partner.String(),
+            Remote:   actor.String(),
+            Evidence: []string{
+                fmt.Sprintf("remote %s port %d key %d: %s", p.PDU.Actor.System, p.PDU.Actor.Port, p.PDU.Actor.Key, actor),
+                fmt.Sprintf("local as seen by remote, %s port %d key %d: %s", p.PDU.Partner.System, p.PDU.Partner.Port, p.PDU.Partner.Key, partner),
+            },
+        })
+    }
+    if len(lag.PartnerSystems) > 1 {
+        findings = append(findings, topology.Finding{
+            Check:    "lacp-partner",
+            Severity: topology.SeverityWarning,
+            Message: fmt.Sprintf("%s members are aggregated with different partner systems %s; only the members of one can be active unless the switches share an MLAG system ID",
+                lag.ID, strings.Join(lag.PartnerSystems, ", ")),
+            Local:  lag.System,
+            Remote: strings.Join(lag.PartnerSystems, ","),
+        })
+    }
+    return findings
+}
+
+// appendUnique appends s to list unless it is empty or already there.
+func appendUnique(list []string, s string) []string {
+    if s == "" {
+        return list
+    }
+    for _, v := range list {
+        if v == s {
+            return list
+        }
+    }
+    return append(list, s)
+}
+
+// currentLAGs bundles the live LACP state into LAGs.
+func currentLAGs(a *aggregator) []topology.LAG {
+    ports := a.lacpSnapshot()
+    _, lags := bundleLAGs(nil, ports, localBonds(ports))
+    return lags
+}
+
+// printLAGReport prints each LAG with its members' LACP state.
+func printLAGReport(w io.Writer, lags []topology.LAG, ports map[string]lacpPort) {
+    if len(lags) == 0 {
+        return
+    }
+    fmt.Fprintf(w, "Link aggregation (%d LAGs):\n", len(lags))
+    for _, lag := range lags {
+        kind := "LAG"
+        if lag.MLAG {
+            kind = "MLAG"
+        }
+        fmt.Fprintf(w, "  %s: %s of %s to %s", lag.ID, kind, strings.Join(lag.Members, ","), strings.Join(lag.PartnerSystems, ","))
+        if len(lag.RemoteDevices) > 0 {
+            fmt.Fprintf(w, " (%s)", strings.Join(lag.RemoteDevices, ", "))
+        }
+        fmt.Fprintln(w)
+        for _, m := range lag.Members {
+            p := ports[m]
+            status := "up"
+            if !p.PDU.Actor.State.Up() || !p.PDU.Partner.State.Up() {
+                status = "OUT OF SYNC"
+            }
+            fmt.Fprintf(w, "    %s: %s, remote port %d [%s], local [%s]\n",
+                m, status, p.PDU.Actor.Port, p.PDU.Actor.State, p.PDU.Partner.State)
+        }
+        if len(lag.PartnerSystems) > 1 {
+            fmt.Fprintf(w, "    WARNING members are aggregated with %d different partner systems\n", len(lag.PartnerSystems))
+        }
+    }
+    fmt.Fprintln(w)
+}
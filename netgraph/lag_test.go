@@ -0,0 +1,144 @@
+package main
+
+import (
+    "net"
+    "reflect"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/lacp"
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+    "github.com/gopacket/gopacket"
+    "github.com/gopacket/gopacket/layers"
+)
+
+// lacpPacket builds a LACPDU frame from a switch port (actor) that holds partner as its view of us.
+func lacpPacket(t *testing.T, srcMAC string, actor, partner lacp.Port, actorState, partnerState byte) gopacket.Packet {
+    t.Helper()
+    info := func(tlvType byte, p lacp.Port, state byte) []byte {
+        sys, _ := net.ParseMAC(p.System)
+        b := []byte{tlvType, 20, 0x80, 0x00}
+        b = append(b, sys...)
+        return append(b, byte(p.Key>>8), byte(p.Key), 0x00, 0xff, byte(p.Port>>8), byte(p.Port), state, 0, 0, 0)
+    }
+    pdu := []byte{lacp.SubtypeLACP, 1}
+    pdu = append(pdu, info(lacp.TLVActor, actor, actorState)...)
+    pdu = append(pdu, info(lacp.TLVPartner, partner, partnerState)...)
+    pdu = append(pdu, lacp.TLVTerminator, 0)
+
+    mac, _ := net.ParseMAC(srcMAC)
+    eth := &layers.Ethernet{SrcMAC: mac, DstMAC: lacp.MulticastMAC, EthernetType: layers.EthernetType(lacp.EtherType)}
+    buf := gopacket.NewSerializeBuffer()
+    if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, gopacket.Payload(pdu)); err != nil {
+        t.Fatal(err)
+    }
+    packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+    packet.Metadata().Timestamp = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    return packet
+}
+
+const lacpUp = 0x3d // active, aggregatable, in sync, collecting, distributing
+
+func TestLACPBundling(t *testing.T) {
+    a := testAggregator()
+    host := lacp.Port{System: "02:00:00:00:00:01", Key: 15}
+    mlagPair := "02:1c:73:00:00:99" // the shared system ID of leaf01a/leaf01b
+
+    // bond0: an MLAG pair presenting one system ID, with ens2 stuck out of sync.
+    ens1, ens2 := host, host
+    ens1.Port, ens2.Port = 1, 2
+    a.processPacket("ens1", lacpPacket(t, "02:1c:73:00:00:0a", lacp.Port{System: mlagPair, Key: 1001, Port: 17}, ens1, lacpUp, lacpUp))
+    a.processPacket("ens2", lacpPacket(t, "02:1c:73:00:00:0b", lacp.Port{System: mlagPair, Key: 1001, Port: 1017}, ens2, lacpUp, 0x05))
+    // bond1: members landed on two unrelated switches.
+    ens3, ens4 := host, host
+    ens3.Key, ens3.Port, ens4.Key, ens4.Port = 16, 3, 16, 4
+    a.processPacket("ens3", lacpPacket(t, "02:1c:73:00:00:0c", lacp.Port{System: "02:1c:73:00:00:0c", Key: 5, Port: 1}, ens3, lacpUp, lacpUp))
+    a.processPacket("ens4", lacpPacket(t, "02:1c:73:00:00:0d", lacp.Port{System: "02:1c:73:00:00:0d", Key: 5, Port: 1}, ens4, lacpUp, lacpUp))
+
+    edges := []topology.Edge{
+        {Local: topology.Node{Interface: "ens1"}, Remote: topology.Node{Device: "leaf01a", Interface: "Ethernet17"}},
+        {Local: topology.Node{Interface: "ens2"}, Remote: topology.Node{Device: "leaf01b", Interface: "Ethernet17"}},
+        {Local: topology.Node{Interface: "ens5"}, Remote: topology.Node{Device: "leaf03", Interface: "Ethernet1"}},
+    }
+    ports := a.lacpSnapshot()
+    edges, lags := bundleLAGs(edges, ports, map[string]string{"ens1": "bond0", "ens2": "bond0"})
+
+    want := []topology.LAG{
+        {ID: "02:00:00:00:00:01/16", Device: localHostname, System: "02:00:00:00:00:01", Key: 16,
+            Members: []string{"ens3", "ens4"}, PartnerSystems: []string{"02:1c:73:00:00:0c", "02:1c:73:00:00:0d"}, MLAG: true},
+        {ID: "bond0", Device: localHostname, Bond: "bond0", System: "02:00:00:00:00:01", Key: 15,
+            Members: []string{"ens1", "ens2"}, RemoteDevices: []string{"leaf01a", "leaf01b"}, PartnerSystems: []string{mlagPair}, MLAG: true},
+    }
+    if !reflect.DeepEqual(lags, want) {
+        t.Errorf("bundleLAGs() lags =\n%+v\nwant\n%+v", lags, want)
+    }
+
+    if edges[0].LAG == nil || edges[0].LAG.ID != "bond0" || edges[0].Local.Bond != "bond0" || edges[0].Remote.LACP.Port != 17 {
+        t.Errorf("edge 0 = %+v", edges[0])
+    }
+    if len(edges[0].Findings) != 0 {
+        t.Errorf("edge 0 findings = %+v, want none", edges[0].Findings)
+    }
+    if len(edges[1].Findings) != 1 || edges[1].Findings[0].Check != "lacp-sync" || edges[1].Findings[0].Local != "active,aggregatable" {
+        t.Errorf("edge 1 findings = %+v, want one lacp-sync finding", edges[1].Findings)
+    }
+    if edges[2].LAG != nil || edges[2].Local.LACP != nil {
+        t.Errorf("edge 2 = %+v, want no LAG", edges[2])
+    }
+
+    for _, m := range []string{"ens3", "ens4"} {
+        findings := lagFindings(lags[0], m, ports[m])
+        if len(findings) != 1 || findings[0].Check != "lacp-partner" {
+            t.Errorf("lagFindings(%s) = %+v, want one lacp-partner finding", m, findings)
+        }
+    }
+}
+
+func TestOwnLACPDUs(t *testing.T) {
+    // ens1 carries the bond MAC; ens2's is unknown, as in a replay.
+    a := testAggregator(
+        topology.InterfaceStatus{Name: "ens1", State: ifaceStateCapturing, MAC: "02:00:00:00:00:01"},
+        topology.InterfaceStatus{Name: "ens2", State: ifaceStateCapturing},
+    )
+    host := lacp.Port{System: "02:00:00:00:00:01", Key: 15, Port: 1}
+    leaf := lacp.Port{System: "02:1c:73:00:00:99", Key: 1001, Port: 17}
+    for _, ifName := range []string{"ens1", "ens2"} {
+        a.processPacket(ifName, lacpPacket(t, "02:1c:73:00:00:0a", leaf, host, lacpUp, lacpUp))
+        // Our own LACPDU on the way out, sent from the member's permanent MAC.
+        a.processPacket(ifName, lacpPacket(t, "02:00:00:00:00:11", host, leaf, lacpUp, lacpUp))
+    }
+
+    ports := a.lacpSnapshot()
+    for _, ifName := range []string{"ens1", "ens2"} {
+        if p := ports[ifName]; p.PDU.Actor.System != leaf.System || p.PDU.Partner.System != host.System || p.Frames != 1 {
+            t.Errorf("%s = %+v, want only the switch's LACPDU", ifName, p)
+        }
+    }
+    if n := a.neighborsSnapshot(); len(n) != 2 {
+        t.Errorf("neighborsSnapshot() = %+v, want the switch on each port", n)
+    }
+    _, lags := bundleLAGs(nil, ports, map[string]string{"ens1": "bond0", "ens2": "bond0"})
+    if len(lags) != 1 || lags[0].MLAG || !reflect.DeepEqual(lags[0].PartnerSystems, []string{leaf.System}) || lags[0].System != host.System {
+        t.Errorf("bundleLAGs() = %+v, want bond0 with the switch as its only partner", lags)
+    }
+    for _, m := range []string{"ens1", "ens2"} {
+        if findings := lagFindings(lags[0], m, ports[m]); len(findings) != 0 {
+            t.Errorf("lagFindings(%s) = %+v, want none", m, findings)
+        }
+    }
+}
+
+func TestReadBond(t *testing.T) {
+    fakeSysfs(t, map[string]string{
+        "devices/virtual/net/bond0/operstate": "up\n",
+        "class/net/ens1/operstate":            "up\n",
+    }, map[string]string{
+        "class/net/ens1/master": "../../../devices/virtual/net/bond0",
+    })
+    if got := readBond("ens1"); got != "bond0" {
+        t.Errorf("readBond(ens1) = %q, want bond0", got)
+    }
+    if got := readBond("ens2"); got != "" {
+        t.Errorf("readBond(ens2) = %q, want none", got)
+    }
+}
@@ -0,0 +1,272 @@
+package lldp
+
+import (
+    "encoding/binary"
+    "fmt"
+)
+
+// OUICEE is the OUI of the pre-standard CEE (DCBX v1.01) TLV.
+var OUICEE = [3]byte{0x00, 0x1b, 0x21}
+
+// IEEE 802.1Qaz DCBX subtypes of the 802.1 org-specific TLV.
+const (
+    Dot1SubtypeETSConfiguration  = 9
+    Dot1SubtypeETSRecommendation = 10
+    Dot1SubtypePFCConfiguration  = 11
+    Dot1SubtypeAppPriority       = 12
+)
+
+// CEESubtypeDCBX is the DCBX v1.01 subtype of the CEE TLV, which nests its own feature TLVs.
+const CEESubtypeDCBX = 2
+
+// CEE feature sub-TLV types.
+const (
+    ceeTLVControl      = 1
+    ceeTLVPriorityGrp  = 2
+    ceeTLVPFC          = 3
+    ceeTLVApplications = 4
+)
+
+// DCBX modes, as recorded in DCBX.Mode.
+const (
+    DCBXModeIEEE = "ieee"
+    DCBXModeCEE  = "cee"
+)
+
+// DCBX holds the Data Center Bridging settings a port advertises.
+// In CEE mode ETS is built from the priority groups, and TSA is not known.
+type DCBX struct {
+    Mode              string        `json:"mode"`
+    PFC               *PFC          `json:"pfc,omitempty"`
+    ETS               *ETS          `json:"ets,omitempty"`
+    ETSRecommendation *ETS          `json:"ets_recommendation,omitempty"`
+    Applications      []AppPriority `json:"applications,omitempty"`
+}
+
+// PFC is the Priority-based Flow Control configuration.
+// Enabled lists the priorities (0-7) that are lossless.
+type PFC struct {
+    Willing    bool  `json:"willing"`
+    MBC        bool  `json:"mbc,omitempty"`
+    Capability int   `json:"capability"` // number of traffic classes that may be lossless at once
+    Enabled    []int `json:"enabled"`
+}
+
+// ETS is the Enhanced Transmission Selection configuration or recommendation.
+// PriorityTC maps each priority 0-7 to its traffic class; Bandwidth and TSA are indexed by traffic class.
+type ETS struct {
+    Willing    bool     `json:"willing,omitempty"`
+    CBS        bool     `json:"cbs,omitempty"`
+    MaxTCs     int      `json:"max_tcs,omitempty"`
+    PriorityTC []int    `json:"priority_tc"`
+    Bandwidth  []int    `json:"bandwidth"`
+    TSA        []string `json:"tsa,omitempty"`
+}
+
+// AppPriority maps an application (EtherType, port or DSCP value) to a priority.
+type AppPriority struct {
+    Priority int    `json:"priority"`
+    Selector string `json:"selector"` // ethertype, tcp, udp, tcp-udp or dscp
+    Protocol uint16 `json:"protocol"`
+}
+
+// RoCEv2UDPPort is the UDP destination port of RoCEv2, which switches map to the lossless priority.
+const RoCEv2UDPPort = 4791
+
+// ApplicationPriority returns the priority mapped to UDP port udpPort, or -1 if none is.
+func (d *DCBX) ApplicationPriority(udpPort uint16) int {
+    for _, app := range d.Applications {
+        if app.Protocol == udpPort && (app.Selector == "udp" || app.Selector == "tcp-udp") {
+            return app.Priority
+        }
+    }
+    return -1
+}
+
+// TrafficClass returns the traffic class priority p is mapped to, or -1 if unknown.
+func (e *ETS) TrafficClass(p int) int {
+    if e == nil || p < 0 || p >= len(e.PriorityTC) {
+        return -1
+    }
+    return e.PriorityTC[p]
+}
+
+// dcbx returns fields.DCBX, creating it in the given mode. IEEE takes precedence over CEE:
+// once a port has sent an IEEE TLV, CEE TLVs from it are ignored (nil is returned).
+func dcbx(fields *Fields, mode string) *DCBX {
+    if fields.DCBX == nil {
+        fields.DCBX = &DCBX{Mode: mode}
+    } else if fields.DCBX.Mode == DCBXModeCEE && mode == DCBXModeIEEE {
+        *fields.DCBX = DCBX{Mode: mode}
+    } else if fields.DCBX.Mode != mode {
+        return nil
+    }
+    return fields.DCBX
+}
+
+// parseIEEEDCBX decodes the 802.1Qaz subtypes of the 802.1 TLV.
+func parseIEEEDCBX(fields *Fields, subtype byte, info []byte) {
+    switch subtype {
+    case Dot1SubtypeETSConfiguration:
+        // [willing|CBS|reserved|max TCs][priority assignment x4][TC bandwidth x8][TSA x8]
+        if len(info) >= 21 {
+            ets := decodeETSTables(info[1:21])
+            ets.Willing = info[0]&0x80 != 0
+            ets.CBS = info[0]&0x40 != 0
+            ets.MaxTCs = int(info[0] & 0x07)
+            if ets.MaxTCs == 0 {
+                ets.MaxTCs = 8
+            }
+            dcbx(fields, DCBXModeIEEE).ETS = ets
+        }
+    case Dot1SubtypeETSRecommendation:
+        // [reserved][priority assignment x4][TC bandwidth x8][TSA x8]
+        if len(info) >= 21 {
+            dcbx(fields, DCBXModeIEEE).ETSRecommendation = decodeETSTables(info[1:21])
+        }
+    case Dot1SubtypePFCConfiguration:
+        // [willing|MBC|reserved|PFC capability][PFC enable bitmap]
+        if len(info) >= 2 {
+            dcbx(fields, DCBXModeIEEE).PFC = &PFC{
+                Willing:    info[0]&0x80 != 0,
+                MBC:        info[0]&0x40 != 0,
+                Capability: int(info[0] & 0x0f),
+                Enabled:    priorityBits(info[1]),
+            }
+        }
+    case Dot1SubtypeAppPriority:
+        // [reserved] then 3-byte entries: [priority(3)|reserved(2)|selector(3)][protocol x2]
+        if len(info) >= 1 {
+            d := dcbx(fields, DCBXModeIEEE)
+            for e := info[1:]; len(e) >= 3; e = e[3:] {
+                d.Applications = append(d.Applications, AppPriority{
+                    Priority: int(e[0] >> 5),
+                    Selector: ieeeAppSelector(e[0] & 0x07),
+                    Protocol: binary.BigEndian.Uint16(e[1:3]),
+                })
+            }
+        }
+    }
+}
+
+// decodeETSTables decodes the 20 bytes of priority assignment, bandwidth and TSA tables.
+func decodeETSTables(t []byte) *ETS {
+    ets := &ETS{
+        PriorityTC: make([]int, 8),
+        Bandwidth:  make([]int, 8),
+        TSA:        make([]string, 8),
+    }
+    for p := 0; p < 8; p++ {
+        ets.PriorityTC[p] = int(t[p/2]>>(4*uint(1-p%2))) & 0x0f
+    }
+    for tc := 0; tc < 8; tc++ {
+        ets.Bandwidth[tc] = int(t[4+tc])
+        ets.TSA[tc] = tsaName(t[12+tc])
+    }
+    return ets
+}
+
+func tsaName(v byte) string {
+    switch v {
+    case 0:
+        return "strict"
+    case 1:
+        return "cbs"
+    case 2:
+        return "ets"
+    case 255:
+        return "vendor"
+    }
+    return fmt.Sprintf("reserved-%d", v)
+}
+
+func ieeeAppSelector(sel byte) string {
+    switch sel {
+    case 1:
+        return "ethertype"
+    case 2:
+        return "tcp"
+    case 3:
+        return "udp"
+    case 4:
+        return "tcp-udp"
+    case 5:
+        return "dscp"
+    }
+    return fmt.Sprintf("reserved-%d", sel)
+}
+
+// priorityBits lists the priorities set in a bitmap where bit n is priority n.
+func priorityBits(bits byte) []int {
+    prios := []int{}
+    for p := 0; p < 8; p++ {
+        if bits&(1<<uint(p)) != 0 {
+            prios = append(prios, p)
+        }
+    }
+    return prios
+}
+
+// parseCEEDCBX decodes the feature sub-TLVs nested in a CEE DCBX v1.01 TLV.
+// Each feature starts with [oper version][max version][enable|willing|error|reserved][subtype].
+func parseCEEDCBX(fields *Fields, info []byte) {
+    for len(info) >= 2 {
+        header := binary.BigEndian.Uint16(info)
+        subType, subLen := header>>9, int(header&0x1FF)
+        if 2+subLen > len(info) {
+            return
+        }
+        value := info[2 : 2+subLen]
+        info = info[2+subLen:]
+
+        if subType == ceeTLVControl || len(value) < 4 {
+            continue
+        }
+        flags, data := value[2], value[4:]
+        d := dcbx(fields, DCBXModeCEE)
+        if d == nil {
+            return
+        }
+        switch subType {
+        case ceeTLVPriorityGrp:
+            // [PGID per priority x4][PG bandwidth % x8][number of TCs]
+            if len(data) >= 13 {
+                ets := &ETS{
+                    Willing:    flags&0x40 != 0,
+                    MaxTCs:     int(data[12]),
+                    PriorityTC: make([]int, 8),
+                    Bandwidth:  make([]int, 8),
+                }
+                for p := 0; p < 8; p++ {
+                    ets.PriorityTC[p] = int(data[p/2]>>(4*uint(1-p%2))) & 0x0f
+                    ets.Bandwidth[p] = int(data[4+p])
+                }
+                d.ETS = ets
+            }
+        case ceeTLVPFC:
+            // [PFC enable bitmap][number of TCs]
+            if len(data) >= 2 {
+                d.PFC = &PFC{
+                    Willing:    flags&0x40 != 0,
+                    Capability: int(data[1]),
+                    Enabled:    priorityBits(data[0]),
+                }
+            }
+        case ceeTLVApplications:
+            // 6-byte entries: [protocol x2][OUI upper 6 bits|selector(2)][OUI x2][priority bitmap]
+            for e := data; len(e) >= 6; e = e[6:] {
+                sel := "ethertype"
+                if e[2]&0x03 == 1 {
+                    sel = "tcp-udp"
+                }
+                for _, p := range priorityBits(e[5]) {
+                    d.Applications = append(d.Applications, AppPriority{
+                        Priority: p,
+                        Selector: sel,
+                        Protocol: binary.BigEndian.Uint16(e[0:2]),
+                    })
+                }
+            }
+        }
+    }
+}
@@ -0,0 +1,127 @@
+package lldp
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestParseIEEEDCBX(t *testing.T) {
+    // Priority 3 lossless in TC 3 with 50% bandwidth, CNP (priority 6) strict, RoCEv2 on priority 3.
+    ets := []byte{
+        0x08,                   // not willing, 8 TCs
+        0x00, 0x03, 0x00, 0x60, // priorities 0-7 -> TCs 0,0,0,3,0,0,6,0
+        50, 0, 0, 50, 0, 0, 0, 0, // bandwidth per TC
+        2, 2, 2, 2, 2, 2, 0, 2, // TSA per TC
+    }
+    var pdu []byte
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeETSConfiguration, ets...)
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeETSRecommendation, append([]byte{0}, ets[1:]...)...)
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypePFCConfiguration, 0x88, 0x08) // willing, 8 TCs, priority 3
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeAppPriority,
+        0x00,
+        0x63, 0x12, 0xb7, // priority 3, UDP port 4791
+        0xa1, 0x89, 0x06, // priority 5, EtherType 0x8906 (FCoE)
+    )
+
+    d := Parse(pdu).DCBX
+    if d == nil {
+        t.Fatal("DCBX not decoded")
+    }
+    if d.Mode != DCBXModeIEEE {
+        t.Errorf("Mode = %q, want %q", d.Mode, DCBXModeIEEE)
+    }
+    if want := (&PFC{Willing: true, Capability: 8, Enabled: []int{3}}); !reflect.DeepEqual(d.PFC, want) {
+        t.Errorf("PFC = %+v, want %+v", d.PFC, want)
+    }
+    wantETS := &ETS{
+        MaxTCs:     8,
+        PriorityTC: []int{0, 0, 0, 3, 0, 0, 6, 0},
+        Bandwidth:  []int{50, 0, 0, 50, 0, 0, 0, 0},
+        TSA:        []string{"ets", "ets", "ets", "ets", "ets", "ets", "strict", "ets"},
+    }
+    if !reflect.DeepEqual(d.ETS, wantETS) {
+        t.Errorf("ETS = %+v, want %+v", d.ETS, wantETS)
+    }
+    wantETS.MaxTCs = 0
+    if !reflect.DeepEqual(d.ETSRecommendation, wantETS) {
+        t.Errorf("ETSRecommendation = %+v, want %+v", d.ETSRecommendation, wantETS)
+    }
+    wantApps := []AppPriority{{3, "udp", 4791}, {5, "ethertype", 0x8906}}
+    if !reflect.DeepEqual(d.Applications, wantApps) {
+        t.Errorf("Applications = %+v, want %+v", d.Applications, wantApps)
+    }
+    if p := d.ApplicationPriority(RoCEv2UDPPort); p != 3 {
+        t.Errorf("ApplicationPriority(RoCEv2) = %d, want 3", p)
+    }
+    if tc := d.ETS.TrafficClass(3); tc != 3 {
+        t.Errorf("TrafficClass(3) = %d, want 3", tc)
+    }
+}
+
+// ceeTLV wraps CEE feature sub-TLVs in the DCBX v1.01 org-specific TLV, after a control sub-TLV.
+func ceeTLV(features ...[]byte) []byte {
+    info := appendTLV(nil, ceeTLVControl, make([]byte, 10))
+    for _, f := range features {
+        info = append(info, f...)
+    }
+    return orgTLV(nil, OUICEE, CEESubtypeDCBX, info...)
+}
+
+func TestParseCEEDCBX(t *testing.T) {
+    pg := appendTLV(nil, ceeTLVPriorityGrp, []byte{
+        0, 0, 0x40, 0x00, // enabled, willing
+        0x00, 0x01, 0x00, 0x00, // priority 3 in PG 1, the rest in PG 0
+        60, 40, 0, 0, 0, 0, 0, 0, // PG bandwidth
+        8,
+    })
+    pfc := appendTLV(nil, ceeTLVPFC, []byte{0, 0, 0x80, 0x00, 0x08, 8})
+    app := appendTLV(nil, ceeTLVApplications, []byte{
+        0, 0, 0x80, 0x00,
+        0x12, 0xb7, 0x01, 0x00, 0x00, 0x08, // UDP 4791 on priority 3
+    })
+
+    d := Parse(ceeTLV(pg, pfc, app)).DCBX
+    if d == nil {
+        t.Fatal("DCBX not decoded")
+    }
+    if d.Mode != DCBXModeCEE {
+        t.Errorf("Mode = %q, want %q", d.Mode, DCBXModeCEE)
+    }
+    if want := (&PFC{Capability: 8, Enabled: []int{3}}); !reflect.DeepEqual(d.PFC, want) {
+        t.Errorf("PFC = %+v, want %+v", d.PFC, want)
+    }
+    wantETS := &ETS{
+        Willing:    true,
+        MaxTCs:     8,
+        PriorityTC: []int{0, 0, 0, 1, 0, 0, 0, 0},
+        Bandwidth:  []int{60, 40, 0, 0, 0, 0, 0, 0},
+    }
+    if !reflect.DeepEqual(d.ETS, wantETS) {
+        t.Errorf("ETS = %+v, want %+v", d.ETS, wantETS)
+    }
+    if p := d.ApplicationPriority(RoCEv2UDPPort); p != 3 {
+        t.Errorf("ApplicationPriority(RoCEv2) = %d, want 3", p)
+    }
+}
+
+func TestIEEEDCBXOverridesCEE(t *testing.T) {
+    pfc := appendTLV(nil, ceeTLVPFC, []byte{0, 0, 0x80, 0x00, 0x10, 8})
+    pdu := ceeTLV(pfc)
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypePFCConfiguration, 0x08, 0x08)
+    pdu = append(pdu, ceeTLV(pfc)...)
+
+    d := Parse(pdu).DCBX
+    if d == nil || d.Mode != DCBXModeIEEE || !reflect.DeepEqual(d.PFC.Enabled, []int{3}) {
+        t.Errorf("DCBX = %+v, want the IEEE PFC settings", d)
+    }
+}
+
+func TestParseDCBXMalformed(t *testing.T) {
+    var pdu []byte
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeETSConfiguration, 0x08, 0x00)
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypePFCConfiguration, 0x08)
+    pdu = orgTLV(pdu, OUICEE, CEESubtypeDCBX, 0x06, 0x20, 0x00) // sub-TLV longer than the TLV
+    if d := Parse(pdu).DCBX; d != nil {
+        t.Errorf("DCBX = %+v, want nil", d)
+    }
+}
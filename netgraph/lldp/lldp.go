@@ -0,0 +1,317 @@
+// Package lldp decodes and encodes IEEE 802.1AB Link Layer Discovery Protocol data units.
+//
+// Parse understands the basic TLVs (Chassis ID, Port ID, TTL, Port/System Description,
+// System Name, System Capabilities and Management Address) and the IEEE 802.1 and 802.3
+// organizationally-specific TLVs (VLANs, link aggregation, MAC/PHY status, max frame size)
+// including DCBX PFC/ETS/application priority in both IEEE and CEE flavours.
+// BuildLLDPDU produces frames that Parse (and any standard LLDP agent) can decode.
+package lldp
+
+import (
+    "encoding/binary"
+    "fmt"
+    "net"
+)
+
+// EtherType is the LLDP Ethernet type.
+const EtherType = 0x88CC
+
+// MulticastMAC is the nearest-bridge LLDP destination address (01:80:c2:00:00:0e).
+var MulticastMAC = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x0e}
+
+// TLV type constants (IEEE 802.1AB mandatory and optional basic TLVs).
+const (
+    TLVEnd         = 0
+    TLVChassisID   = 1
+    TLVPortID      = 2
+    TLVTTL         = 3
+    TLVPortDesc    = 4
+    TLVSystemName  = 5
+    TLVSystemDesc  = 6
+    TLVSystemCaps  = 7
+    TLVMgmtAddress = 8
+    TLVOrgSpecific = 127
+)
+
+// Chassis ID subtypes (IEEE 802.1AB 8.5.2.2).
+const (
+    ChassisIDSubtypeChassisComponent = 1
+    ChassisIDSubtypeInterfaceAlias   = 2
+    ChassisIDSubtypePortComponent    = 3
+    ChassisIDSubtypeMACAddress       = 4
+    ChassisIDSubtypeNetworkAddress   = 5
+    ChassisIDSubtypeInterfaceName    = 6
+    ChassisIDSubtypeLocal            = 7
+)
+
+// Port ID subtypes (IEEE 802.1AB 8.5.3.2).
+const (
+    PortIDSubtypeInterfaceAlias = 1
+    PortIDSubtypePortComponent  = 2
+    PortIDSubtypeMACAddress     = 3
+    PortIDSubtypeNetworkAddress = 4
+    PortIDSubtypeInterfaceName  = 5
+    PortIDSubtypeAgentCircuitID = 6
+    PortIDSubtypeLocal          = 7
+)
+
+// IANA address family numbers, as used by the Management Address TLV
+// and the network-address Chassis/Port ID subtypes.
+const (
+    AddressFamilyIPv4 = 1
+    AddressFamilyIPv6 = 2
+    AddressFamily802  = 6
+)
+
+// CapabilityStation is the "station only" System Capabilities bit, which is what a GPU host is.
+const CapabilityStation = 1 << 7
+
+// CapabilityNames maps each System Capabilities bit (LSB first) to a short name.
+var CapabilityNames = []string{
+    "other",
+    "repeater",
+    "bridge",
+    "wlan-ap",
+    "router",
+    "telephone",
+    "docsis",
+    "station",
+    "c-vlan",
+    "s-vlan",
+    "tpmr",
+}
+
+// Fields holds the decoded basic TLVs from an LLDP payload.
+type Fields struct {
+    ChassisID           string
+    ChassisIDSubtype    string
+    PortID              string
+    PortIDSubtype       string
+    TTL                 uint16
+    PortDescription     string
+    SystemName          string
+    SystemDescription   string
+    Capabilities        []string // capabilities the system supports
+    EnabledCapabilities []string // subset of Capabilities currently enabled
+    ManagementAddresses []string
+
+    // IEEE 802.1 organizationally-specific TLVs.
+    PortVLANID      uint16 // 0 when not advertised (or the port is untagged-only)
+    ProtocolVLANIDs []uint16
+    VLANNames       []VLAN
+    LinkAggregation *LinkAggregation // from the 802.1 TLV, or the older 802.3 one
+
+    // IEEE 802.3 organizationally-specific TLVs.
+    MACPHY       *MACPHY
+    MaxFrameSize uint16
+
+    // DCBX (IEEE 802.1Qaz, or CEE if that is all the port sends).
+    DCBX *DCBX
+}
+
+// Advertisement describes an LLDPDU to send about the local system on one interface.
+type Advertisement struct {
+    ChassisIDSubtype    byte
+    ChassisID           []byte
+    PortID              string // sent as the interface-name subtype
+    SystemName          string
+    TTL                 uint16
+    Capabilities        uint16
+    EnabledCapabilities uint16
+}
+
+// Parse walks the LLDP TLV structure and returns the decoded basic TLVs.
+// Unknown or malformed TLVs are skipped.
+func Parse(payload []byte) Fields {
+    var fields Fields
+    offset := 0
+
+    for offset < len(payload) {
+        // Need at least 2 bytes for a TLV header.
+        if len(payload[offset:]) < 2 {
+            break
+        }
+        // 2-byte TLV header: [7 bits of Type | 9 bits of Length]
+        tlvHeader := binary.BigEndian.Uint16(payload[offset : offset+2])
+        offset += 2
+
+        tlvType := tlvHeader >> 9
+        tlvLen := tlvHeader & 0x1FF
+
+        if tlvLen == 0 || offset+int(tlvLen) > len(payload) {
+            break
+        }
+        tlvValue := payload[offset : offset+int(tlvLen)]
+        offset += int(tlvLen)
+
+        switch tlvType {
+        case TLVEnd:
+            // End of LLDPDU
+            return fields
+        case TLVChassisID:
+            // Byte 0 is sub-type, so actual chassis ID data is after that
+            if len(tlvValue) > 1 {
+                fields.ChassisIDSubtype, fields.ChassisID = DecodeChassisID(tlvValue[0], tlvValue[1:])
+            }
+        case TLVPortID:
+            // Byte 0 is sub-type, so actual port ID data is after that
+            if len(tlvValue) > 1 {
+                fields.PortIDSubtype, fields.PortID = DecodePortID(tlvValue[0], tlvValue[1:])
+            }
+        case TLVTTL:
+            if len(tlvValue) >= 2 {
+                fields.TTL = binary.BigEndian.Uint16(tlvValue[:2])
+            }
+        case TLVPortDesc:
+            fields.PortDescription = string(tlvValue)
+        case TLVSystemName:
+            // System name is directly the entire TLV value
+            fields.SystemName = string(tlvValue)
+        case TLVSystemDesc:
+            fields.SystemDescription = string(tlvValue)
+        case TLVSystemCaps:
+            // 2 bytes of supported capabilities followed by 2 bytes of enabled ones.
+            if len(tlvValue) >= 4 {
+                fields.Capabilities = DecodeCapabilities(binary.BigEndian.Uint16(tlvValue[0:2]))
+                fields.EnabledCapabilities = DecodeCapabilities(binary.BigEndian.Uint16(tlvValue[2:4]))
+            }
+        case TLVMgmtAddress:
+            if addr := decodeManagementAddress(tlvValue); addr != "" {
+                fields.ManagementAddresses = append(fields.ManagementAddresses, addr)
+            }
+        case TLVOrgSpecific:
+            parseOrgSpecific(&fields, tlvValue)
+        }
+    }
+    return fields
+}
+
+// DecodeChassisID returns the subtype name and a printable form of a Chassis ID.
+func DecodeChassisID(subtype byte, id []byte) (string, string) {
+    switch subtype {
+    case ChassisIDSubtypeChassisComponent:
+        return "chassis-component", string(id)
+    case ChassisIDSubtypeInterfaceAlias:
+        return "interface-alias", string(id)
+    case ChassisIDSubtypePortComponent:
+        return "port-component", string(id)
+    case ChassisIDSubtypeMACAddress:
+        return "mac-address", net.HardwareAddr(id).String()
+    case ChassisIDSubtypeNetworkAddress:
+        return "network-address", decodeNetworkAddressID(id)
+    case ChassisIDSubtypeInterfaceName:
+        return "interface-name", string(id)
+    case ChassisIDSubtypeLocal:
+        return "local", string(id)
+    }
+    return fmt.Sprintf("unknown-%d", subtype), fmt.Sprintf("%x", id)
+}
+
+// DecodePortID returns the subtype name and a printable form of a Port ID.
+func DecodePortID(subtype byte, id []byte) (string, string) {
+    switch subtype {
+    case PortIDSubtypeInterfaceAlias:
+        return "interface-alias", string(id)
+    case PortIDSubtypePortComponent:
+        return "port-component", string(id)
+    case PortIDSubtypeMACAddress:
+        return "mac-address", net.HardwareAddr(id).String()
+    case PortIDSubtypeNetworkAddress:
+        return "network-address", decodeNetworkAddressID(id)
+    case PortIDSubtypeInterfaceName:
+        return "interface-name", string(id)
+    case PortIDSubtypeAgentCircuitID:
+        // Agent circuit IDs (RFC 3046) are opaque binary.
+        return "agent-circuit-id", fmt.Sprintf("%x", id)
+    case PortIDSubtypeLocal:
+        return "local", string(id)
+    }
+    return fmt.Sprintf("unknown-%d", subtype), fmt.Sprintf("%x", id)
+}
+
+// decodeNetworkAddressID decodes a network-address Chassis/Port ID,
+// which is an IANA address family byte followed by the address.
+func decodeNetworkAddressID(id []byte) string {
+    if len(id) < 2 {
+        return fmt.Sprintf("%x", id)
+    }
+    return DecodeNetworkAddress(id[0], id[1:])
+}
+
+// DecodeCapabilities turns a System Capabilities bitmap into capability names.
+func DecodeCapabilities(bits uint16) []string {
+    var names []string
+    for i, name := range CapabilityNames {
+        if bits&(1<<uint(i)) != 0 {
+            names = append(names, name)
+        }
+    }
+    return names
+}
+
+// decodeManagementAddress extracts the address from a Management Address TLV.
+// Layout: [addr string len][addr subtype][addr...][if subtype][if number x4][OID len][OID...]
+// The address string length counts the subtype byte as well.
+func decodeManagementAddress(tlvValue []byte) string {
+    if len(tlvValue) < 2 {
+        return ""
+    }
+    addrLen := int(tlvValue[0])
+    if addrLen < 2 || 1+addrLen > len(tlvValue) {
+        return ""
+    }
+    return DecodeNetworkAddress(tlvValue[1], tlvValue[2:1+addrLen])
+}
+
+// DecodeNetworkAddress formats an address according to its IANA address family.
+// Unknown families fall back to hex.
+func DecodeNetworkAddress(family byte, addr []byte) string {
+    switch family {
+    case AddressFamilyIPv4:
+        if len(addr) == net.IPv4len {
+            return net.IP(addr).String()
+        }
+    case AddressFamilyIPv6:
+        if len(addr) == net.IPv6len {
+            return net.IP(addr).String()
+        }
+    case AddressFamily802:
+        return net.HardwareAddr(addr).String()
+    }
+    return fmt.Sprintf("%x", addr)
+}
+
+// BuildFrame returns a complete Ethernet frame carrying the LLDPDU for adv.
+func BuildFrame(srcMAC net.HardwareAddr, adv Advertisement) []byte {
+    frame := make([]byte, 0, 128)
+    frame = append(frame, MulticastMAC...)
+    frame = append(frame, srcMAC...)
+    frame = binary.BigEndian.AppendUint16(frame, EtherType)
+    return append(frame, BuildLLDPDU(adv)...)
+}
+
+// BuildLLDPDU encodes adv as LLDP TLVs, in the order required by 802.1AB
+// (Chassis ID, Port ID, TTL first, End last). Parse decodes the result.
+func BuildLLDPDU(adv Advertisement) []byte {
+    var pdu []byte
+    pdu = appendTLV(pdu, TLVChassisID, append([]byte{adv.ChassisIDSubtype}, adv.ChassisID...))
+    pdu = appendTLV(pdu, TLVPortID, append([]byte{PortIDSubtypeInterfaceName}, adv.PortID...))
+    pdu = appendTLV(pdu, TLVTTL, binary.BigEndian.AppendUint16(nil, adv.TTL))
+    if adv.SystemName != "" {
+        pdu = appendTLV(pdu, TLVSystemName, []byte(adv.SystemName))
+    }
+    caps := binary.BigEndian.AppendUint16(nil, adv.Capabilities)
+    caps = binary.BigEndian.AppendUint16(caps, adv.EnabledCapabilities)
+    pdu = appendTLV(pdu, TLVSystemCaps, caps)
+    return appendTLV(pdu, TLVEnd, nil)
+}
+
+// appendTLV appends one TLV: a 7-bit type and 9-bit length header followed by the value.
+// Values longer than the 511-byte maximum are truncated.
+func appendTLV(buf []byte, tlvType uint16, value []byte) []byte {
+    if len(value) > 0x1FF {
+        value = value[:0x1FF]
+    }
+    buf = binary.BigEndian.AppendUint16(buf, tlvType<<9|uint16(len(value)))
+    return append(buf, value...)
+}
@@ -0,0 +1,174 @@
+package lldp
+
+import (
+    "encoding/binary"
+    "net"
+    "reflect"
+    "testing"
+)
+
+// switchLLDPDU is what a typical switch port sends: MAC chassis ID, interface-name port ID,
+// all basic optional TLVs and an IPv4 management address.
+func switchLLDPDU() []byte {
+    var pdu []byte
+    pdu = appendTLV(pdu, TLVChassisID, []byte{ChassisIDSubtypeMACAddress, 0x00, 0x1c, 0x73, 0xaa, 0xbb, 0xcc})
+    pdu = appendTLV(pdu, TLVPortID, append([]byte{PortIDSubtypeInterfaceName}, "Ethernet1/1"...))
+    pdu = appendTLV(pdu, TLVTTL, []byte{0x00, 0x78})
+    pdu = appendTLV(pdu, TLVPortDesc, []byte("to gpu-node-01"))
+    pdu = appendTLV(pdu, TLVSystemName, []byte("leaf01"))
+    pdu = appendTLV(pdu, TLVSystemDesc, []byte("Arista EOS"))
+    pdu = appendTLV(pdu, TLVSystemCaps, []byte{0x00, 0x14, 0x00, 0x04}) // bridge+router, bridge enabled
+    pdu = appendTLV(pdu, TLVMgmtAddress, []byte{
+        5, AddressFamilyIPv4, 10, 0, 0, 1, // address string length, family, address
+        2, 0, 0, 0, 1, // interface numbering subtype and number
+        0, // OID length
+    })
+    return appendTLV(pdu, TLVEnd, nil)
+}
+
+func TestParse(t *testing.T) {
+    got := Parse(switchLLDPDU())
+    want := Fields{
+        ChassisID:           "00:1c:73:aa:bb:cc",
+        ChassisIDSubtype:    "mac-address",
+        PortID:              "Ethernet1/1",
+        PortIDSubtype:       "interface-name",
+        TTL:                 120,
+        PortDescription:     "to gpu-node-01",
+        SystemName:          "leaf01",
+        SystemDescription:   "Arista EOS",
+        Capabilities:        []string{"bridge", "router"},
+        EnabledCapabilities: []string{"bridge"},
+        ManagementAddresses: []string{"10.0.0.1"},
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("Parse() =\n%+v\nwant\n%+v", got, want)
+    }
+}
+
+func TestParseMalformed(t *testing.T) {
+    good := switchLLDPDU()
+    tests := []struct {
+        name    string
+        payload []byte
+    }{
+        {"empty", nil},
+        {"short header", []byte{0x02}},
+        {"truncated value", good[:5]},
+        {"length past end", []byte{0x02, 0x10, ChassisIDSubtypeLocal, 'x'}},
+        {"subtype only", appendTLV(nil, TLVChassisID, []byte{ChassisIDSubtypeLocal})},
+        {"short ttl", appendTLV(nil, TLVTTL, []byte{0x01})},
+        {"bad mgmt length", appendTLV(nil, TLVMgmtAddress, []byte{40, AddressFamilyIPv4, 10})},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            f := Parse(tt.payload)
+            if f.ChassisID != "" || f.PortID != "" || f.TTL != 0 || f.ManagementAddresses != nil {
+                t.Errorf("Parse(%x) = %+v, want no fields", tt.payload, f)
+            }
+        })
+    }
+}
+
+func TestParseStopsAtEnd(t *testing.T) {
+    pdu := appendTLV(nil, TLVSystemName, []byte("leaf01"))
+    pdu = appendTLV(pdu, TLVEnd, nil)
+    pdu = appendTLV(pdu, TLVSystemDesc, []byte("after end"))
+    if f := Parse(pdu); f.SystemName != "leaf01" || f.SystemDescription != "" {
+        t.Errorf("Parse() = %+v, want only SystemName", f)
+    }
+}
+
+func TestDecodeChassisID(t *testing.T) {
+    tests := []struct {
+        subtype     byte
+        id          []byte
+        wantSubtype string
+        wantID      string
+    }{
+        {ChassisIDSubtypeMACAddress, []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}, "mac-address", "aa:bb:cc:dd:ee:ff"},
+        {ChassisIDSubtypeNetworkAddress, []byte{AddressFamilyIPv4, 192, 168, 1, 1}, "network-address", "192.168.1.1"},
+        {ChassisIDSubtypeNetworkAddress, append([]byte{AddressFamilyIPv6}, net.ParseIP("fe80::1")...), "network-address", "fe80::1"},
+        {ChassisIDSubtypeInterfaceName, []byte("eth0"), "interface-name", "eth0"},
+        {ChassisIDSubtypeLocal, []byte("0123456789abcdef"), "local", "0123456789abcdef"},
+        {42, []byte{0x01, 0x02}, "unknown-42", "0102"},
+    }
+    for _, tt := range tests {
+        subtype, id := DecodeChassisID(tt.subtype, tt.id)
+        if subtype != tt.wantSubtype || id != tt.wantID {
+            t.Errorf("DecodeChassisID(%d, %x) = (%q, %q), want (%q, %q)",
+                tt.subtype, tt.id, subtype, id, tt.wantSubtype, tt.wantID)
+        }
+    }
+}
+
+func TestDecodePortID(t *testing.T) {
+    tests := []struct {
+        subtype     byte
+        id          []byte
+        wantSubtype string
+        wantID      string
+    }{
+        {PortIDSubtypeInterfaceAlias, []byte("uplink"), "interface-alias", "uplink"},
+        {PortIDSubtypeMACAddress, []byte{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}, "mac-address", "02:00:00:00:00:01"},
+        {PortIDSubtypeNetworkAddress, []byte{AddressFamilyIPv4}, "network-address", "01"},
+        {PortIDSubtypeAgentCircuitID, []byte{0xde, 0xad}, "agent-circuit-id", "dead"},
+        {PortIDSubtypeLocal, []byte("17"), "local", "17"},
+    }
+    for _, tt := range tests {
+        subtype, id := DecodePortID(tt.subtype, tt.id)
+        if subtype != tt.wantSubtype || id != tt.wantID {
+            t.Errorf("DecodePortID(%d, %x) = (%q, %q), want (%q, %q)",
+                tt.subtype, tt.id, subtype, id, tt.wantSubtype, tt.wantID)
+        }
+    }
+}
+
+func TestBuildRoundTrip(t *testing.T) {
+    adv := Advertisement{
+        ChassisIDSubtype:    ChassisIDSubtypeLocal,
+        ChassisID:           []byte("4f1c2a9e"),
+        PortID:              "ens1f0np0",
+        SystemName:          "gpu-node-01",
+        TTL:                 120,
+        Capabilities:        CapabilityStation,
+        EnabledCapabilities: CapabilityStation,
+    }
+    src := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x01}
+    frame := BuildFrame(src, adv)
+
+    if !reflect.DeepEqual(net.HardwareAddr(frame[0:6]), MulticastMAC) {
+        t.Errorf("destination = %v, want %v", net.HardwareAddr(frame[0:6]), MulticastMAC)
+    }
+    if !reflect.DeepEqual(net.HardwareAddr(frame[6:12]), src) {
+        t.Errorf("source = %v, want %v", net.HardwareAddr(frame[6:12]), src)
+    }
+    if et := binary.BigEndian.Uint16(frame[12:14]); et != EtherType {
+        t.Errorf("ethertype = %#04x, want %#04x", et, EtherType)
+    }
+
+    got := Parse(frame[14:])
+    want := Fields{
+        ChassisID:           "4f1c2a9e",
+        ChassisIDSubtype:    "local",
+        PortID:              "ens1f0np0",
+        PortIDSubtype:       "interface-name",
+        TTL:                 120,
+        SystemName:          "gpu-node-01",
+        Capabilities:        []string{"station"},
+        EnabledCapabilities: []string{"station"},
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("Parse(BuildFrame()) =\n%+v\nwant\n%+v", got, want)
+    }
+}
+
+func TestAppendTLVTruncates(t *testing.T) {
+    tlv := appendTLV(nil, TLVSystemDesc, make([]byte, 600))
+    if got := binary.BigEndian.Uint16(tlv) & 0x1FF; got != 0x1FF {
+        t.Errorf("length = %d, want %d", got, 0x1FF)
+    }
+    if len(tlv) != 2+0x1FF {
+        t.Errorf("len(tlv) = %d, want %d", len(tlv), 2+0x1FF)
+    }
+}
@@ -0,0 +1,282 @@
+package lldp
+
+import "encoding/binary"
+
+// Organizationally unique identifiers of the org-specific TLVs we decode.
+var (
+    OUIIEEE8021 = [3]byte{0x00, 0x80, 0xc2}
+    OUIIEEE8023 = [3]byte{0x00, 0x12, 0x0f}
+)
+
+// IEEE 802.1 org-specific TLV subtypes (802.1Q Annex D, 802.1AX).
+const (
+    Dot1SubtypePortVLANID      = 1
+    Dot1SubtypeProtocolVLANID  = 2
+    Dot1SubtypeVLANName        = 3
+    Dot1SubtypeLinkAggregation = 7
+)
+
+// IEEE 802.3 org-specific TLV subtypes (802.3 Clause 79).
+const (
+    Dot3SubtypeMACPHY          = 1
+    Dot3SubtypeLinkAggregation = 3 // deprecated in favour of the 802.1 TLV but still sent by many switches
+    Dot3SubtypeMaxFrameSize    = 4
+)
+
+// VLAN is one entry of the 802.1 VLAN Name TLV.
+type VLAN struct {
+    ID   uint16 `json:"id"`
+    Name string `json:"name"`
+}
+
+// LinkAggregation is the 802.1 (or legacy 802.3) Link Aggregation TLV.
+// AggregatedPortID is the ifIndex of the aggregate, and is only meaningful when Enabled.
+type LinkAggregation struct {
+    Capable          bool   `json:"capable"`
+    Enabled          bool   `json:"enabled"`
+    AggregatedPortID uint32 `json:"aggregated_port_id,omitempty"`
+}
+
+// MACPHY is the 802.3 MAC/PHY Configuration/Status TLV.
+// SpeedMbps and Duplex are derived from the operational MAU type and are empty for types we do not know.
+type MACPHY struct {
+    AutonegSupported  bool     `json:"autoneg_supported"`
+    AutonegEnabled    bool     `json:"autoneg_enabled"`
+    AutonegAdvertised []string `json:"autoneg_advertised,omitempty"`
+    MAUType           uint16   `json:"mau_type"`
+    MAU               string   `json:"mau,omitempty"`
+    SpeedMbps         int      `json:"speed_mbps,omitempty"`
+    Duplex            string   `json:"duplex,omitempty"`
+}
+
+// parseOrgSpecific decodes one type-127 TLV value (OUI, subtype, info) into fields.
+// TLVs from other organizations, unknown subtypes and short values are ignored.
+func parseOrgSpecific(fields *Fields, value []byte) {
+    if len(value) < 4 {
+        return
+    }
+    oui := [3]byte{value[0], value[1], value[2]}
+    subtype := value[3]
+    info := value[4:]
+
+    switch oui {
+    case OUIIEEE8021:
+        parseDot1(fields, subtype, info)
+    case OUIIEEE8023:
+        parseDot3(fields, subtype, info)
+    case OUICEE:
+        if subtype == CEESubtypeDCBX {
+            parseCEEDCBX(fields, info)
+        }
+    }
+}
+
+func parseDot1(fields *Fields, subtype byte, info []byte) {
+    switch subtype {
+    case Dot1SubtypePortVLANID:
+        if len(info) >= 2 {
+            fields.PortVLANID = binary.BigEndian.Uint16(info)
+        }
+    case Dot1SubtypeProtocolVLANID:
+        // [flags][PPVID x2]; a zero PPVID means the port supports but has no protocol VLAN.
+        if len(info) >= 3 {
+            if id := binary.BigEndian.Uint16(info[1:3]); id != 0 {
+                fields.ProtocolVLANIDs = append(fields.ProtocolVLANIDs, id)
+            }
+        }
+    case Dot1SubtypeVLANName:
+        // [VLAN ID x2][name length][name...]
+        if len(info) >= 3 {
+            nameLen := int(info[2])
+            if 3+nameLen <= len(info) {
+                fields.VLANNames = append(fields.VLANNames, VLAN{
+                    ID:   binary.BigEndian.Uint16(info[0:2]),
+                    Name: string(info[3 : 3+nameLen]),
+                })
+            }
+        }
+    case Dot1SubtypeLinkAggregation:
+        if lag := decodeLinkAggregation(info); lag != nil {
+            fields.LinkAggregation = lag
+        }
+    case Dot1SubtypeETSConfiguration, Dot1SubtypeETSRecommendation,
+        Dot1SubtypePFCConfiguration, Dot1SubtypeAppPriority:
+        parseIEEEDCBX(fields, subtype, info)
+    }
+}
+
+func parseDot3(fields *Fields, subtype byte, info []byte) {
+    switch subtype {
+    case Dot3SubtypeMACPHY:
+        // [autoneg support/status][PMD autoneg advertised capability x2][operational MAU type x2]
+        if len(info) >= 5 {
+            mau := binary.BigEndian.Uint16(info[3:5])
+            macphy := &MACPHY{
+                AutonegSupported:  info[0]&0x01 != 0,
+                AutonegEnabled:    info[0]&0x02 != 0,
+                AutonegAdvertised: DecodeAutonegAdvertised(binary.BigEndian.Uint16(info[1:3])),
+                MAUType:           mau,
+            }
+            if t, ok := mauTypes[mau]; ok {
+                macphy.MAU = t.name
+                macphy.SpeedMbps = t.speedMbps
+                macphy.Duplex = t.duplex
+            }
+            fields.MACPHY = macphy
+        }
+    case Dot3SubtypeLinkAggregation:
+        // The 802.1 TLV wins if a switch sends both.
+        if lag := decodeLinkAggregation(info); lag != nil && fields.LinkAggregation == nil {
+            fields.LinkAggregation = lag
+        }
+    case Dot3SubtypeMaxFrameSize:
+        if len(info) >= 2 {
+            fields.MaxFrameSize = binary.BigEndian.Uint16(info)
+        }
+    }
+}
+
+// decodeLinkAggregation decodes [status][aggregated port ID x4], shared by the 802.1 and 802.3 TLVs.
+func decodeLinkAggregation(info []byte) *LinkAggregation {
+    if len(info) < 5 {
+        return nil
+    }
+    return &LinkAggregation{
+        Capable:          info[0]&0x01 != 0,
+        Enabled:          info[0]&0x02 != 0,
+        AggregatedPortID: binary.BigEndian.Uint32(info[1:5]),
+    }
+}
+
+// autonegNames are the ifMauAutoNegCapAdvertisedBits (RFC 4836) in the order of the TLV bitmap,
+// where bit 0 is the most significant bit.
+var autonegNames = []string{
+    "other",
+    "10base-t",
+    "10base-t-fd",
+    "100base-t4",
+    "100base-tx",
+    "100base-tx-fd",
+    "100base-t2",
+    "100base-t2-fd",
+    "fdx-pause",
+    "fdx-apause",
+    "fdx-spause",
+    "fdx-bpause",
+    "1000base-x",
+    "1000base-x-fd",
+    "1000base-t",
+    "1000base-t-fd",
+}
+
+// DecodeAutonegAdvertised turns the PMD auto-negotiation advertised capability bitmap into names.
+func DecodeAutonegAdvertised(bits uint16) []string {
+    var names []string
+    for i, name := range autonegNames {
+        if bits&(0x8000>>uint(i)) != 0 {
+            names = append(names, name)
+        }
+    }
+    return names
+}
+
+type mauType struct {
+    name      string
+    speedMbps int
+    duplex    string
+}
+
+// mauTypes maps IANA dot3MauType values (IANA-MAU-MIB) to speed and duplex.
+// Only types seen on datacenter links are listed; many switches report 0 for speeds
+// newer than the MIB, in which case speed is left unknown.
+var mauTypes = map[uint16]mauType{
+    10:  {"10base-t-hd", 10, "half"},
+    11:  {"10base-t-fd", 10, "full"},
+    15:  {"100base-tx-hd", 100, "half"},
+    16:  {"100base-tx-fd", 100, "full"},
+    17:  {"100base-fx-hd", 100, "half"},
+    18:  {"100base-fx-fd", 100, "full"},
+    21:  {"1000base-x-hd", 1000, "half"},
+    22:  {"1000base-x-fd", 1000, "full"},
+    23:  {"1000base-lx-hd", 1000, "half"},
+    24:  {"1000base-lx-fd", 1000, "full"},
+    25:  {"1000base-sx-hd", 1000, "half"},
+    26:  {"1000base-sx-fd", 1000, "full"},
+    27:  {"1000base-cx-hd", 1000, "half"},
+    28:  {"1000base-cx-fd", 1000, "full"},
+    29:  {"1000base-t-hd", 1000, "half"},
+    30:  {"1000base-t-fd", 1000, "full"},
+    31:  {"10gbase-x", 10000, "full"},
+    32:  {"10gbase-lx4", 10000, "full"},
+    33:  {"10gbase-r", 10000, "full"},
+    34:  {"10gbase-er", 10000, "full"},
+    35:  {"10gbase-lr", 10000, "full"},
+    36:  {"10gbase-sr", 10000, "full"},
+    37:  {"10gbase-w", 10000, "full"},
+    38:  {"10gbase-ew", 10000, "full"},
+    39:  {"10gbase-lw", 10000, "full"},
+    40:  {"10gbase-sw", 10000, "full"},
+    41:  {"10gbase-cx4", 10000, "full"},
+    54:  {"10gbase-t", 10000, "full"},
+    55:  {"10gbase-lrm", 10000, "full"},
+    56:  {"1000base-kx", 1000, "full"},
+    57:  {"10gbase-kx4", 10000, "full"},
+    58:  {"10gbase-kr", 10000, "full"},
+    70:  {"40gbase-kr4", 40000, "full"},
+    71:  {"40gbase-cr4", 40000, "full"},
+    72:  {"40gbase-sr4", 40000, "full"},
+    73:  {"40gbase-fr", 40000, "full"},
+    74:  {"40gbase-lr4", 40000, "full"},
+    75:  {"100gbase-cr10", 100000, "full"},
+    76:  {"100gbase-sr10", 100000, "full"},
+    77:  {"100gbase-lr4", 100000, "full"},
+    78:  {"100gbase-er4", 100000, "full"},
+    88:  {"25gbase-cr", 25000, "full"},
+    89:  {"25gbase-cr-s", 25000, "full"},
+    90:  {"25gbase-kr", 25000, "full"},
+    91:  {"25gbase-kr-s", 25000, "full"},
+    92:  {"25gbase-r", 25000, "full"},
+    93:  {"25gbase-sr", 25000, "full"},
+    94:  {"25gbase-t", 25000, "full"},
+    95:  {"40gbase-er4", 40000, "full"},
+    96:  {"40gbase-r", 40000, "full"},
+    97:  {"40gbase-t", 40000, "full"},
+    98:  {"100gbase-cr4", 100000, "full"},
+    99:  {"100gbase-kr4", 100000, "full"},
+    100: {"100gbase-kp4", 100000, "full"},
+    101: {"100gbase-r", 100000, "full"},
+    102: {"100gbase-sr4", 100000, "full"},
+    103: {"2.5gbase-t", 2500, "full"},
+    104: {"5gbase-t", 5000, "full"},
+    109: {"2.5gbase-kx", 2500, "full"},
+    110: {"2.5gbase-x", 2500, "full"},
+    111: {"5gbase-kr", 5000, "full"},
+    112: {"5gbase-r", 5000, "full"},
+    114: {"25gbase-lr", 25000, "full"},
+    115: {"25gbase-er", 25000, "full"},
+    116: {"50gbase-r", 50000, "full"},
+    117: {"50gbase-cr", 50000, "full"},
+    118: {"50gbase-kr", 50000, "full"},
+    119: {"50gbase-sr", 50000, "full"},
+    120: {"50gbase-fr", 50000, "full"},
+    121: {"50gbase-lr", 50000, "full"},
+    122: {"50gbase-er", 50000, "full"},
+    123: {"100gbase-cr2", 100000, "full"},
+    124: {"100gbase-kr2", 100000, "full"},
+    125: {"100gbase-sr2", 100000, "full"},
+    126: {"100gbase-dr", 100000, "full"},
+    127: {"200gbase-r", 200000, "full"},
+    128: {"200gbase-dr4", 200000, "full"},
+    129: {"200gbase-fr4", 200000, "full"},
+    130: {"200gbase-lr4", 200000, "full"},
+    131: {"200gbase-cr4", 200000, "full"},
+    132: {"200gbase-kr4", 200000, "full"},
+    133: {"200gbase-sr4", 200000, "full"},
+    134: {"200gbase-er4", 200000, "full"},
+    135: {"400gbase-r", 400000, "full"},
+    136: {"400gbase-sr16", 400000, "full"},
+    137: {"400gbase-dr4", 400000, "full"},
+    138: {"400gbase-fr8", 400000, "full"},
+    139: {"400gbase-lr8", 400000, "full"},
+    140: {"400gbase-er8", 400000, "full"},
+}
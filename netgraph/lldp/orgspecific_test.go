@@ -0,0 +1,113 @@
+package lldp
+
+import (
+    "reflect"
+    "testing"
+)
+
+func orgTLV(buf []byte, oui [3]byte, subtype byte, info ...byte) []byte {
+    value := append(oui[:], subtype)
+    return appendTLV(buf, TLVOrgSpecific, append(value, info...))
+}
+
+func TestParseOrgSpecific(t *testing.T) {
+    var pdu []byte
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypePortVLANID, 0x00, 0x64)
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeProtocolVLANID, 0x06, 0x00, 0x00) // supported+enabled, no PPVID
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeVLANName, append([]byte{0x00, 0x64, 7}, "storage"...)...)
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeVLANName, append([]byte{0x00, 0xc8, 4}, "rdma"...)...)
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeLinkAggregation, 0x03, 0x00, 0x00, 0x03, 0xe9)
+    pdu = orgTLV(pdu, OUIIEEE8023, Dot3SubtypeMACPHY, 0x03, 0x6c, 0x01, 0x00, 0x1e)
+    pdu = orgTLV(pdu, OUIIEEE8023, Dot3SubtypeMaxFrameSize, 0x24, 0x00)
+    pdu = orgTLV(pdu, [3]byte{0x00, 0x01, 0x42}, 1, 0xff) // another vendor's TLV is skipped
+    pdu = appendTLV(pdu, TLVEnd, nil)
+
+    f := Parse(pdu)
+    if f.PortVLANID != 100 {
+        t.Errorf("PortVLANID = %d, want 100", f.PortVLANID)
+    }
+    if f.ProtocolVLANIDs != nil {
+        t.Errorf("ProtocolVLANIDs = %v, want none", f.ProtocolVLANIDs)
+    }
+    if want := []VLAN{{100, "storage"}, {200, "rdma"}}; !reflect.DeepEqual(f.VLANNames, want) {
+        t.Errorf("VLANNames = %+v, want %+v", f.VLANNames, want)
+    }
+    if want := (&LinkAggregation{Capable: true, Enabled: true, AggregatedPortID: 1001}); !reflect.DeepEqual(f.LinkAggregation, want) {
+        t.Errorf("LinkAggregation = %+v, want %+v", f.LinkAggregation, want)
+    }
+    wantPHY := &MACPHY{
+        AutonegSupported:  true,
+        AutonegEnabled:    true,
+        AutonegAdvertised: []string{"10base-t", "10base-t-fd", "100base-tx", "100base-tx-fd", "1000base-t-fd"},
+        MAUType:           30,
+        MAU:               "1000base-t-fd",
+        SpeedMbps:         1000,
+        Duplex:            "full",
+    }
+    if !reflect.DeepEqual(f.MACPHY, wantPHY) {
+        t.Errorf("MACPHY = %+v, want %+v", f.MACPHY, wantPHY)
+    }
+    if f.MaxFrameSize != 9216 {
+        t.Errorf("MaxFrameSize = %d, want 9216", f.MaxFrameSize)
+    }
+}
+
+func TestParseLinkAggregationPrecedence(t *testing.T) {
+    // Legacy 802.3 TLV first, then the 802.1 one: the 802.1 TLV wins either way.
+    pdu := orgTLV(nil, OUIIEEE8023, Dot3SubtypeLinkAggregation, 0x01, 0, 0, 0, 0)
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeLinkAggregation, 0x03, 0, 0, 0, 7)
+    pdu = orgTLV(pdu, OUIIEEE8023, Dot3SubtypeLinkAggregation, 0x01, 0, 0, 0, 0)
+    if lag := Parse(pdu).LinkAggregation; lag == nil || !lag.Enabled || lag.AggregatedPortID != 7 {
+        t.Errorf("LinkAggregation = %+v, want the 802.1 TLV", lag)
+    }
+}
+
+func TestParseOrgSpecificMalformed(t *testing.T) {
+    var pdu []byte
+    pdu = appendTLV(pdu, TLVOrgSpecific, []byte{0x00, 0x80}) // shorter than OUI+subtype
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypePortVLANID, 0x01)
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeVLANName, 0x00, 0x64, 20, 'x')
+    pdu = orgTLV(pdu, OUIIEEE8021, Dot1SubtypeLinkAggregation, 0x03)
+    pdu = orgTLV(pdu, OUIIEEE8023, Dot3SubtypeMACPHY, 0x03, 0x00)
+    pdu = orgTLV(pdu, OUIIEEE8023, Dot3SubtypeMaxFrameSize, 0x24)
+
+    f := Parse(pdu)
+    if f.PortVLANID != 0 || f.VLANNames != nil || f.LinkAggregation != nil || f.MACPHY != nil || f.MaxFrameSize != 0 {
+        t.Errorf("Parse() = %+v, want no org-specific fields", f)
+    }
+}
+
+func TestUnknownMAUType(t *testing.T) {
+    f := Parse(orgTLV(nil, OUIIEEE8023, Dot3SubtypeMACPHY, 0x00, 0x00, 0x00, 0x00, 0x00))
+    if f.MACPHY == nil || f.MACPHY.MAUType != 0 || f.MACPHY.SpeedMbps != 0 || f.MACPHY.Duplex != "" {
+        t.Errorf("MACPHY = %+v, want MAU type 0 with unknown speed", f.MACPHY)
+    }
+}
+
+func TestMAUTypeSpeeds(t *testing.T) {
+    for _, tt := range []struct {
+        mauType uint16
+        mau     string
+        speed   int
+    }{
+        {69, "", 0}, // 10GBASE-PR-U3, not listed
+        {70, "40gbase-kr4", 40000},
+        {74, "40gbase-lr4", 40000},
+        {75, "100gbase-cr10", 100000},
+        {77, "100gbase-lr4", 100000},
+        {78, "100gbase-er4", 100000},
+        {79, "", 0}, // 1000BASE-T1, not listed
+        {93, "25gbase-sr", 25000},
+        {98, "100gbase-cr4", 100000},
+        {102, "100gbase-sr4", 100000},
+        {117, "50gbase-cr", 50000},
+        {131, "200gbase-cr4", 200000},
+        {137, "400gbase-dr4", 400000},
+        {140, "400gbase-er8", 400000},
+    } {
+        f := Parse(orgTLV(nil, OUIIEEE8023, Dot3SubtypeMACPHY, 0x03, 0x00, 0x00, byte(tt.mauType>>8), byte(tt.mauType)))
+        if f.MACPHY == nil || f.MACPHY.MAUType != tt.mauType || f.MACPHY.MAU != tt.mau || f.MACPHY.SpeedMbps != tt.speed {
+            t.Errorf("MAU type %d: MACPHY = %+v, want %q at %d Mb/s", tt.mauType, f.MACPHY, tt.mau, tt.speed)
+        }
+    }
+}
@@ -1,84 +1,141 @@
 package main
 
 import (
+    "bufio"
+    "bytes"
     "context"
     "encoding/binary"
     "encoding/json"
+    "errors"
     "flag"
     "fmt"
     "io"
     "log"
     "net"
+    "net/http"
+    "net/url"
     "os"
     "os/signal"
+    "path"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
     "sync"
     "syscall"
     "time"
 
+    "github.com/AMD-DC-GPU/ce/netgraph/lacp"
+    "github.com/AMD-DC-GPU/ce/netgraph/lldp"
+    "github.com/AMD-DC-GPU/ce/netgraph/stp"
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
     "github.com/gopacket/gopacket"
     "github.com/gopacket/gopacket/layers"
-    "github.com/gopacket/gopacket/pcap"
+    "github.com/gopacket/gopacket/pcapgo"
 )
 
 // debug enables extra logging for development/troubleshooting.
 const debug = true
 
-// Constants for EtherTypes:
+// Constants for EtherTypes (LLDP's is lldp.EtherType, LACP's lacp.EtherType):
 const (
-    lldpEtherType = 0x88CC
-    cdpEtherType  = 0x2000
+    cdpEtherType  = 0x2000 // carried as a SNAP protocol ID, not an Ethernet II type
     arpEtherType  = 0x0806
+    ipv6EtherType = 0x86dd
+    stpEtherType  = 0x0042 // the LLC SAP of an 802.3 frame, not an Ethernet II type
 )
 
-// LLDP TLV type constants (minimal subset).
+// lldpTxHoldMultiplier is the 802.1AB default msgTxHold: advertised TTL = interval * 4.
+const lldpTxHoldMultiplier = 4
+
+// pcapngSectionHeaderMagic is the block type that starts every pcapng file.
+const pcapngSectionHeaderMagic = 0x0A0D0D0A
+
+// Protocol names recorded on edges (and used as metric labels).
 const (
-    lldpTLVTypeEnd        = 0
-    lldpTLVTypeChassisID  = 1
-    lldpTLVTypePortID     = 2
-    lldpTLVTypeTTL        = 3
-    lldpTLVTypePortDesc   = 4
-    lldpTLVTypeSystemName = 5
-    // … add more if needed
+    protocolLLDP = "lldp"
+    protocolCDP  = "cdp"
+    protocolARP  = "arp"
+    protocolND   = "nd"
+    protocolLACP = "lacp"
+    protocolSTP  = "stp"
 )
 
-// NeighborInfo holds basic info for discovered neighbors (for ARP/CDP).
-type NeighborInfo struct {
-    InterfaceName string
-    SourceMAC     string
-    Protocol      string
-    Details       string // Could store more structured info
-}
+// Interface capture states reported by topology.InterfaceStatus.
+const (
+    ifaceStateCapturing = "capturing"
+    ifaceStateFailed    = "failed"
+    ifaceStateStopped   = "stopped"
+)
 
-// Node represents one end of a link, e.g. (device=switch1, interface=Eth0/1, mac=aa:bb:cc...).
-type Node struct {
-    Device    string `json:"device"`
-    Interface string `json:"interface"`
-    MAC       string `json:"mac,omitempty"`
-    // You could add more fields if needed (e.g., VLAN, IP, etc.)
+// edgeKey identifies a link: (local device, local interface, remote chassis, remote port).
+type edgeKey struct {
+    localDevice     string
+    localInterface  string
+    remoteChassis   string
+    remoteInterface string
 }
 
-// Edge links two Nodes (Local -> Remote).
-type Edge struct {
-    Local  Node `json:"local"`
-    Remote Node `json:"remote"`
-}
+// liveConfig holds the command-line settings for a live capture.
+type liveConfig struct {
+    Duration       int    // seconds to capture, 0 = until interrupted
+    LLDPTx         string // comma-separated interfaces to advertise on
+    LLDPTxInterval int    // seconds between advertisements
+    Listen         string // HTTP API listen address (daemon mode), "" = off
+    PushGateway    string // Prometheus Pushgateway base URL, "" = off
+    PushInterval   int    // seconds between pushes
+    Select         interfaceSelection
 
-var (
-    // edges holds discovered LLDP edges in a global slice.
-    edges   []Edge
-    edgesMu sync.Mutex
+    UntilComplete   bool // stop once every interface with carrier has a neighbor
+    CompleteTimeout int  // seconds to wait at most with UntilComplete
 
-    // discoveredNeighbors is used for ARP/CDP logging.
-    discoveredNeighbors sync.Map
+    Hotplug bool // follow links that appear, come up or go away (always on in daemon mode)
+}
 
+var (
     // localHostname caches our local hostname once for clarity.
     localHostname string
+
+    // offlineReplay is set when processing a saved capture file rather than live interfaces.
+    offlineReplay bool
+
+    // nicGPUs maps local netdevs to their closest GPU; filled in once before a live capture starts.
+    nicGPUs map[string]*topology.GPU
+
+    // sysfsRoot and procRoot are where sysfs and procfs are mounted; tests point them at a fake tree.
+    sysfsRoot = "/sys"
+    procRoot  = "/proc"
+
+    // startTime is when this netgraph process started, reported by /healthz.
+    startTime = time.Now()
+
+    // advertisedIfaces lists the interfaces we send our own LLDPDUs on (-lldp-tx).
+    // It is filled in before any capture starts and only read afterwards.
+    advertisedIfaces = make(map[string]bool)
 )
 
 func main() {
     // Use flags for a user-specified duration and output file.
     outputFile := flag.String("out", "", "Output JSON file for edges")
     captureDuration := flag.Int("duration", 0, "Capture time in seconds (0 means run until Ctrl+C)")
+    readFile := flag.String("read", "", "Replay a saved pcap/pcapng file instead of capturing live")
+    readIface := flag.String("iface", "", "With -read: local interface name to record on edges (default: pcapng interface name)")
+    readHost := flag.String("host", "", "With -read: local device name to record on edges (default: this host's hostname)")
+    lldpTx := flag.String("lldp-tx", "", "Comma-separated interfaces to send our own LLDP advertisements on (default: listen only)")
+    lldpTxInterval := flag.Int("lldp-tx-interval", 30, "Seconds between LLDP advertisements with -lldp-tx")
+    listenAddr := flag.String("listen", "", "Daemon mode: serve the live neighbor table over HTTP on this address (e.g. :9110)")
+    pushGateway := flag.String("push-gateway", "", "Prometheus Pushgateway URL to push metrics to (e.g. http://pgw:9091)")
+    pushInterval := flag.Int("push-interval", 30, "Seconds between Pushgateway pushes during a live capture")
+    includeIfaces := flag.String("include", "", "Comma-separated interface patterns to capture on (globs, or regexps prefixed with 're:')")
+    excludeIfaces := flag.String("exclude", "", "Comma-separated interface patterns to skip (globs, or regexps prefixed with 're:')")
+    physicalOnly := flag.Bool("physical", false, "Only capture on physical NICs (those with /sys/class/net/<if>/device)")
+    rdmaOnly := flag.Bool("rdma", false, "Only capture on netdevs backing an RDMA device (/sys/class/infiniband/*/device/net)")
+    losslessPriority := flag.Int("lossless-priority", -1, "Priority PFC must be enabled on for the DCBX report (default: the most common one)")
+    untilComplete := flag.Bool("until-complete", false, "Stop as soon as every captured interface with carrier has seen an LLDP or CDP neighbor")
+    completeTimeout := flag.Int("complete-timeout", 120, "With -until-complete: seconds to wait at most before reporting the silent interfaces")
+    hotplug := flag.Bool("hotplug", false, "Start and stop captures as interfaces appear, come up, go down or disappear (always on with -listen)")
+    captureMode := flag.String("capture", defaultCaptureBackend, "Capture backend: pcap (libpcap) or afpacket (Linux raw sockets, works without libpcap)")
 
     flag.Parse()
 
@@ -89,28 +146,99 @@ func main() {
     }
     localHostname = h
 
+    switch *captureMode {
+    case captureBackendPcap, captureBackendAFPacket:
+        captureBackend = *captureMode
+    default:
+        log.Fatalf("Invalid -capture %q: want pcap or afpacket", *captureMode)
+    }
+
+    selection := interfaceSelection{PhysicalOnly: *physicalOnly, RDMAOnly: *rdmaOnly}
+    if selection.Include, err = parseInterfacePatterns(*includeIfaces); err != nil {
+        log.Fatalf("Invalid -include: %v", err)
+    }
+    if selection.Exclude, err = parseInterfacePatterns(*excludeIfaces); err != nil {
+        log.Fatalf("Invalid -exclude: %v", err)
+    }
+
+    agg := newAggregator(eventQueueLen)
+    if *readFile != "" {
+        if *readHost != "" {
+            localHostname = *readHost
+        }
+        // The capture was taken elsewhere, so local interface MACs on this host mean nothing.
+        offlineReplay = true
+        startReplayCollection(*readFile)
+        lastPacket, err := replayCaptureFile(agg, *readFile, *readIface)
+        if err != nil {
+            log.Fatalf("Error replaying %s: %v", *readFile, err)
+        }
+        // Judge TTL expiry against the end of the capture, not the time of the replay.
+        agg.markStaleEdges(lastPacket)
+    } else if !captureLive(agg, liveConfig{
+        Duration:       *captureDuration,
+        LLDPTx:         *lldpTx,
+        LLDPTxInterval: *lldpTxInterval,
+        Listen:         *listenAddr,
+        PushGateway:    *pushGateway,
+        PushInterval:   *pushInterval,
+        Select:         selection,
+
+        UntilComplete:   *untilComplete,
+        CompleteTimeout: *completeTimeout,
+
+        Hotplug: *hotplug,
+    }) {
+        return
+    } else {
+        agg.markStaleEdges(time.Now())
+    }
+
+    reportResults(agg, *outputFile, *losslessPriority)
+
+    // Final push so one-shot runs (and the end of a daemon run) are reflected too.
+    if *pushGateway != "" {
+        if err := pushMetrics(agg, &http.Client{Timeout: pushTimeout}, *pushGateway, localHostname); err != nil {
+            log.Printf("Error pushing metrics to %s: %v\n", *pushGateway, err)
+        }
+    }
+}
+
+// captureLive captures on every device until the duration expires or we are interrupted,
+// optionally advertising ourselves via LLDP and serving the live tables over HTTP.
+// Everything captured is collected by a. It returns false if there was nothing to capture on.
+func captureLive(a *aggregator, cfg liveConfig) bool {
     // Find all network devices.
-    devices, err := pcap.FindAllDevs()
+    devices, err := captureDevices()
     if err != nil {
         log.Fatalf("Error finding devices: %v", err)
     }
     if len(devices) == 0 {
         log.Println("No devices found. Exiting.")
-        return
+        return false
+    }
+    devices = cfg.Select.filter(devices)
+    if len(devices) == 0 {
+        log.Println("No devices match the interface selection. Exiting.")
+        return false
     }
 
-    // We want to capture LLDP (0x88cc), CDP (0x2000), and ARP (0x0806).
-    // The BPF filter uses "or" for multiple EtherTypes.
-    filter := "ether proto 0x88cc or ether proto 0x2000 or ether proto 0x0806"
+    nicGPUs = gpuAffinity()
 
     // Create a context that cancels on SIGINT/SIGTERM.
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
 
-    // Also, if captureDuration is set, stop after that many seconds.
-    if *captureDuration > 0 {
-        time.AfterFunc(time.Duration(*captureDuration)*time.Second, func() {
-            log.Printf("Capture time (%d seconds) is up, stopping...\n", *captureDuration)
+    // Also, if a duration is set, stop after that many seconds.
+    if cfg.Duration > 0 {
+        time.AfterFunc(time.Duration(cfg.Duration)*time.Second, func() {
+            log.Printf("Capture time (%d seconds) is up, stopping...\n", cfg.Duration)
+            cancel()
+        })
+    }
+    if cfg.UntilComplete && cfg.CompleteTimeout > 0 {
+        time.AfterFunc(time.Duration(cfg.CompleteTimeout)*time.Second, func() {
+            log.Printf("Not every interface saw a neighbor within %d seconds, stopping...\n", cfg.CompleteTimeout)
             cancel()
         })
     }
@@ -124,20 +252,75 @@ func main() {
         cancel()
     }()
 
+    for _, name := range strings.Split(cfg.LLDPTx, ",") {
+        if name = strings.TrimSpace(name); name != "" {
+            advertisedIfaces[name] = true
+        }
+    }
+
     // Start capturing from all devices in parallel.
     // We'll close them gracefully once the context is cancelled.
+    go a.run()
     var wg sync.WaitGroup
+    for name := range advertisedIfaces {
+        wg.Add(1)
+        go func(n string) {
+            defer wg.Done()
+            advertiseLLDP(ctx, n, time.Duration(cfg.LLDPTxInterval)*time.Second)
+        }(name)
+    }
+
+    // In daemon mode, serve the live tables over HTTP and drop edges whose TTL ran out.
+    if cfg.Listen != "" {
+        // Bind before starting so a bad address fails right away rather than silently.
+        ln, err := net.Listen("tcp", cfg.Listen)
+        if err != nil {
+            log.Fatalf("Error listening on %s: %v", cfg.Listen, err)
+        }
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            serveAPI(ctx, a, ln)
+        }()
+        go func() {
+            defer wg.Done()
+            expireEdgesLoop(ctx, a, edgeExpiryInterval)
+        }()
+    }
+    if cfg.PushGateway != "" && cfg.PushInterval > 0 {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            pushMetricsLoop(ctx, a, cfg.PushGateway, time.Duration(cfg.PushInterval)*time.Second)
+        }()
+    }
+    captures := newCaptureSet(ctx, a, &wg)
     for _, dev := range devices {
+        captures.start(dev)
+    }
+    start := time.Now()
+    startCollection(devices, captureFilter, start)
+    // NICs reset by a firmware update or links that only come up later are picked up as they change.
+    if cfg.Hotplug || cfg.Listen != "" {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            followLinks(ctx, captures, cfg.Select, devices)
+        }()
+    }
+    if cfg.UntilComplete {
         wg.Add(1)
-        go func(d pcap.Interface) {
+        go func() {
             defer wg.Done()
-            capturePackets(ctx, d.Name, filter)
-        }(dev)
+            untilCompleteLoop(ctx, a, cancel, devices, completeCheckInterval)
+        }()
     }
 
-    // Let the user know how to stop or how long we run if *captureDuration>0.
-    if *captureDuration > 0 {
-        log.Printf("Capturing for %d seconds...\n", *captureDuration)
+    // Let the user know how to stop or how long we run if cfg.Duration>0.
+    if cfg.UntilComplete {
+        log.Printf("Capturing until every interface with carrier has a neighbor (at most %d seconds)...\n", cfg.CompleteTimeout)
+    } else if cfg.Duration > 0 {
+        log.Printf("Capturing for %d seconds...\n", cfg.Duration)
     } else {
         log.Println("Capturing until Ctrl+C...")
     }
@@ -145,86 +328,238 @@ func main() {
     // Wait until context is done.
     <-ctx.Done()
 
-    // Wait for all goroutines to exit cleanly.
+    // Wait for all goroutines to exit cleanly, then for the frames still queued.
     wg.Wait()
+    a.stop()
+    endCollection(time.Now())
+
+    if cfg.UntilComplete {
+        findings := silentPortFindings(a, devices, hasCarrier, time.Since(start))
+        printCompletionReport(os.Stdout, devices, a.interfacesSnapshot(), hasCarrier, start)
+        for _, f := range findings {
+            fmt.Printf("  %s %s: %s\n", strings.ToUpper(f.Severity), f.Check, f.Message)
+        }
+        if len(findings) > 0 {
+            fmt.Println()
+        }
+    }
+    return true
+}
 
+// reportResults prints the discovered neighbors and edges and the DCBX report, and writes
+// the snapshot JSON to outputFile (or stdout if empty).
+func reportResults(a *aggregator, outputFile string, losslessPriority int) {
     // Print discovered neighbors for ARP/CDP
     fmt.Println("\nDiscovered Neighbors (ARP & CDP):")
-    discoveredNeighbors.Range(func(key, value interface{}) bool {
-        neighbor := value.(NeighborInfo)
-        fmt.Printf("  Key: %s, Interface: %s, SrcMAC: %s, Protocol: %s, Details: %s\n",
-            key, neighbor.InterfaceName, neighbor.SourceMAC, neighbor.Protocol, neighbor.Details)
-        return true
-    })
+    for _, neighbor := range a.neighborsSnapshot() {
+        fmt.Printf("  Interface: %s, SrcMAC: %s, Protocol: %s, Details: %s\n",
+            neighbor.InterfaceName, neighbor.SourceMAC, neighbor.Protocol, neighbor.Details)
+    }
     fmt.Println()
 
-    // Print discovered LLDP edges in text form:
-    fmt.Println("Discovered LLDP Edges:")
-    edgesMu.Lock()
-    for _, e := range edges {
-        // Just skip printing self-loops, if any
-        if e.Local.Device != e.Remote.Device {
-            fmt.Printf("  (%s, %s) -> (%s, %s)\n",
-                e.Local.Device, e.Local.Interface,
-                e.Remote.Device, e.Remote.Interface)
+    // Work on a copy with the ARP-learned IPs and the BPDUs attached to the remote ports.
+    out := attachBindings(a.rawEdges(), a.bindingsSnapshot())
+    stpPorts := a.stpSnapshot()
+    out = attachSTP(out, stpPorts)
+    ports := a.lacpSnapshot()
+    out, lags := bundleLAGs(out, ports, localBonds(ports))
+
+    // Print discovered LLDP/CDP edges in text form:
+    fmt.Println("Discovered LLDP/CDP Edges:")
+    for _, e := range out {
+        marks := ""
+        if e.Stale {
+            marks = " STALE"
         }
+        if e.Remote.STP != nil && e.Remote.STP.Blocked() {
+            marks += fmt.Sprintf(" BEHIND %s STP PORT", strings.ToUpper(e.Remote.STP.Role))
+        }
+        fmt.Printf("  (%s, %s) -> (%s, %s) [%s, %d frames%s]\n",
+            e.Local.Device, e.Local.Interface,
+            e.Remote.Device, e.Remote.Interface, e.Protocol, e.Frames, marks)
     }
-    edgesMu.Unlock()
     fmt.Println()
+    expected, dcbxPorts := dcbxReport(out, losslessPriority)
+    printDCBXReport(os.Stdout, expected, dcbxPorts, losslessPriority < 0)
+    printLinkFindings(os.Stdout, out)
+    printPortFindings(os.Stdout, portFindings(out, a.ownFramesSnapshot()))
+    printRailMap(os.Stdout, out)
+    printL3Report(os.Stdout, currentL3Report(a))
+    printLAGReport(os.Stdout, lags, ports)
+    printSTPReport(os.Stdout, stpPorts, out)
+    statuses := a.interfacesSnapshot()
+    printLinkTransitions(os.Stdout, statuses)
+    printCaptureCounters(os.Stdout, statuses)
 
-    // Also output edges in JSON form (to file or stdout).
-    edgesMu.Lock()
-    jsonData, err := json.MarshalIndent(edges, "", "  ")
-    edgesMu.Unlock()
+    // Also output the snapshot (edges, neighbors and capture state) in JSON form (to file or stdout).
+    jsonData, err := topology.MarshalSnapshot(currentSnapshot(a, out))
     if err != nil {
-        log.Printf("Error marshaling edges to JSON: %v\n", err)
+        log.Printf("Error marshaling snapshot to JSON: %v\n", err)
         return
     }
 
-    if *outputFile != "" {
-        if err := os.WriteFile(*outputFile, jsonData, 0644); err != nil {
-            log.Printf("Error writing JSON to file '%s': %v\n", *outputFile, err)
+    if outputFile != "" {
+        if err := os.WriteFile(outputFile, jsonData, 0644); err != nil {
+            log.Printf("Error writing JSON to file '%s': %v\n", outputFile, err)
         } else {
-            fmt.Printf("Wrote LLDP edges JSON to %s\n", *outputFile)
+            fmt.Printf("Wrote netgraph snapshot JSON to %s\n", outputFile)
         }
     } else {
-        fmt.Println("LLDP Edges in JSON:")
+        fmt.Println("Netgraph snapshot in JSON:")
         fmt.Println(string(jsonData))
     }
 }
 
-// capturePackets opens a pcap handle on the given interface, applies the BPF filter,
-// and reads packets until the context is cancelled or an error occurs.
-func capturePackets(ctx context.Context, deviceName, filter string) {
-    // Use a short read timeout (1 second). This ensures we can periodically check the context and exit.
-    handle, err := pcap.OpenLive(deviceName, 65535, true, 1*time.Second)
-    if err != nil {
-        log.Printf("pcap OpenLive failed on %s: %v", deviceName, err)
-        return
+// ---- Interface selection ----
+
+// interfaceMatcher reports whether an interface name matches one -include/-exclude pattern.
+type interfaceMatcher func(name string) bool
+
+// interfaceSelection decides which devices returned by captureDevices get captured on.
+type interfaceSelection struct {
+    Include      []interfaceMatcher // if non-empty, a device must match at least one
+    Exclude      []interfaceMatcher // a device matching any of these is skipped
+    PhysicalOnly bool               // only devices backed by hardware (sysfs "device" link)
+    RDMAOnly     bool               // only netdevs that back an RDMA device
+}
+
+// parseInterfacePatterns turns a comma-separated pattern list into matchers.
+// Patterns are shell globs (e.g. "enp*s0np0"); a "re:" prefix makes one a regular expression.
+func parseInterfacePatterns(list string) ([]interfaceMatcher, error) {
+    var matchers []interfaceMatcher
+    for _, pattern := range strings.Split(list, ",") {
+        pattern = strings.TrimSpace(pattern)
+        if pattern == "" {
+            continue
+        }
+        if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+            re, err := regexp.Compile(expr)
+            if err != nil {
+                return nil, fmt.Errorf("bad regexp %q: %w", expr, err)
+            }
+            matchers = append(matchers, re.MatchString)
+            continue
+        }
+        if _, err := path.Match(pattern, ""); err != nil {
+            return nil, fmt.Errorf("bad glob %q: %w", pattern, err)
+        }
+        glob := pattern
+        matchers = append(matchers, func(name string) bool {
+            ok, _ := path.Match(glob, name)
+            return ok
+        })
     }
-    defer handle.Close()
+    return matchers, nil
+}
+
+// filter returns the devices that pass the selection, logging the ones it skips.
+func (sel interfaceSelection) filter(devices []string) []string {
+    var rdma map[string]string
+    if sel.RDMAOnly {
+        rdma = rdmaNetdevs()
+    }
+    var selected []string
+    for _, dev := range devices {
+        if reason := sel.skipReason(dev, rdma); reason != "" {
+            log.Printf("Skipping interface %s (%s)\n", dev, reason)
+            continue
+        }
+        selected = append(selected, dev)
+    }
+    return selected
+}
+
+// skipReason explains why name is not selected, or returns "" if it is.
+// rdma maps netdev names to RDMA devices and is only consulted in RDMAOnly mode.
+func (sel interfaceSelection) skipReason(name string, rdma map[string]string) string {
+    if len(sel.Include) > 0 && !matchesAny(sel.Include, name) {
+        return "not in -include"
+    }
+    if matchesAny(sel.Exclude, name) {
+        return "matches -exclude"
+    }
+    if sel.PhysicalOnly && !isPhysicalInterface(name) {
+        return "not a physical NIC"
+    }
+    if sel.RDMAOnly && rdma[name] == "" {
+        return "no RDMA device"
+    }
+    return ""
+}
+
+// matchesAny reports whether any matcher accepts name.
+func matchesAny(matchers []interfaceMatcher, name string) bool {
+    for _, m := range matchers {
+        if m(name) {
+            return true
+        }
+    }
+    return false
+}
+
+// isPhysicalInterface reports whether the netdev is backed by a device (PCI etc.).
+// Virtual interfaces such as lo, bonds, bridges, veth and docker0 have no "device" link.
+func isPhysicalInterface(name string) bool {
+    _, err := os.Stat(filepath.Join(sysfsRoot, "class", "net", name, "device"))
+    return err == nil
+}
+
+// rdmaNetdevs maps each netdev that backs an RDMA device to that device's name,
+// e.g. "enp121s0" -> "ionic_0", using /sys/class/infiniband/<dev>/device/net/<netdev>.
+func rdmaNetdevs() map[string]string {
+    netdevs := make(map[string]string)
+    matches, _ := filepath.Glob(filepath.Join(sysfsRoot, "class", "infiniband", "*", "device", "net", "*"))
+    for _, m := range matches {
+        rdmaDev := filepath.Base(filepath.Dir(filepath.Dir(filepath.Dir(m))))
+        netdevs[filepath.Base(m)] = rdmaDev
+    }
+    return netdevs
+}
 
-    if err := handle.SetBPFFilter(filter); err != nil {
-        log.Printf("SetBPFFilter failed on %s: %v", deviceName, err)
+// capturePackets opens a packet source on the given interface with the capture filter
+// and reads packets until the context is cancelled or an error occurs. Every frame is
+// decoded here and sent to a; if a falls behind, reading waits (see aggregator.send).
+func capturePackets(ctx context.Context, a *aggregator, deviceName string) {
+    src, err := openPacketSource(deviceName, sourceOptions{
+        Snaplen: captureSnaplen,
+        Promisc: true,
+        Filter:  true,
+        // Don't pick up our own outgoing advertisements as a neighbor.
+        InboundOnly: advertisedIfaces[deviceName],
+    })
+    if err != nil {
+        log.Printf("Opening %s capture failed on %s: %v", captureBackend, deviceName, err)
+        a.setInterfaceState(deviceName, ifaceStateFailed, err)
         return
     }
-    log.Printf("Capturing on interface %s with filter (%s)\n", deviceName, filter)
+    defer src.Close()
 
-    packetSource := gopacket.NewPacketSource(handle, handle.LinkType())
+    a.setInterfaceState(deviceName, ifaceStateCapturing, nil)
+    defer a.setInterfaceState(deviceName, ifaceStateStopped, nil)
+    log.Printf("Capturing on interface %s (%s) with filter (%s)\n", deviceName, captureBackend, captureFilter)
+
+    var lastStats time.Time
+    defer recordPcapStats(a, deviceName, src)
 
     for {
         select {
         case <-ctx.Done():
             return
         default:
-            // Use NextPacket() to handle timeouts.
-            packet, err := packetSource.NextPacket()
+            // Refresh the kernel drop counters every few seconds.
+            if time.Since(lastStats) >= pcapStatsInterval {
+                recordPcapStats(a, deviceName, src)
+                lastStats = time.Now()
+            }
+
+            // Reads time out every captureReadTimeout so we notice cancellation.
+            data, ci, err := src.ReadPacketData()
             if err != nil {
                 if err == io.EOF {
                     // No more packets (interface closed?), just exit.
                     return
                 }
-                if err == pcap.NextErrorTimeoutExpired {
+                if err == errReadTimeout {
                     // Timeout - check if context is done.
                     if ctx.Err() != nil {
                         return
@@ -234,124 +569,275 @@ func capturePackets(ctx context.Context, deviceName, filter string) {
                 }
                 // Some other error.
                 log.Printf("Error reading packet on %s: %v", deviceName, err)
+                a.setInterfaceState(deviceName, ifaceStateFailed, err)
                 return
             }
+            packet := gopacket.NewPacket(data, src.LinkType(), gopacket.Default)
+            packet.Metadata().CaptureInfo = ci
             // Got a valid packet
             if debug {
                 log.Printf("NETGRAPH: got packet on %s (len=%d)\n",
                     deviceName, len(packet.Data()))
             }
-            processPacket(deviceName, packet)
+            if !a.send(ctx, decodePacket(deviceName, packet)) && debug {
+                log.Printf("NETGRAPH: event queue full, dropped a frame from %s\n", deviceName)
+            }
+        }
+    }
+}
+
+// etherTypeKey formats an EtherType as counted in topology.InterfaceStatus.PacketsByEtherType.
+func etherTypeKey(etherType uint16) string {
+    return fmt.Sprintf("0x%04x", etherType)
+}
+
+// pcapStatsInterval is how often capturePackets refreshes the capture drop counters.
+const pcapStatsInterval = 5 * time.Second
+
+// recordPcapStats copies the source's receive/drop counters into the interface status.
+func recordPcapStats(a *aggregator, deviceName string, src packetSource) {
+    stats, err := src.Stats()
+    if err != nil {
+        return
+    }
+    a.recordCaptureStats(deviceName, stats)
+}
+
+// replayCaptureFile feeds every packet of a saved pcap or pcapng file to a.
+// ifaceName overrides the interface recorded on edges; otherwise the pcapng interface
+// name is used, falling back to "unknown" for classic pcap files.
+// It returns the timestamp of the last packet in the file.
+func replayCaptureFile(a *aggregator, path, ifaceName string) (time.Time, error) {
+    var lastPacket time.Time
+    f, err := os.Open(path)
+    if err != nil {
+        return lastPacket, err
+    }
+    defer f.Close()
+
+    r := bufio.NewReader(f)
+    magic, err := r.Peek(4)
+    if err != nil {
+        return lastPacket, fmt.Errorf("reading file header: %w", err)
+    }
+
+    var count int
+    // pcapng files start with a Section Header Block (0x0A0D0D0A), which reads the same in either byte order.
+    if binary.BigEndian.Uint32(magic) == pcapngSectionHeaderMagic {
+        ngr, err := pcapgo.NewNgReader(r, pcapgo.DefaultNgReaderOptions)
+        if err != nil {
+            return lastPacket, err
+        }
+        for {
+            data, ci, err := ngr.ReadPacketData()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                return lastPacket, err
+            }
+            name, linkType := ifaceName, ngr.LinkType()
+            if intf, err := ngr.Interface(ci.InterfaceIndex); err == nil {
+                linkType = intf.LinkType
+                if name == "" {
+                    name = intf.Name
+                }
+            }
+            if name == "" {
+                name = "unknown"
+            }
+            replayPacket(a, name, linkType, data, ci)
+            lastPacket = ci.Timestamp
+            count++
+        }
+    } else {
+        pr, err := pcapgo.NewReader(r)
+        if err != nil {
+            return lastPacket, err
+        }
+        name := ifaceName
+        if name == "" {
+            name = "unknown"
+        }
+        for {
+            data, ci, err := pr.ReadPacketData()
+            if err == io.EOF {
+                break
+            }
+            if err != nil {
+                return lastPacket, err
+            }
+            replayPacket(a, name, pr.LinkType(), data, ci)
+            lastPacket = ci.Timestamp
+            count++
         }
     }
+
+    log.Printf("Replayed %d packets from %s\n", count, path)
+    return lastPacket, nil
+}
+
+// replayPacket decodes one packet from a capture file, keeping its original capture metadata.
+func replayPacket(a *aggregator, deviceName string, linkType layers.LinkType, data []byte, ci gopacket.CaptureInfo) {
+    packet := gopacket.NewPacket(data, linkType, gopacket.Default)
+    packet.Metadata().CaptureInfo = ci
+    noteReplayedPacket(deviceName, ci.Timestamp)
+    a.processPacket(deviceName, packet)
 }
 
-// processPacket routes packets to the right handler based on EtherType.
-func processPacket(deviceName string, packet gopacket.Packet) {
+// decodePacket routes packets to the right decoder based on EtherType and returns what
+// they carried. It touches no shared state, so capture workers run it concurrently.
+func decodePacket(deviceName string, packet gopacket.Packet) frameEvent {
+    // Use the capture timestamp so replayed files keep their original timing.
+    ev := frameEvent{Iface: deviceName, Time: packet.Metadata().Timestamp}
+    if ev.Time.IsZero() {
+        ev.Time = time.Now()
+    }
+
     ethLayer := packet.Layer(layers.LayerTypeEthernet)
     if ethLayer == nil {
-        return
+        return ev
     }
     eth, _ := ethLayer.(*layers.Ethernet)
+    ev.SrcMAC = eth.SrcMAC.String()
+
+    // CDP frames carry a length instead of an EtherType; the 0x2000 protocol ID is in the SNAP header.
+    if snapLayer := packet.Layer(layers.LayerTypeSNAP); snapLayer != nil {
+        if snap, _ := snapLayer.(*layers.SNAP); uint16(snap.Type) == cdpEtherType {
+            ev.EtherType = cdpEtherType
+            cdpLayer := packet.Layer(layers.LayerTypeCiscoDiscoveryInfo)
+            if cdpLayer == nil {
+                ev.fail(protocolCDP)
+                return ev
+            }
+            cdp, _ := cdpLayer.(*layers.CiscoDiscoveryInfo)
+            decodeCDP(&ev, eth, packet, cdp)
+            return ev
+        }
+    }
+
+    // STP BPDUs are LLC frames too, with DSAP and SSAP 0x42.
+    if llcLayer := packet.Layer(layers.LayerTypeLLC); llcLayer != nil {
+        if llc, _ := llcLayer.(*layers.LLC); llc.DSAP == stp.LLCSAP && llc.SSAP == stp.LLCSAP {
+            ev.EtherType = stpEtherType
+            decodeSTP(&ev, eth, llc)
+            return ev
+        }
+    }
 
+    ev.EtherType = uint16(eth.EthernetType)
     switch uint16(eth.EthernetType) {
-    case lldpEtherType:
-        handleLLDPPacket(deviceName, eth)
-    case cdpEtherType:
-        handleCDPPacket(deviceName, eth)
+    case lldp.EtherType:
+        decodeLLDP(&ev, eth)
     case arpEtherType:
-        handleARPPacket(deviceName, eth, packet)
+        decodeARP(&ev, eth, packet)
+    case ipv6EtherType:
+        decodeND(&ev, eth, packet)
+    case lacp.EtherType:
+        decodeLACP(&ev, eth)
     default:
-        // Not LLDP, CDP, or ARP - ignore
+        // Not LLDP, CDP, ARP, IPv6, LACP or STP - ignore
     }
+    return ev
 }
 
 // ---- LLDP Handling ----
 
-// LLDPFields holds fields we care about from the LLDP payload.
-type LLDPFields struct {
-    ChassisID  string
-    PortID     string
-    SystemName string
-}
-
-// handleLLDPPacket decodes the LLDP data and stores it as an edge in our graph.
-func handleLLDPPacket(deviceName string, eth *layers.Ethernet) {
+// decodeLLDP decodes the LLDP data into an edge of our graph.
+func decodeLLDP(ev *frameEvent, eth *layers.Ethernet) {
     payload := eth.Payload
-    fields := parseLLDPFields(payload)
+    fields := lldp.Parse(payload)
+    // Chassis ID and Port ID are mandatory; without them the LLDPDU is malformed and
+    // can't identify a link.
+    if fields.ChassisID == "" || fields.PortID == "" {
+        ev.fail(protocolLLDP)
+        return
+    }
 
-    // If we have no system name but a chassis, use the chassis ID as the "device name".
+    // If we have no system name, use the chassis ID as the "device name".
     remoteDeviceName := fields.SystemName
     if remoteDeviceName == "" {
         remoteDeviceName = fields.ChassisID
-        if remoteDeviceName == "" {
-            remoteDeviceName = "UnknownRemote"
-        }
     }
 
     // Build our local and remote nodes:
-    localNode := Node{
-        Device:    localHostname,
-        Interface: deviceName,
-        MAC:       getInterfaceMAC(deviceName).String(),
-    }
-    remoteNode := Node{
-        Device:    remoteDeviceName,
-        Interface: fields.PortID,
-        MAC:       eth.SrcMAC.String(),
+    localNode := newLocalNode(ev.Iface)
+    remoteNode := topology.Node{
+        Device:              remoteDeviceName,
+        Interface:           fields.PortID,
+        MAC:                 eth.SrcMAC.String(),
+        ChassisID:           fields.ChassisID,
+        ChassisIDSubtype:    fields.ChassisIDSubtype,
+        PortIDSubtype:       fields.PortIDSubtype,
+        TTL:                 fields.TTL,
+        PortDescription:     fields.PortDescription,
+        SystemDescription:   fields.SystemDescription,
+        Capabilities:        fields.Capabilities,
+        EnabledCapabilities: fields.EnabledCapabilities,
+        ManagementAddresses: fields.ManagementAddresses,
+        PortVLANID:          fields.PortVLANID,
+        ProtocolVLANIDs:     fields.ProtocolVLANIDs,
+        VLANNames:           fields.VLANNames,
+        LinkAggregation:     fields.LinkAggregation,
+        MACPHY:              fields.MACPHY,
+        MaxFrameSize:        fields.MaxFrameSize,
+        DCBX:                fields.DCBX,
     }
 
-    // Store the edge in our global slice:
-    edgesMu.Lock()
-    edges = append(edges, Edge{Local: localNode, Remote: remoteNode})
-    edgesMu.Unlock()
+    ev.Edge = &edgeEvent{Protocol: protocolLLDP, Local: localNode, Remote: remoteNode}
 }
 
-// parseLLDPFields does a minimal parse of the LLDP TLV structure
-// and returns the key fields (ChassisID, PortID, SystemName).
-func parseLLDPFields(payload []byte) LLDPFields {
-    var fields LLDPFields
-    offset := 0
-
-    for offset < len(payload) {
-        // Need at least 2 bytes for a TLV header.
-        if len(payload[offset:]) < 2 {
-            break
-        }
-        // 2-byte TLV header: [7 bits of Type | 9 bits of Length]
-        tlvHeader := binary.BigEndian.Uint16(payload[offset : offset+2])
-        offset += 2
+// localNodeRefresh is how long newLocalNode reuses what it read about an interface;
+// a link event (see hotplug.go) makes it read the interface again sooner.
+const localNodeRefresh = 30 * time.Second
 
-        tlvType := tlvHeader >> 9
-        tlvLen := tlvHeader & 0x1FF
+var (
+    // localNodes caches the local end of edges per capture interface (see newLocalNode).
+    localNodes   = make(map[string]cachedNode)
+    localNodesMu sync.Mutex
+)
 
-        if tlvLen == 0 || offset+int(tlvLen) > len(payload) {
-            break
-        }
-        tlvValue := payload[offset : offset+int(tlvLen)]
-        offset += int(tlvLen)
+// cachedNode is a local node and when it was read.
+type cachedNode struct {
+    node topology.Node
+    read time.Time
+}
 
-        switch tlvType {
-        case lldpTLVTypeEnd:
-            // End of LLDPDU
-            return fields
-        case lldpTLVTypeChassisID:
-            // Byte 0 is sub-type, so actual chassis ID data is after that
-            if len(tlvValue) > 1 {
-                fields.ChassisID = string(tlvValue[1:])
-            }
-        case lldpTLVTypePortID:
-            // Byte 0 is sub-type, so actual port ID data is after that
-            if len(tlvValue) > 1 {
-                fields.PortID = string(tlvValue[1:])
-            }
-        case lldpTLVTypeSystemName:
-            // System name is directly the entire TLV value
-            fields.SystemName = string(tlvValue)
-        // You can also parse TTL, Port Description, etc.
-        }
+// newLocalNode builds the local end of an edge for the given capture interface. It is
+// called for every LLDP/CDP frame, so the link settings, NIC inventory, addresses and bond
+// read from sysfs and the kernel are cached for localNodeRefresh or until forgetLocalNode.
+// Cached nodes are shared and must not be modified.
+func newLocalNode(deviceName string) topology.Node {
+    node := topology.Node{
+        Device:    localHostname,
+        Interface: deviceName,
+    }
+    if offlineReplay {
+        return node
+    }
+    now := time.Now()
+    localNodesMu.Lock()
+    c, ok := localNodes[deviceName]
+    if !ok || now.Sub(c.read) >= localNodeRefresh {
+        node.MAC = getInterfaceMAC(deviceName).String()
+        readLinkSettings(&node)
+        node.NIC = readNICInventory(deviceName)
+        node.GPU = nicGPUs[deviceName]
+        node.IPs = localIPs(deviceName)
+        node.Bond = readBond(deviceName)
+        c = cachedNode{node: node, read: now}
+        localNodes[deviceName] = c
     }
-    return fields
+    localNodesMu.Unlock()
+    return c.node
+}
+
+// forgetLocalNode drops what newLocalNode cached about deviceName, so the next frame
+// reads it again. Called when the link changes.
+func forgetLocalNode(deviceName string) {
+    localNodesMu.Lock()
+    delete(localNodes, deviceName)
+    localNodesMu.Unlock()
 }
 
 // getInterfaceMAC attempts to look up the local interface's MAC address.
@@ -363,63 +849,1152 @@ func getInterfaceMAC(ifName string) net.HardwareAddr {
     return iface.HardwareAddr
 }
 
+// ---- LLDP Transmit ----
+
+// advertiseLLDP periodically sends our LLDPDU on deviceName until the context is cancelled,
+// then sends a final shutdown LLDPDU (TTL 0) so neighbors drop us right away.
+func advertiseLLDP(ctx context.Context, deviceName string, interval time.Duration) {
+    if interval <= 0 {
+        log.Printf("Invalid LLDP transmit interval %v, not advertising on %s", interval, deviceName)
+        return
+    }
+    srcMAC := getInterfaceMAC(deviceName)
+    if len(srcMAC) != 6 {
+        log.Printf("No Ethernet MAC for %s, not advertising LLDP", deviceName)
+        return
+    }
+
+    handle, err := openPacketSource(deviceName, sourceOptions{Snaplen: 256, TransmitOnly: true})
+    if err != nil {
+        log.Printf("Opening %s LLDP transmit failed on %s: %v", captureBackend, deviceName, err)
+        return
+    }
+    defer handle.Close()
+
+    ttl := interval.Seconds() * lldpTxHoldMultiplier
+    if ttl > 65535 {
+        ttl = 65535
+    }
+    subtype, chassisID := localChassisID()
+    adv := lldp.Advertisement{
+        ChassisIDSubtype:    subtype,
+        ChassisID:           chassisID,
+        PortID:              deviceName,
+        SystemName:          localHostname,
+        TTL:                 uint16(ttl),
+        Capabilities:        lldp.CapabilityStation,
+        EnabledCapabilities: lldp.CapabilityStation,
+    }
+    log.Printf("Advertising LLDP on %s every %v (TTL %ds)\n", deviceName, interval, adv.TTL)
+
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        if err := handle.WritePacketData(lldp.BuildFrame(srcMAC, adv)); err != nil {
+            log.Printf("Sending LLDP on %s failed: %v", deviceName, err)
+        }
+        select {
+        case <-ctx.Done():
+            adv.TTL = 0
+            if err := handle.WritePacketData(lldp.BuildFrame(srcMAC, adv)); err != nil {
+                log.Printf("Sending LLDP shutdown on %s failed: %v", deviceName, err)
+            }
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// localChassisID picks the chassis ID we advertise: the systemd machine-id (locally assigned
+// subtype) if available, since it is the same on every port, otherwise the MAC address of the
+// first non-loopback interface.
+func localChassisID() (byte, []byte) {
+    if id := readMachineID(); id != "" {
+        return lldp.ChassisIDSubtypeLocal, []byte(id)
+    }
+    ifaces, err := net.Interfaces()
+    if err == nil {
+        sort.Slice(ifaces, func(i, j int) bool { return ifaces[i].Name < ifaces[j].Name })
+        for _, iface := range ifaces {
+            if iface.Flags&net.FlagLoopback == 0 && len(iface.HardwareAddr) == 6 {
+                return lldp.ChassisIDSubtypeMACAddress, iface.HardwareAddr
+            }
+        }
+    }
+    return lldp.ChassisIDSubtypeLocal, []byte(localHostname)
+}
+
 // ---- CDP Handling ----
 
-// handleCDPPacket logs CDP neighbor data. Optionally parse details to store in edges.
-func handleCDPPacket(deviceName string, eth *layers.Ethernet) {
-    payload := eth.Payload
+// decodeCDP decodes a CDP announcement into a neighbor and an edge.
+func decodeCDP(ev *frameEvent, eth *layers.Ethernet, packet gopacket.Packet, info *layers.CiscoDiscoveryInfo) {
+    remoteDeviceName := info.DeviceID
+    if remoteDeviceName == "" {
+        remoteDeviceName = info.SysName
+        if remoteDeviceName == "" {
+            remoteDeviceName = "UnknownRemote"
+        }
+    }
+
+    remoteNode := topology.Node{
+        Device:              remoteDeviceName,
+        Interface:           info.PortID,
+        MAC:                 eth.SrcMAC.String(),
+        Capabilities:        decodeCDPCapabilities(info.Capabilities),
+        ManagementAddresses: cdpManagementAddresses(info),
+        Platform:            info.Platform,
+        SoftwareVersion:     info.Version,
+        NativeVLAN:          info.NativeVLAN,
+    }
 
-    // Potentially parse the CDP payload (TLVs, device ID, port ID, etc.).
-    // For now we just store minimal info in discoveredNeighbors.
-    neighborKey := fmt.Sprintf("%s-CDP-%s", deviceName, eth.SrcMAC)
-    details := fmt.Sprintf("CDP payload length: %d bytes", len(payload))
+    // The CDP header (TTL) and the list of TLVs present live on the base CiscoDiscovery layer.
+    if cdpLayer := packet.Layer(layers.LayerTypeCiscoDiscovery); cdpLayer != nil {
+        cdp, _ := cdpLayer.(*layers.CiscoDiscovery)
+        remoteNode.TTL = uint16(cdp.TTL)
+        for _, v := range cdp.Values {
+            // FullDuplex defaults to false, so only report duplex if the TLV was actually sent.
+            if v.Type == layers.CDPTLVFullDuplex {
+                remoteNode.Duplex = "half"
+                if info.FullDuplex {
+                    remoteNode.Duplex = "full"
+                }
+            }
+        }
+    }
 
-    neighbor := NeighborInfo{
-        InterfaceName: deviceName,
+    neighborKey := fmt.Sprintf("%s-CDP-%s", ev.Iface, eth.SrcMAC)
+    details := fmt.Sprintf("CDP: DeviceID=%s, PortID=%s, Platform=%s, NativeVLAN=%d, MgmtAddrs=%v",
+        info.DeviceID, info.PortID, info.Platform, info.NativeVLAN, remoteNode.ManagementAddresses)
+
+    neighbor := topology.Neighbor{
+        InterfaceName: ev.Iface,
         SourceMAC:     eth.SrcMAC.String(),
         Protocol:      "CDP",
         Details:       details,
     }
-    discoveredNeighbors.Store(neighborKey, neighbor)
+    ev.Neighbor = &neighborEvent{Key: neighborKey, Neighbor: neighbor}
+    ev.Edge = &edgeEvent{Protocol: protocolCDP, Local: newLocalNode(ev.Iface), Remote: remoteNode}
+}
+
+// decodeCDPCapabilities turns the CDP capability flags into names matching the LLDP ones where possible.
+func decodeCDPCapabilities(caps layers.CDPCapabilities) []string {
+    var names []string
+    if caps.L3Router {
+        names = append(names, "router")
+    }
+    if caps.TBBridge || caps.SPBridge {
+        names = append(names, "bridge")
+    }
+    if caps.L2Switch {
+        names = append(names, "switch")
+    }
+    if caps.IsHost {
+        names = append(names, "host")
+    }
+    if caps.IGMPFilter {
+        names = append(names, "igmp")
+    }
+    if caps.L1Repeater {
+        names = append(names, "repeater")
+    }
+    if caps.IsPhone {
+        names = append(names, "telephone")
+    }
+    if caps.RemotelyManaged {
+        names = append(names, "remotely-managed")
+    }
+    return names
+}
 
-    // (Optional) You might add edges just like LLDP:
-    // localNode := Node{ ... }
-    // remoteNode := Node{ ... } // parse from CDP device ID, etc.
-    // storeEdge(localNode, remoteNode)
+// cdpManagementAddresses prefers the Management Address TLV and falls back to the Address TLV.
+func cdpManagementAddresses(info *layers.CiscoDiscoveryInfo) []string {
+    addrs := info.MgmtAddresses
+    if len(addrs) == 0 {
+        addrs = info.Addresses
+    }
+    var out []string
+    for _, ip := range addrs {
+        out = append(out, ip.String())
+    }
+    return out
 }
 
 // ---- ARP Handling ----
 
-// handleARPPacket logs ARP neighbor data. We could also store them in a graph.
-func handleARPPacket(deviceName string, eth *layers.Ethernet, packet gopacket.Packet) {
+// decodeARP decodes the sender's IP to MAC binding (see l3.go) and the neighbor.
+// Gratuitous ARPs (sender IP == target IP) are marked as such; probes from 0.0.0.0 bind nothing.
+func decodeARP(ev *frameEvent, eth *layers.Ethernet, packet gopacket.Packet) {
     arpLayer := packet.Layer(layers.LayerTypeARP)
     if arpLayer == nil {
+        ev.fail(protocolARP)
         return
     }
     arp, _ := arpLayer.(*layers.ARP)
 
-    neighborKey := fmt.Sprintf("%s-ARP-%s", deviceName, eth.SrcMAC)
+    senderIP := net.IP(arp.SourceProtAddress)
+    source := bindingSourceARP
+    if senderIP.Equal(net.IP(arp.DstProtAddress)) {
+        source = bindingSourceGARP
+    }
+    ev.Binding = &bindingEvent{IP: senderIP, MAC: net.HardwareAddr(arp.SourceHwAddress), Source: source}
+
+    neighborKey := fmt.Sprintf("%s-ARP-%s", ev.Iface, eth.SrcMAC)
     details := fmt.Sprintf("ARP: SenderIP=%s, SenderMAC=%s, TargetIP=%s, TargetMAC=%s",
         net.IP(arp.SourceProtAddress).String(),
         net.HardwareAddr(arp.SourceHwAddress).String(),
         net.IP(arp.DstProtAddress).String(),
         net.HardwareAddr(arp.DstHwAddress).String())
 
-    neighbor := NeighborInfo{
-        InterfaceName: deviceName,
+    neighbor := topology.Neighbor{
+        InterfaceName: ev.Iface,
         SourceMAC:     eth.SrcMAC.String(),
+        IP:            senderIP.String(),
         Protocol:      "ARP",
         Details:       details,
     }
-    discoveredNeighbors.Store(neighborKey, neighbor)
+    ev.Neighbor = &neighborEvent{Key: neighborKey, Neighbor: neighbor}
+}
+
+// ---- LACP Handling ----
+
+// decodeLACP decodes the LACPDU as the interface's latest LACP state (see lag.go) and
+// the partner. Other Slow Protocols (Marker, OAM) are ignored.
+func decodeLACP(ev *frameEvent, eth *layers.Ethernet) {
+    pdu, err := lacp.Parse(eth.Payload)
+    if errors.Is(err, lacp.ErrNotLACP) {
+        return
+    }
+    if err != nil {
+        ev.fail(protocolLACP)
+        if debug {
+            log.Printf("LACP on %s: %v", ev.Iface, err)
+        }
+        return
+    }
+    ev.LACP = &pdu
 
-    // (Optional) you can unify with edges if you want to represent ARP as well.
+    neighborKey := fmt.Sprintf("%s-LACP-%s", ev.Iface, eth.SrcMAC)
+    details := fmt.Sprintf("LACP: ActorSystem=%s, ActorKey=%d, ActorPort=%d, ActorState=%s, PartnerSystem=%s, PartnerKey=%d, PartnerState=%s",
+        pdu.Actor.System, pdu.Actor.Key, pdu.Actor.Port, pdu.Actor.State,
+        pdu.Partner.System, pdu.Partner.Key, pdu.Partner.State)
+    ev.Neighbor = &neighborEvent{Key: neighborKey, Neighbor: topology.Neighbor{
+        InterfaceName: ev.Iface,
+        SourceMAC:     eth.SrcMAC.String(),
+        Protocol:      "LACP",
+        Details:       details,
+    }}
 }
 
-// storeEdge is a helper function if you want a uniform approach for any discovered link.
-// (Example usage: unify LLDP/CDP into the same adjacency structure.)
-func storeEdge(local, remote Node) {
-    edgesMu.Lock()
-    defer edgesMu.Unlock()
-    edges = append(edges, Edge{Local: local, Remote: remote})
+// ---- STP Handling ----
+
+// decodeSTP decodes a BPDU into the spanning tree state of the receiving interface
+// (see spantree.go) and logs its sender as a neighbor.
+func decodeSTP(ev *frameEvent, eth *layers.Ethernet, llc *layers.LLC) {
+    b, err := stp.Parse(llc.Payload)
+    if err != nil {
+        ev.fail(protocolSTP)
+        if debug {
+            log.Printf("STP on %s: %v", ev.Iface, err)
+        }
+        return
+    }
+    ev.STP = &b
+
+    details := "STP: topology change notification"
+    if b.Type != stp.TypeTCN {
+        details = fmt.Sprintf("STP: Version=%s, Root=%s, RootPathCost=%d, Bridge=%s, Port=%s",
+            b.VersionName(), b.Root, b.RootPathCost, b.Bridge, b.Port)
+        if b.Role != "" {
+            details += fmt.Sprintf(", Role=%s, State=%s", b.Role, b.State)
+        }
+    }
+    ev.Neighbor = &neighborEvent{Key: fmt.Sprintf("%s-STP-%s", ev.Iface, eth.SrcMAC), Neighbor: topology.Neighbor{
+        InterfaceName: ev.Iface,
+        SourceMAC:     eth.SrcMAC.String(),
+        Protocol:      "STP",
+        Details:       details,
+    }}
+}
+
+// ---- IPv6 Neighbor Discovery Handling ----
+
+// ndHopLimit is the hop limit every neighbor discovery message must carry (RFC 4861);
+// anything lower was forwarded by a router and did not originate on this link.
+const ndHopLimit = 255
+
+// decodeND decodes the IP to MAC bindings carried by ICMPv6 neighbor solicitations,
+// neighbor advertisements and router advertisements, and the routers and prefixes the
+// advertisements announce (see l3.go). Other IPv6 traffic is ignored.
+func decodeND(ev *frameEvent, eth *layers.Ethernet, packet gopacket.Packet) {
+    ip6Layer := packet.Layer(layers.LayerTypeIPv6)
+    icmpLayer := packet.Layer(layers.LayerTypeICMPv6)
+    if ip6Layer == nil || icmpLayer == nil {
+        return
+    }
+    ip6, _ := ip6Layer.(*layers.IPv6)
+    icmp, _ := icmpLayer.(*layers.ICMPv6)
+    if ip6.HopLimit != ndHopLimit {
+        return
+    }
+
+    var ip net.IP
+    var mac net.HardwareAddr
+    var details string
+    switch icmp.TypeCode.Type() {
+    case layers.ICMPv6TypeNeighborSolicitation:
+        nsLayer := packet.Layer(layers.LayerTypeICMPv6NeighborSolicitation)
+        if nsLayer == nil {
+            ev.fail(protocolND)
+            return
+        }
+        ns, _ := nsLayer.(*layers.ICMPv6NeighborSolicitation)
+        // Duplicate address detection probes come from :: and bind nothing.
+        ip, mac = ip6.SrcIP, ndLinkAddr(ns.Options, layers.ICMPv6OptSourceAddress, eth.SrcMAC)
+        details = fmt.Sprintf("ND: NS SourceIP=%s, SourceMAC=%s, Target=%s", ip, mac, ns.TargetAddress)
+    case layers.ICMPv6TypeNeighborAdvertisement:
+        naLayer := packet.Layer(layers.LayerTypeICMPv6NeighborAdvertisement)
+        if naLayer == nil {
+            ev.fail(protocolND)
+            return
+        }
+        na, _ := naLayer.(*layers.ICMPv6NeighborAdvertisement)
+        ip, mac = na.TargetAddress, ndLinkAddr(na.Options, layers.ICMPv6OptTargetAddress, eth.SrcMAC)
+        details = fmt.Sprintf("ND: NA Target=%s, TargetMAC=%s, Router=%t, Solicited=%t, Override=%t",
+            ip, mac, na.Router(), na.Solicited(), na.Override())
+    case layers.ICMPv6TypeRouterAdvertisement:
+        raLayer := packet.Layer(layers.LayerTypeICMPv6RouterAdvertisement)
+        if raLayer == nil {
+            ev.fail(protocolND)
+            return
+        }
+        ra, _ := raLayer.(*layers.ICMPv6RouterAdvertisement)
+        ip, mac = ip6.SrcIP, ndLinkAddr(ra.Options, layers.ICMPv6OptSourceAddress, eth.SrcMAC)
+        router := routerFromRA(ra)
+        router.IP, router.MAC = ip.String(), mac.String()
+        ev.Router = &router
+        details = fmt.Sprintf("ND: RA Router=%s, RouterMAC=%s, Lifetime=%ds, Prefixes=%d",
+            ip, mac, router.Lifetime, len(router.Prefixes))
+    default:
+        // Router solicitations come from hosts that know nothing yet.
+        return
+    }
+    if ip.IsUnspecified() {
+        return
+    }
+    ev.Binding = &bindingEvent{IP: ip, MAC: mac, Source: bindingSourceND}
+
+    neighborKey := fmt.Sprintf("%s-ND-%s-%s", ev.Iface, mac, ip)
+    ev.Neighbor = &neighborEvent{Key: neighborKey, Neighbor: topology.Neighbor{
+        InterfaceName: ev.Iface,
+        SourceMAC:     eth.SrcMAC.String(),
+        IP:            ip.String(),
+        Protocol:      "ND",
+        Details:       details,
+    }}
+}
+
+// ndLinkAddr returns the link-layer address carried in the first option of type opt,
+// or fallback (the Ethernet source) if the message has none.
+func ndLinkAddr(options layers.ICMPv6Options, opt layers.ICMPv6Opt, fallback net.HardwareAddr) net.HardwareAddr {
+    for _, o := range options {
+        if o.Type == opt && len(o.Data) >= 6 {
+            return net.HardwareAddr(o.Data[:6])
+        }
+    }
+    return fallback
+}
+
+// routerFromRA decodes the router parameters, MTU and prefix information options of a Router Advertisement.
+func routerFromRA(ra *layers.ICMPv6RouterAdvertisement) topology.Router {
+    r := topology.Router{
+        Lifetime:    int(ra.RouterLifetime),
+        HopLimit:    int(ra.HopLimit),
+        Managed:     ra.ManagedAddressConfig(),
+        OtherConfig: ra.OtherConfig(),
+    }
+    for _, o := range ra.Options {
+        switch o.Type {
+        case layers.ICMPv6OptMTU:
+            // [reserved x2][MTU x4]
+            if len(o.Data) >= 6 {
+                r.MTU = int(binary.BigEndian.Uint32(o.Data[2:6]))
+            }
+        case layers.ICMPv6OptPrefixInfo:
+            // [prefix length][L|A|reserved][valid lifetime x4][preferred lifetime x4][reserved x4][prefix x16]
+            if len(o.Data) >= 30 && o.Data[0] <= 128 {
+                prefix := net.IPNet{IP: net.IP(o.Data[14:30]), Mask: net.CIDRMask(int(o.Data[0]), 128)}
+                prefix.IP = prefix.IP.Mask(prefix.Mask)
+                r.Prefixes = append(r.Prefixes, topology.Prefix{
+                    Prefix:            prefix.String(),
+                    OnLink:            o.Data[1]&0x80 != 0,
+                    Autonomous:        o.Data[1]&0x40 != 0,
+                    ValidLifetime:     binary.BigEndian.Uint32(o.Data[2:6]),
+                    PreferredLifetime: binary.BigEndian.Uint32(o.Data[6:10]),
+                })
+            }
+        }
+    }
+    return r
+}
+
+// newEdgeKey derives the dedup key of a link from its two ends.
+func newEdgeKey(local, remote topology.Node) edgeKey {
+    // CDP has no chassis ID, so its Device ID identifies the remote system.
+    remoteChassis := remote.ChassisID
+    if remoteChassis == "" {
+        remoteChassis = remote.Device
+    }
+    return edgeKey{
+        localDevice:     local.Device,
+        localInterface:  local.Interface,
+        remoteChassis:   remoteChassis,
+        remoteInterface: remote.Interface,
+    }
+}
+
+// edgeExpired reports whether the edge's advertised TTL ran out before now without a refresh.
+// A TTL of zero is an explicit "neighbor going away" notice, so such edges are always expired.
+func edgeExpired(e topology.Edge, now time.Time) bool {
+    expires := e.LastSeen.Add(time.Duration(e.Remote.TTL) * time.Second)
+    return e.Remote.TTL == 0 || now.After(expires)
+}
+
+// ---- HTTP API (daemon mode) ----
+
+// edgeExpiryInterval is how often daemon mode drops edges whose TTL has run out.
+const edgeExpiryInterval = 5 * time.Second
+
+// expireEdgesLoop periodically removes expired edges until the context is cancelled.
+func expireEdgesLoop(ctx context.Context, a *aggregator, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case now := <-ticker.C:
+            if n := a.expireEdges(now); n > 0 {
+                log.Printf("Expired %d edge(s) whose LLDP/CDP TTL ran out\n", n)
+            }
+        }
+    }
+}
+
+// serveAPI serves the live edge, neighbor and interface tables as JSON on ln
+// until the context is cancelled.
+func serveAPI(ctx context.Context, a *aggregator, ln net.Listener) {
+    srv := &http.Server{Handler: apiHandler(a), ReadHeaderTimeout: 10 * time.Second}
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        srv.Shutdown(shutdownCtx)
+    }()
+
+    log.Printf("Serving HTTP API on %s (/snapshot, /edges, /neighbors, /l3, /lags, /interfaces, /healthz, /metrics)\n", ln.Addr())
+    if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+        log.Printf("HTTP API on %s stopped: %v", ln.Addr(), err)
+    }
+}
+
+// apiHandler routes the HTTP API endpoints to a's tables.
+func apiHandler(a *aggregator) http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/edges", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, a.edgesSnapshot(time.Now()))
+    })
+    mux.HandleFunc("/neighbors", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, a.neighborsSnapshot())
+    })
+    mux.HandleFunc("/l3", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, currentL3Report(a))
+    })
+    mux.HandleFunc("/snapshot", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, currentSnapshot(a, a.edgesSnapshot(time.Now())))
+    })
+    mux.HandleFunc("/lags", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, currentLAGs(a))
+    })
+    mux.HandleFunc("/interfaces", func(w http.ResponseWriter, r *http.Request) {
+        writeJSON(w, http.StatusOK, a.interfacesSnapshot())
+    })
+    mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+        handleHealthz(a, w)
+    })
+    mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Type", metricsContentType)
+        writeMetrics(a, w, time.Now())
+    })
+    return mux
+}
+
+// handleHealthz reports 200 while at least one interface is capturing, 503 otherwise.
+func handleHealthz(a *aggregator, w http.ResponseWriter) {
+    capturing := 0
+    for _, st := range a.interfacesSnapshot() {
+        if st.State == ifaceStateCapturing {
+            capturing++
+        }
+    }
+    numEdges := len(a.rawEdges())
+
+    status, code := "ok", http.StatusOK
+    if capturing == 0 {
+        status, code = "no interface capturing", http.StatusServiceUnavailable
+    }
+    writeJSON(w, code, map[string]interface{}{
+        "status":               status,
+        "hostname":             localHostname,
+        "uptime_seconds":       int(time.Since(startTime).Seconds()),
+        "interfaces_capturing": capturing,
+        "edges":                numEdges,
+    })
+}
+
+// writeJSON writes v as an indented JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+    data, err := json.MarshalIndent(v, "", "  ")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(code)
+    w.Write(data)
+}
+
+// ---- Prometheus metrics ----
+
+// metricsContentType is the Prometheus text exposition format content type.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// writeMetrics writes the current neighbor and capture state in the Prometheus text format.
+// Edges are judged live or stale at now.
+func writeMetrics(a *aggregator, w io.Writer, now time.Time) {
+    edgeList := a.edgesSnapshot(now)
+    ifaces := a.interfacesSnapshot()
+
+    fmt.Fprintln(w, "# HELP netgraph_lldp_neighbor Discovered LLDP/CDP link: 1 while its advertised TTL has not expired, 0 once stale.")
+    fmt.Fprintln(w, "# TYPE netgraph_lldp_neighbor gauge")
+    for _, e := range edgeList {
+        value := 1
+        if e.Stale {
+            value = 0
+        }
+        fmt.Fprintf(w, "netgraph_lldp_neighbor{%s} %d\n", edgeMetricLabels(e), value)
+    }
+
+    fmt.Fprintln(w, "# HELP netgraph_lldp_neighbor_last_seen_timestamp_seconds Time the link was last advertised.")
+    fmt.Fprintln(w, "# TYPE netgraph_lldp_neighbor_last_seen_timestamp_seconds gauge")
+    for _, e := range edgeList {
+        fmt.Fprintf(w, "netgraph_lldp_neighbor_last_seen_timestamp_seconds{%s} %d\n", edgeMetricLabels(e), e.LastSeen.Unix())
+    }
+
+    // Per-interface live neighbor count, including zero, so "NIC lost its switch" is a simple == 0 alert.
+    live := make(map[string]int)
+    for _, e := range edgeList {
+        if !e.Stale && e.Local.Device == localHostname {
+            live[e.Local.Interface]++
+        }
+    }
+    fmt.Fprintln(w, "# HELP netgraph_interface_neighbors Number of live LLDP/CDP neighbors per captured interface.")
+    fmt.Fprintln(w, "# TYPE netgraph_interface_neighbors gauge")
+    for _, st := range ifaces {
+        fmt.Fprintf(w, "netgraph_interface_neighbors{interface=%s} %d\n", promQuote(st.Name), live[st.Name])
+    }
+
+    fmt.Fprintln(w, "# HELP netgraph_interface_capturing Whether netgraph is currently capturing on the interface.")
+    fmt.Fprintln(w, "# TYPE netgraph_interface_capturing gauge")
+    for _, st := range ifaces {
+        capturing := 0
+        if st.State == ifaceStateCapturing {
+            capturing = 1
+        }
+        fmt.Fprintf(w, "netgraph_interface_capturing{interface=%s} %d\n", promQuote(st.Name), capturing)
+    }
+
+    fmt.Fprintln(w, "# HELP netgraph_packets_total Packets captured per interface and EtherType (CDP is reported as its SNAP protocol ID 0x2000).")
+    fmt.Fprintln(w, "# TYPE netgraph_packets_total counter")
+    for _, st := range ifaces {
+        for _, et := range sortedKeys(st.PacketsByEtherType) {
+            fmt.Fprintf(w, "netgraph_packets_total{interface=%s,ethertype=%s} %d\n",
+                promQuote(st.Name), promQuote(et), st.PacketsByEtherType[et])
+        }
+    }
+
+    fmt.Fprintln(w, "# HELP netgraph_parse_failures_total Frames that could not be decoded, per interface and protocol.")
+    fmt.Fprintln(w, "# TYPE netgraph_parse_failures_total counter")
+    for _, st := range ifaces {
+        for _, proto := range sortedKeys(st.ParseFailures) {
+            fmt.Fprintf(w, "netgraph_parse_failures_total{interface=%s,protocol=%s} %d\n",
+                promQuote(st.Name), promQuote(proto), st.ParseFailures[proto])
+        }
+    }
+
+    pcapCounters := []struct {
+        name, help string
+        value      func(topology.InterfaceStatus) uint64
+    }{
+        {"netgraph_pcap_received_total", "Packets received by the pcap filter.", func(st topology.InterfaceStatus) uint64 { return uint64(st.PcapReceived) }},
+        {"netgraph_pcap_dropped_total", "Packets dropped by the kernel because the capture buffer was full.", func(st topology.InterfaceStatus) uint64 { return uint64(st.PcapDropped) }},
+        {"netgraph_pcap_if_dropped_total", "Packets dropped by the network interface or its driver.", func(st topology.InterfaceStatus) uint64 { return uint64(st.PcapIfDropped) }},
+        {"netgraph_frames_decoded_total", "Frames that added a neighbor, edge, binding or LACP state.", func(st topology.InterfaceStatus) uint64 { return st.Decoded }},
+        {"netgraph_frames_dropped_total", "Frames netgraph dropped because its event queue stayed full.", func(st topology.InterfaceStatus) uint64 { return st.Dropped }},
+        {"netgraph_capture_errors_total", "Frames that could not be decoded plus capture failures.", func(st topology.InterfaceStatus) uint64 { return st.Errors }},
+    }
+    for _, c := range pcapCounters {
+        fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+        fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+        for _, st := range ifaces {
+            fmt.Fprintf(w, "%s{interface=%s} %d\n", c.name, promQuote(st.Name), c.value(st))
+        }
+    }
+}
+
+// edgeMetricLabels returns the label set identifying one link. It covers the link's edgeKey,
+// so no two edges export the same series.
+func edgeMetricLabels(e topology.Edge) string {
+    key := newEdgeKey(e.Local, e.Remote)
+    return fmt.Sprintf("interface=%s,remote_device=%s,remote_chassis=%s,remote_port=%s,protocol=%s",
+        promQuote(e.Local.Interface), promQuote(e.Remote.Device), promQuote(key.remoteChassis), promQuote(e.Remote.Interface), promQuote(e.Protocol))
+}
+
+// promQuote quotes a label value, escaping backslash, double quote and newline.
+func promQuote(v string) string {
+    return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v) + `"`
+}
+
+// sortedKeys returns the keys of m in sorted order, for stable metric output.
+func sortedKeys(m map[string]uint64) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// pushTimeout bounds one push, so an unreachable Pushgateway can't hang netgraph at exit.
+const pushTimeout = 10 * time.Second
+
+// pushMetrics replaces this host's metric group on a Prometheus Pushgateway
+// (PUT <gateway>/metrics/job/netgraph/instance/<instance>).
+func pushMetrics(a *aggregator, client *http.Client, gatewayURL, instance string) error {
+    var body bytes.Buffer
+    writeMetrics(a, &body, time.Now())
+
+    target := strings.TrimRight(gatewayURL, "/") + "/metrics/job/netgraph/instance/" + url.PathEscape(instance)
+    req, err := http.NewRequest(http.MethodPut, target, &body)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", metricsContentType)
+    resp, err := client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode/100 != 2 {
+        msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+        return fmt.Errorf("pushgateway returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+    }
+    return nil
+}
+
+// pushMetricsLoop pushes metrics every interval until the context is cancelled.
+// The final push at exit is done by main once all captures have stopped.
+func pushMetricsLoop(ctx context.Context, a *aggregator, gatewayURL string, interval time.Duration) {
+    client := &http.Client{Timeout: pushTimeout}
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := pushMetrics(a, client, gatewayURL, localHostname); err != nil {
+                log.Printf("Error pushing metrics to %s: %v\n", gatewayURL, err)
+            }
+        }
+    }
+}
+
+// ---- DCBX report ----
+
+// dcbxPort is the lossless-priority view of one LLDP link.
+type dcbxPort struct {
+    Local        topology.Node
+    Remote       topology.Node
+    Lossless     []int // PFC-enabled priorities; nil if the port sent no PFC TLV
+    TC           int   // traffic class of the expected priority, -1 if unknown
+    Bandwidth    int   // ETS bandwidth % of that class, -1 if unknown
+    RoCEPriority int   // priority RoCEv2 (UDP 4791) is mapped to, -1 if not advertised
+    Problems     []string
+}
+
+// dcbxReport checks every LLDP link against the expected lossless priority.
+// With expected < 0 the priority is inferred as the one most ports have PFC enabled on,
+// and only ports advertising DCBX are checked; otherwise ports without PFC are reported too.
+// It returns the priority checked against (-1 if there was nothing to check) and the per-port results.
+func dcbxReport(edges []topology.Edge, expected int) (int, []dcbxPort) {
+    explicit := expected >= 0
+    if !explicit {
+        counts := make(map[int]int)
+        for _, e := range edges {
+            if d := e.Remote.DCBX; d != nil && d.PFC != nil {
+                for _, p := range d.PFC.Enabled {
+                    counts[p]++
+                }
+            }
+        }
+        for p := 0; p < 8; p++ {
+            if counts[p] > 0 && (expected < 0 || counts[p] > counts[expected]) {
+                expected = p
+            }
+        }
+        if expected < 0 {
+            return -1, nil
+        }
+    }
+
+    var ports []dcbxPort
+    for _, e := range edges {
+        d := e.Remote.DCBX
+        if e.Protocol != protocolLLDP || (!explicit && d == nil) {
+            continue
+        }
+        port := dcbxPort{Local: e.Local, Remote: e.Remote, TC: -1, Bandwidth: -1, RoCEPriority: -1}
+        if d == nil || d.PFC == nil {
+            port.Problems = append(port.Problems, "no PFC advertised")
+        } else {
+            port.Lossless = d.PFC.Enabled
+            found := false
+            var extra []int
+            for _, p := range d.PFC.Enabled {
+                if p == expected {
+                    found = true
+                } else {
+                    extra = append(extra, p)
+                }
+            }
+            if !found {
+                port.Problems = append(port.Problems, fmt.Sprintf("priority %d is not lossless", expected))
+            }
+            if len(extra) > 0 {
+                port.Problems = append(port.Problems, fmt.Sprintf("unexpected lossless priorities %v", extra))
+            }
+        }
+        if d != nil {
+            if port.TC = d.ETS.TrafficClass(expected); port.TC >= 0 && port.TC < len(d.ETS.Bandwidth) {
+                port.Bandwidth = d.ETS.Bandwidth[port.TC]
+                if port.Bandwidth == 0 && (len(d.ETS.TSA) <= port.TC || d.ETS.TSA[port.TC] == "ets") {
+                    port.Problems = append(port.Problems, fmt.Sprintf("traffic class %d has 0%% ETS bandwidth", port.TC))
+                }
+            }
+            port.RoCEPriority = d.ApplicationPriority(lldp.RoCEv2UDPPort)
+            if port.RoCEPriority >= 0 && port.RoCEPriority != expected {
+                port.Problems = append(port.Problems, fmt.Sprintf("RoCEv2 is mapped to priority %d", port.RoCEPriority))
+            }
+        }
+        ports = append(ports, port)
+    }
+    return expected, ports
+}
+
+// printDCBXReport prints the lossless-priority report, if any port was checked.
+func printDCBXReport(w io.Writer, expected int, ports []dcbxPort, inferred bool) {
+    if len(ports) == 0 {
+        return
+    }
+    how := "expected"
+    if inferred {
+        how = "inferred"
+    }
+    fmt.Fprintf(w, "DCBX lossless priority report (%s priority %d):\n", how, expected)
+    ok := 0
+    for _, p := range ports {
+        status := "OK"
+        if len(p.Problems) > 0 {
+            status = "MISMATCH: " + strings.Join(p.Problems, "; ")
+        } else {
+            ok++
+        }
+        pfc := "none"
+        if p.Lossless != nil {
+            pfc = fmt.Sprint(p.Lossless)
+        }
+        fmt.Fprintf(w, "  (%s, %s) -> (%s, %s) pfc=%s tc=%s bw=%s roce=%s: %s\n",
+            p.Local.Device, p.Local.Interface, p.Remote.Device, p.Remote.Interface,
+            pfc, optInt(p.TC, ""), optInt(p.Bandwidth, "%"), optInt(p.RoCEPriority, ""), status)
+    }
+    fmt.Fprintf(w, "  %d of %d ports OK\n\n", ok, len(ports))
+}
+
+// optInt formats v with suffix, or "-" for the -1 "unknown" value.
+func optInt(v int, suffix string) string {
+    if v < 0 {
+        return "-"
+    }
+    return fmt.Sprintf("%d%s", v, suffix)
+}
+
+// ---- Link consistency checks ----
+
+// ethernetOverhead is the Ethernet header plus FCS that the max frame size counts on top of the MTU;
+// a VLAN tag adds vlanTagLen more.
+const (
+    ethernetOverhead = 18
+    vlanTagLen       = 4
+)
+
+// readLinkSettings fills in the local MTU, speed, duplex and VLAN subinterfaces of node.Interface
+// from /sys/class/net/<if>/{mtu,speed,duplex} and /proc/net/vlan/config.
+// Values the kernel doesn't know (e.g. speed on a down link) are left unset.
+func readLinkSettings(node *topology.Node) {
+    dir := filepath.Join(sysfsRoot, "class", "net", node.Interface)
+    if v, err := readSysfsInt(filepath.Join(dir, "mtu")); err == nil && v > 0 {
+        node.MTU = v
+    }
+    if v, err := readSysfsInt(filepath.Join(dir, "speed")); err == nil && v > 0 {
+        node.SpeedMbps = v
+    }
+    if data, err := os.ReadFile(filepath.Join(dir, "duplex")); err == nil {
+        if d := strings.TrimSpace(string(data)); d == "full" || d == "half" {
+            node.Duplex = d
+        }
+    }
+    node.VLANs = localVLANs(node.Interface)
+}
+
+// readNICInventory collects the driver, firmware, PCI address, NUMA node, operstate, carrier
+// changes and RDMA device of ifName from /sys/class/net/<if> and /sys/class/infiniband.
+// It returns nil if the interface doesn't exist.
+func readNICInventory(ifName string) *topology.NIC {
+    dir := filepath.Join(sysfsRoot, "class", "net", ifName)
+    if _, err := os.Stat(dir); err != nil {
+        return nil
+    }
+    nic := &topology.NIC{NUMANode: -1}
+    if data, err := os.ReadFile(filepath.Join(dir, "operstate")); err == nil {
+        nic.OperState = strings.TrimSpace(string(data))
+    }
+    if v, err := readSysfsInt(filepath.Join(dir, "carrier_changes")); err == nil {
+        nic.CarrierChanges = v
+    }
+
+    // Physical NICs link to their bus device; /sys/class/net/<if>/device -> ../../../0000:79:00.0
+    if target, err := filepath.EvalSymlinks(filepath.Join(dir, "device")); err == nil {
+        if isPCIAddress(filepath.Base(target)) {
+            nic.PCIAddress = filepath.Base(target)
+        }
+        if driver, err := os.Readlink(filepath.Join(target, "driver")); err == nil {
+            nic.Driver = filepath.Base(driver)
+        }
+        if v, err := readSysfsInt(filepath.Join(target, "numa_node")); err == nil {
+            nic.NUMANode = v
+        }
+    }
+
+    nic.RDMADevice = rdmaNetdevs()[ifName]
+    if nic.RDMADevice != "" {
+        if data, err := os.ReadFile(filepath.Join(sysfsRoot, "class", "infiniband", nic.RDMADevice, "fw_ver")); err == nil {
+            nic.Firmware = strings.TrimSpace(string(data))
+        }
+    }
+    if nic.Firmware == "" && nic.Driver != "" {
+        nic.Firmware = ethtoolFirmware(ifName)
+    }
+    return nic
+}
+
+// pciAddressRE matches a PCI domain:bus:device.function address.
+var pciAddressRE = regexp.MustCompile(`^[0-9a-f]{4}:[0-9a-f]{2}:[0-9a-f]{2}\.[0-7]$`)
+
+func isPCIAddress(s string) bool {
+    return pciAddressRE.MatchString(s)
+}
+
+// readSysfsInt reads a sysfs attribute holding a single integer.
+func readSysfsInt(path string) (int, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return 0, err
+    }
+    return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// localVLANs returns the IDs of the VLAN devices stacked on ifName, from /proc/net/vlan/config:
+//
+//	VLAN Dev name    | VLAN ID
+//	Name-Type: VLAN_NAME_TYPE_RAW_PLUS_VID_NO_PAD
+//	ens1.200       | 200  | ens1
+func localVLANs(ifName string) []int {
+    data, err := os.ReadFile(filepath.Join(procRoot, "net", "vlan", "config"))
+    if err != nil {
+        return nil
+    }
+    var vlans []int
+    for _, line := range strings.Split(string(data), "\n") {
+        cols := strings.Split(line, "|")
+        if len(cols) != 3 || strings.TrimSpace(cols[2]) != ifName {
+            continue
+        }
+        if id, err := strconv.Atoi(strings.TrimSpace(cols[1])); err == nil {
+            vlans = append(vlans, id)
+        }
+    }
+    sort.Ints(vlans)
+    return vlans
+}
+
+// checkLink compares the local interface's settings with what the remote port advertised
+// and returns one finding per disagreement. Checks that lack a value on either end are skipped.
+func checkLink(local, remote topology.Node) []topology.Finding {
+    var findings []topology.Finding
+    add := func(check, severity, localValue, remoteValue, format string, args ...interface{}) {
+        findings = append(findings, topology.Finding{
+            Check:    check,
+            Severity: severity,
+            Message:  fmt.Sprintf(format, args...),
+            Local:    localValue,
+            Remote:   remoteValue,
+        })
+    }
+
+    if local.MTU > 0 && remote.MaxFrameSize > 0 {
+        need := local.MTU + ethernetOverhead
+        if len(local.VLANs) > 0 {
+            need += vlanTagLen
+        }
+        maxFrame := int(remote.MaxFrameSize)
+        localMTU, remoteMax := strconv.Itoa(local.MTU), strconv.Itoa(maxFrame)
+        if maxFrame < need {
+            add("mtu", topology.SeverityError, localMTU, remoteMax,
+                "local MTU %d needs %d-byte frames but the remote port's max frame size is %d", local.MTU, need, maxFrame)
+        } else if local.MTU <= 1500 && maxFrame > 1500+ethernetOverhead+vlanTagLen {
+            add("mtu", topology.SeverityWarning, localMTU, remoteMax,
+                "remote port takes jumbo frames (max frame size %d) but local MTU is %d", maxFrame, local.MTU)
+        }
+    }
+
+    remoteSpeed, remoteDuplex := 0, remote.Duplex
+    if remote.MACPHY != nil {
+        remoteSpeed = remote.MACPHY.SpeedMbps
+        if remote.MACPHY.Duplex != "" {
+            remoteDuplex = remote.MACPHY.Duplex
+        }
+    }
+    if local.SpeedMbps > 0 && remoteSpeed > 0 && local.SpeedMbps != remoteSpeed {
+        add("speed", topology.SeverityError, strconv.Itoa(local.SpeedMbps), strconv.Itoa(remoteSpeed),
+            "local speed %d Mb/s, remote port operating at %d Mb/s", local.SpeedMbps, remoteSpeed)
+    }
+    if local.Duplex != "" && remoteDuplex != "" && local.Duplex != remoteDuplex {
+        add("duplex", topology.SeverityError, local.Duplex, remoteDuplex,
+            "local %s duplex, remote port %s duplex", local.Duplex, remoteDuplex)
+    }
+
+    // The remote's untagged VLAN (PVID, or CDP native VLAN) carries our untagged traffic, so tagging
+    // it locally as well is almost always a mistake; any other local VLAN has to be allowed on the port.
+    pvid := int(remote.PortVLANID)
+    if pvid == 0 {
+        pvid = int(remote.NativeVLAN)
+    }
+    carried := make(map[int]bool)
+    for _, v := range remote.VLANNames {
+        carried[int(v.ID)] = true
+    }
+    for _, v := range local.VLANs {
+        if v == pvid {
+            add("vlan", topology.SeverityWarning, strconv.Itoa(v), strconv.Itoa(pvid),
+                "local VLAN %d is tagged but is the remote port's untagged VLAN", v)
+        } else if len(carried) > 0 && !carried[v] {
+            add("vlan", topology.SeverityError, strconv.Itoa(v), "",
+                "local VLAN %d is not among the VLANs the remote port advertises", v)
+        }
+    }
+    return findings
+}
+
+// printLinkFindings prints a summary of the consistency findings on all edges.
+func printLinkFindings(w io.Writer, edges []topology.Edge) {
+    links, errs, warnings := 0, 0, 0
+    for _, e := range edges {
+        if len(e.Findings) > 0 {
+            links++
+        }
+        for _, f := range e.Findings {
+            if f.Severity == topology.SeverityError {
+                errs++
+            } else {
+                warnings++
+            }
+        }
+    }
+    if links == 0 {
+        fmt.Fprintf(w, "Link consistency: no mismatches on %d links\n\n", len(edges))
+        return
+    }
+    fmt.Fprintf(w, "Link consistency: %d errors, %d warnings on %d of %d links:\n", errs, warnings, links, len(edges))
+    for _, e := range edges {
+        for _, f := range e.Findings {
+            fmt.Fprintf(w, "  (%s, %s) -> (%s, %s) %s %s: %s\n",
+                e.Local.Device, e.Local.Interface, e.Remote.Device, e.Remote.Interface,
+                strings.ToUpper(f.Severity), f.Check, f.Message)
+        }
+    }
+    fmt.Fprintln(w)
+}
+
+// ---- GPU affinity ----
+
+// amdVendorID is AMD/ATI's PCI vendor ID as it appears in /sys/bus/pci/devices/<bdf>/vendor.
+const amdVendorID = "0x1002"
+
+// pciDevice is one entry of /sys/bus/pci/devices.
+type pciDevice struct {
+    Address  string
+    Path     []string // components of the resolved sysfs path, e.g. devices, pci0000:00, 0000:00:01.1, ...
+    Vendor   string
+    Class    string
+    NUMANode int
+    Netdevs  []string
+}
+
+// scanPCIDevices reads every device under /sys/bus/pci/devices, sorted by PCI address.
+func scanPCIDevices() []pciDevice {
+    dir := filepath.Join(sysfsRoot, "bus", "pci", "devices")
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil
+    }
+    var devs []pciDevice
+    for _, e := range entries {
+        path, err := filepath.EvalSymlinks(filepath.Join(dir, e.Name()))
+        if err != nil {
+            continue
+        }
+        rel, err := filepath.Rel(sysfsRoot, path)
+        if err != nil {
+            continue
+        }
+        d := pciDevice{Address: e.Name(), Path: strings.Split(rel, string(filepath.Separator)), NUMANode: -1}
+        if data, err := os.ReadFile(filepath.Join(path, "vendor")); err == nil {
+            d.Vendor = strings.TrimSpace(string(data))
+        }
+        if data, err := os.ReadFile(filepath.Join(path, "class")); err == nil {
+            d.Class = strings.TrimSpace(string(data))
+        }
+        if v, err := readSysfsInt(filepath.Join(path, "numa_node")); err == nil {
+            d.NUMANode = v
+        }
+        if nets, err := os.ReadDir(filepath.Join(path, "net")); err == nil {
+            for _, n := range nets {
+                d.Netdevs = append(d.Netdevs, n.Name())
+            }
+        }
+        devs = append(devs, d)
+    }
+    sort.Slice(devs, func(i, j int) bool { return devs[i].Address < devs[j].Address })
+    return devs
+}
+
+// isAMDGPU reports whether d is an AMD display controller (class 0x03xxxx) or
+// processing accelerator (class 0x12xxxx, e.g. MI300X).
+func isAMDGPU(d pciDevice) bool {
+    return d.Vendor == amdVendorID && (strings.HasPrefix(d.Class, "0x03") || strings.HasPrefix(d.Class, "0x12"))
+}
+
+// sharedBridges counts the PCIe bridges above both a and b, not counting the root bus itself.
+// Devices behind the same PCIe switch share at least its upstream and root ports.
+func sharedBridges(a, b pciDevice) int {
+    n := 0
+    // Path ends with the device itself, so only compare the ancestors.
+    for n < len(a.Path)-1 && n < len(b.Path)-1 && a.Path[n] == b.Path[n] {
+        n++
+    }
+    // Skip "devices" and the "pci0000:00" root bus.
+    if n < 2 {
+        return 0
+    }
+    return n - 2
+}
+
+// gpuAffinity pairs every netdev backed by a PCI NIC with its closest AMD GPU:
+// the GPU sharing the most upstream bridges with it or, if none shares a bridge,
+// the only GPU on its NUMA node that no NIC is PCIe-close to.
+func gpuAffinity() map[string]*topology.GPU {
+    devs := scanPCIDevices()
+    var gpus, nics []pciDevice
+    for _, d := range devs {
+        if isAMDGPU(d) {
+            gpus = append(gpus, d)
+        } else if len(d.Netdevs) > 0 {
+            nics = append(nics, d)
+        }
+    }
+
+    affinity := make(map[string]*topology.GPU)
+    claimed := make(map[int]bool)
+    var unmatched []pciDevice
+    for _, nic := range nics {
+        best, bestShared := -1, 0
+        for i, gpu := range gpus {
+            if s := sharedBridges(nic, gpu); s > bestShared {
+                best, bestShared = i, s
+            }
+        }
+        if best < 0 {
+            unmatched = append(unmatched, nic)
+            continue
+        }
+        claimed[best] = true
+        for _, n := range nic.Netdevs {
+            affinity[n] = &topology.GPU{Index: best, PCIAddress: gpus[best].Address, Via: topology.GPUViaPCIe}
+        }
+    }
+
+    for _, nic := range unmatched {
+        if nic.NUMANode < 0 {
+            continue
+        }
+        match := -1
+        for i, gpu := range gpus {
+            if claimed[i] || gpu.NUMANode != nic.NUMANode {
+                continue
+            }
+            if match >= 0 {
+                match = -1 // more than one candidate: ambiguous
+                break
+            }
+            match = i
+        }
+        if match < 0 {
+            continue
+        }
+        for _, n := range nic.Netdevs {
+            affinity[n] = &topology.GPU{Index: match, PCIAddress: gpus[match].Address, Via: topology.GPUViaNUMA}
+        }
+    }
+    return affinity
+}
+
+// printRailMap prints which switch port each local GPU's NIC is cabled to.
+func printRailMap(w io.Writer, edges []topology.Edge) {
+    var rails []topology.Edge
+    for _, e := range edges {
+        if e.Local.GPU != nil {
+            rails = append(rails, e)
+        }
+    }
+    if len(rails) == 0 {
+        return
+    }
+    sort.SliceStable(rails, func(i, j int) bool { return rails[i].Local.GPU.Index < rails[j].Local.GPU.Index })
+    fmt.Fprintln(w, "GPU rails:")
+    for _, e := range rails {
+        fmt.Fprintf(w, "  GPU %d (%s) on %s -> %s -> (%s, %s) [via %s]\n",
+            e.Local.GPU.Index, e.Local.GPU.PCIAddress, e.Local.Device, e.Local.Interface,
+            e.Remote.Device, e.Remote.Interface, e.Local.GPU.Via)
+    }
+    fmt.Fprintln(w)
 }
@@ -0,0 +1,824 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/lldp"
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+    "github.com/gopacket/gopacket"
+    "github.com/gopacket/gopacket/layers"
+)
+
+// fakeSysfs builds a sysfs tree under a temp dir and points sysfsRoot and procRoot at it.
+// files maps paths relative to the root to their contents; links maps paths to symlink targets.
+func fakeSysfs(t *testing.T, files, links map[string]string) string {
+    t.Helper()
+    root := t.TempDir()
+    for name, content := range files {
+        path := filepath.Join(root, name)
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+    for name, target := range links {
+        path := filepath.Join(root, name)
+        if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+            t.Fatal(err)
+        }
+        if err := os.Symlink(target, path); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    oldSysfs, oldProc := sysfsRoot, procRoot
+    sysfsRoot, procRoot = root, filepath.Join(root, "proc")
+    resetLocalNodes()
+    t.Cleanup(func() {
+        sysfsRoot, procRoot = oldSysfs, oldProc
+        resetLocalNodes()
+    })
+    return root
+}
+
+// resetLocalNodes empties the local node cache, which holds what was read from another sysfs tree.
+func resetLocalNodes() {
+    localNodesMu.Lock()
+    localNodes = make(map[string]cachedNode)
+    localNodesMu.Unlock()
+}
+
+// stubEthtool replaces the ethtool firmware query for the duration of a test.
+func stubEthtool(t *testing.T, firmware map[string]string) {
+    old := ethtoolFirmware
+    ethtoolFirmware = func(ifName string) string { return firmware[ifName] }
+    t.Cleanup(func() { ethtoolFirmware = old })
+}
+
+// mi300Sysfs is a node with one RDMA-capable Pensando NIC, one plain Broadcom NIC and a bridge.
+func mi300Sysfs(t *testing.T) {
+    const ionicDev = "devices/pci0000:70/0000:70:01.1/0000:79:00.0"
+    const bnxtDev = "devices/pci0000:00/0000:00:01.1/0000:02:00.0"
+    fakeSysfs(t, map[string]string{
+        ionicDev + "/numa_node":                    "1\n",
+        ionicDev + "/net/enp121s0/operstate":       "up\n",
+        ionicDev + "/net/enp121s0/carrier_changes": "4\n",
+        ionicDev + "/net/enp121s0/mtu":             "9000\n",
+        ionicDev + "/net/enp121s0/speed":           "400000\n",
+        ionicDev + "/net/enp121s0/duplex":          "full\n",
+        ionicDev + "/infiniband/ionic_0/fw_ver":    "1.101.0-C-8\n",
+        bnxtDev + "/numa_node":                     "-1\n",
+        bnxtDev + "/net/eno1/operstate":            "up\n",
+        bnxtDev + "/net/eno1/carrier_changes":      "2\n",
+        bnxtDev + "/net/eno1/mtu":                  "1500\n",
+        bnxtDev + "/net/eno1/speed":                "-1\n",
+        bnxtDev + "/net/eno1/duplex":               "unknown\n",
+        "devices/virtual/net/br0/operstate":        "unknown\n",
+        "devices/virtual/net/br0/carrier_changes":  "0\n",
+        "devices/virtual/net/br0/mtu":              "1500\n",
+        "proc/net/vlan/config": "VLAN Dev name    | VLAN ID\n" +
+            "Name-Type: VLAN_NAME_TYPE_RAW_PLUS_VID_NO_PAD\n" +
+            "enp121s0.300   | 300  | enp121s0\n" +
+            "enp121s0.200   | 200  | enp121s0\n" +
+            "eno1.10        | 10  | eno1\n",
+    }, map[string]string{
+        "class/net/enp121s0":                    "../../" + ionicDev + "/net/enp121s0",
+        "class/net/eno1":                        "../../" + bnxtDev + "/net/eno1",
+        "class/net/br0":                         "../../devices/virtual/net/br0",
+        ionicDev + "/net/enp121s0/device":       "../../../0000:79:00.0",
+        bnxtDev + "/net/eno1/device":            "../../../0000:02:00.0",
+        ionicDev + "/driver":                    "../../../../bus/pci/drivers/ionic",
+        bnxtDev + "/driver":                     "../../../../bus/pci/drivers/bnxt_en",
+        "class/infiniband/ionic_0":              "../../" + ionicDev + "/infiniband/ionic_0",
+        ionicDev + "/infiniband/ionic_0/device": "../../../0000:79:00.0",
+    })
+}
+
+func TestReadNICInventory(t *testing.T) {
+    mi300Sysfs(t)
+    stubEthtool(t, map[string]string{"enp121s0": "from-ethtool", "eno1": "231.0.153.0"})
+
+    tests := []struct {
+        ifName string
+        want   *topology.NIC
+    }{
+        {"enp121s0", &topology.NIC{
+            Driver:         "ionic",
+            Firmware:       "1.101.0-C-8", // the RDMA device's fw_ver wins over ethtool
+            PCIAddress:     "0000:79:00.0",
+            NUMANode:       1,
+            OperState:      "up",
+            CarrierChanges: 4,
+            RDMADevice:     "ionic_0",
+        }},
+        {"eno1", &topology.NIC{
+            Driver:         "bnxt_en",
+            Firmware:       "231.0.153.0",
+            PCIAddress:     "0000:02:00.0",
+            NUMANode:       -1,
+            OperState:      "up",
+            CarrierChanges: 2,
+        }},
+        {"br0", &topology.NIC{NUMANode: -1, OperState: "unknown"}},
+        {"missing0", nil},
+    }
+    for _, tt := range tests {
+        if got := readNICInventory(tt.ifName); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("readNICInventory(%q) = %+v, want %+v", tt.ifName, got, tt.want)
+        }
+    }
+}
+
+func TestRDMANetdevs(t *testing.T) {
+    mi300Sysfs(t)
+    if got, want := rdmaNetdevs(), map[string]string{"enp121s0": "ionic_0"}; !reflect.DeepEqual(got, want) {
+        t.Errorf("rdmaNetdevs() = %v, want %v", got, want)
+    }
+}
+
+func TestReadLinkSettings(t *testing.T) {
+    mi300Sysfs(t)
+
+    node := topology.Node{Interface: "enp121s0"}
+    readLinkSettings(&node)
+    if node.MTU != 9000 || node.SpeedMbps != 400000 || node.Duplex != "full" || !reflect.DeepEqual(node.VLANs, []int{200, 300}) {
+        t.Errorf("readLinkSettings(enp121s0) = %+v", node)
+    }
+
+    // Unknown speed and duplex (link down) are left unset.
+    node = topology.Node{Interface: "eno1"}
+    readLinkSettings(&node)
+    if node.MTU != 1500 || node.SpeedMbps != 0 || node.Duplex != "" || !reflect.DeepEqual(node.VLANs, []int{10}) {
+        t.Errorf("readLinkSettings(eno1) = %+v", node)
+    }
+}
+
+func TestLocalNodeCache(t *testing.T) {
+    root := fakeSysfs(t, map[string]string{
+        "class/net/ens1/mtu":   "9000\n",
+        "class/net/ens1/speed": "400000\n",
+    }, nil)
+    setMTU := func(mtu string) {
+        t.Helper()
+        if err := os.WriteFile(filepath.Join(root, "class/net/ens1/mtu"), []byte(mtu+"\n"), 0644); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if n := newLocalNode("ens1"); n.MTU != 9000 || n.SpeedMbps != 400000 || n.Interface != "ens1" || n.Device != localHostname {
+        t.Fatalf("newLocalNode(ens1) = %+v", n)
+    }
+    setMTU("1500")
+    if n := newLocalNode("ens1"); n.MTU != 9000 {
+        t.Errorf("MTU = %d, want the cached 9000", n.MTU)
+    }
+
+    // A link event drops the cached node.
+    forgetLocalNode("ens1")
+    if n := newLocalNode("ens1"); n.MTU != 1500 {
+        t.Errorf("MTU after a link event = %d, want 1500", n.MTU)
+    }
+
+    // So does age.
+    setMTU("4200")
+    localNodesMu.Lock()
+    c := localNodes["ens1"]
+    c.read = c.read.Add(-localNodeRefresh)
+    localNodes["ens1"] = c
+    localNodesMu.Unlock()
+    if n := newLocalNode("ens1"); n.MTU != 4200 {
+        t.Errorf("MTU after %v = %d, want 4200", localNodeRefresh, n.MTU)
+    }
+
+    // A replayed capture was taken elsewhere: nothing is read here.
+    old := offlineReplay
+    offlineReplay = true
+    t.Cleanup(func() { offlineReplay = old })
+    if n := newLocalNode("ens1"); !reflect.DeepEqual(n, topology.Node{Device: localHostname, Interface: "ens1"}) {
+        t.Errorf("newLocalNode(ens1) in a replay = %+v", n)
+    }
+}
+
+func TestLocalNodeNICInventory(t *testing.T) {
+    mi300Sysfs(t)
+    queries := 0
+    old := ethtoolFirmware
+    ethtoolFirmware = func(ifName string) string {
+        queries++
+        return "231.0.153.0"
+    }
+    t.Cleanup(func() { ethtoolFirmware = old })
+
+    // The inventory, firmware query included, is read once and reused for every frame.
+    for i := 0; i < 3; i++ {
+        if n := newLocalNode("eno1"); n.NIC == nil || n.NIC.Driver != "bnxt_en" || n.NIC.Firmware != "231.0.153.0" {
+            t.Fatalf("newLocalNode(eno1).NIC = %+v", n.NIC)
+        }
+    }
+    if queries != 1 {
+        t.Errorf("ethtool queried %d times, want once", queries)
+    }
+
+    n := newLocalNode("enp121s0")
+    if n.NIC == nil || n.NIC.RDMADevice != "ionic_0" {
+        t.Fatalf("newLocalNode(enp121s0).NIC = %+v", n.NIC)
+    }
+    // The RDMA device goes away (driver reload); only a link event makes us look again.
+    if err := os.Remove(filepath.Join(sysfsRoot, "class/infiniband/ionic_0")); err != nil {
+        t.Fatal(err)
+    }
+    if n := newLocalNode("enp121s0"); n.NIC.RDMADevice != "ionic_0" {
+        t.Errorf("RDMADevice = %q, want the cached ionic_0", n.NIC.RDMADevice)
+    }
+    forgetLocalNode("enp121s0")
+    if n := newLocalNode("enp121s0"); n.NIC.RDMADevice != "" {
+        t.Errorf("RDMADevice after a link event = %q, want none", n.NIC.RDMADevice)
+    }
+}
+
+func TestIsPhysicalInterface(t *testing.T) {
+    mi300Sysfs(t)
+    for name, want := range map[string]bool{"enp121s0": true, "eno1": true, "br0": false, "missing0": false} {
+        if got := isPhysicalInterface(name); got != want {
+            t.Errorf("isPhysicalInterface(%q) = %v, want %v", name, got, want)
+        }
+    }
+}
+
+func TestParseInterfacePatterns(t *testing.T) {
+    names := []string{"enp121s0", "enp122s0np0", "eno1", "br0", "lo"}
+    tests := []struct {
+        name string
+        list string
+        want []string // names accepted by any of the matchers
+    }{
+        {"empty", "", nil},
+        {"blank entries", " , ,", nil},
+        {"glob", "enp*", []string{"enp121s0", "enp122s0np0"}},
+        {"glob is anchored", "np0", nil},
+        {"glob class", "en[op]1*", []string{"enp121s0", "enp122s0np0", "eno1"}},
+        {"regexp", "re:np0$", []string{"enp122s0np0"}},
+        {"regexp is unanchored", "re:o", []string{"eno1", "lo"}},
+        {"mixed list", " br0 , re:^eno[0-9]+$ ", []string{"eno1", "br0"}},
+        {"re prefix only on regexps", "re*", nil},
+    }
+    for _, tt := range tests {
+        matchers, err := parseInterfacePatterns(tt.list)
+        if err != nil {
+            t.Errorf("%s: parseInterfacePatterns(%q): %v", tt.name, tt.list, err)
+            continue
+        }
+        var got []string
+        for _, n := range names {
+            if matchesAny(matchers, n) {
+                got = append(got, n)
+            }
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: %q matches %v, want %v", tt.name, tt.list, got, tt.want)
+        }
+    }
+
+    for _, list := range []string{"enp[", "eno1,re:(", "re:[a-"} {
+        if _, err := parseInterfacePatterns(list); err == nil {
+            t.Errorf("parseInterfacePatterns(%q) succeeded, want error", list)
+        }
+    }
+}
+
+func TestInterfaceSelectionFilter(t *testing.T) {
+    mi300Sysfs(t)
+    patterns := func(list string) []interfaceMatcher {
+        t.Helper()
+        m, err := parseInterfacePatterns(list)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return m
+    }
+    devices := []string{"enp121s0", "eno1", "br0", "lo"}
+    tests := []struct {
+        name string
+        sel  interfaceSelection
+        want []string
+    }{
+        {"everything", interfaceSelection{}, devices},
+        {"include", interfaceSelection{Include: patterns("en*")}, []string{"enp121s0", "eno1"}},
+        {"exclude", interfaceSelection{Exclude: patterns("lo,re:^br")}, []string{"enp121s0", "eno1"}},
+        {"exclude wins over include", interfaceSelection{Include: patterns("en*,lo"), Exclude: patterns("eno*")}, []string{"enp121s0", "lo"}},
+        {"physical", interfaceSelection{PhysicalOnly: true}, []string{"enp121s0", "eno1"}},
+        {"rdma", interfaceSelection{RDMAOnly: true}, []string{"enp121s0"}},
+        {"physical and include", interfaceSelection{Include: patterns("eno*,br*"), PhysicalOnly: true}, []string{"eno1"}},
+        {"rdma excluded", interfaceSelection{Exclude: patterns("enp*"), RDMAOnly: true}, nil},
+    }
+    for _, tt := range tests {
+        if got := tt.sel.filter(devices); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: filter() = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+
+    sel := interfaceSelection{Include: patterns("br0"), Exclude: patterns("br*"), PhysicalOnly: true, RDMAOnly: true}
+    for _, tt := range []struct{ name, reason string }{
+        {"eno1", "not in -include"},
+        {"br0", "matches -exclude"},
+    } {
+        if got := sel.skipReason(tt.name, rdmaNetdevs()); got != tt.reason {
+            t.Errorf("skipReason(%q) = %q, want %q", tt.name, got, tt.reason)
+        }
+    }
+    sel = interfaceSelection{PhysicalOnly: true, RDMAOnly: true}
+    for _, tt := range []struct{ name, reason string }{
+        {"br0", "not a physical NIC"},
+        {"eno1", "no RDMA device"},
+        {"enp121s0", ""},
+    } {
+        if got := sel.skipReason(tt.name, rdmaNetdevs()); got != tt.reason {
+            t.Errorf("skipReason(%q) = %q, want %q", tt.name, got, tt.reason)
+        }
+    }
+}
+
+// pciFixture lays out PCI devices at the given resolved paths (relative to devices/) with
+// /sys/bus/pci/devices symlinks, vendor, class and numa_node files, and optional netdevs.
+type pciFixture struct {
+    path    string
+    vendor  string
+    class   string
+    numa    string
+    netdevs []string
+}
+
+func fakePCI(t *testing.T, devs []pciFixture) {
+    files := make(map[string]string)
+    links := make(map[string]string)
+    for _, d := range devs {
+        dir := "devices/" + d.path
+        files[dir+"/vendor"] = d.vendor + "\n"
+        files[dir+"/class"] = d.class + "\n"
+        files[dir+"/numa_node"] = d.numa + "\n"
+        for _, n := range d.netdevs {
+            files[dir+"/net/"+n+"/operstate"] = "up\n"
+        }
+        links["bus/pci/devices/"+filepath.Base(d.path)] = "../../../" + dir
+    }
+    fakeSysfs(t, files, links)
+}
+
+func TestGPUAffinity(t *testing.T) {
+    fakePCI(t, []pciFixture{
+        // GPU 0 and ens1 behind the same PCIe switch (upstream 01:00.0).
+        {"pci0000:00/0000:00:01.1/0000:01:00.0/0000:02:00.0/0000:03:00.0", "0x1002", "0x120000", "0", nil},
+        {"pci0000:00/0000:00:01.1/0000:01:00.0/0000:02:00.0/0000:03:00.1", "0x1002", "0x040300", "0", nil}, // HDMI audio, not a GPU
+        {"pci0000:00/0000:00:01.1/0000:01:00.0/0000:02:01.0/0000:04:00.0", "0x1dd8", "0x020000", "0", []string{"ens1"}},
+        // GPU 1 and ens2 behind another switch on the same socket.
+        {"pci0000:40/0000:40:01.1/0000:41:00.0/0000:42:00.0/0000:43:00.0", "0x1002", "0x120000", "0", nil},
+        {"pci0000:40/0000:40:01.1/0000:41:00.0/0000:42:01.0/0000:44:00.0", "0x1dd8", "0x020000", "0", []string{"ens2"}},
+        // GPU 2 on socket 1 with its NIC on a different root complex: paired by NUMA node.
+        {"pci0000:80/0000:80:01.1/0000:81:00.0", "0x1002", "0x038000", "1", nil},
+        {"pci0000:c0/0000:c0:01.1/0000:c1:00.0", "0x15b3", "0x020000", "1", []string{"ens3"}},
+        // Management NIC on socket 0, whose GPUs are already taken: left unpaired.
+        {"pci0000:00/0000:00:07.1/0000:05:00.0", "0x14e4", "0x020000", "0", []string{"eno1"}},
+    })
+
+    want := map[string]*topology.GPU{
+        "ens1": {Index: 0, PCIAddress: "0000:03:00.0", Via: topology.GPUViaPCIe},
+        "ens2": {Index: 1, PCIAddress: "0000:43:00.0", Via: topology.GPUViaPCIe},
+        "ens3": {Index: 2, PCIAddress: "0000:81:00.0", Via: topology.GPUViaNUMA},
+    }
+    if got := gpuAffinity(); !reflect.DeepEqual(got, want) {
+        t.Errorf("gpuAffinity() =")
+        for n, g := range got {
+            t.Errorf("  %s: %+v", n, g)
+        }
+    }
+}
+
+func TestGPUAffinityAmbiguousNUMA(t *testing.T) {
+    // Two GPUs on the NIC's NUMA node and no shared bridge: no pairing is made.
+    fakePCI(t, []pciFixture{
+        {"pci0000:00/0000:00:01.1/0000:01:00.0", "0x1002", "0x120000", "0", nil},
+        {"pci0000:00/0000:00:02.1/0000:02:00.0", "0x1002", "0x120000", "0", nil},
+        {"pci0000:00/0000:00:03.1/0000:03:00.0", "0x1dd8", "0x020000", "0", []string{"ens1"}},
+    })
+    if got := gpuAffinity(); len(got) != 0 {
+        t.Errorf("gpuAffinity() = %v, want no pairings", got)
+    }
+}
+
+func TestGPUAffinityNoSysfs(t *testing.T) {
+    fakeSysfs(t, nil, nil)
+    if got := gpuAffinity(); len(got) != 0 {
+        t.Errorf("gpuAffinity() = %v, want none", got)
+    }
+}
+
+func TestPushMetrics(t *testing.T) {
+    a := testAggregator(
+        topology.InterfaceStatus{Name: "ens1", State: ifaceStateCapturing, PacketsByEtherType: map[string]uint64{"0x88cc": 3},
+            ParseFailures: map[string]uint64{protocolLLDP: 1}, Decoded: 2, Errors: 1},
+        topology.InterfaceStatus{Name: "ens2", State: ifaceStateStopped},
+    )
+    t0 := time.Now().Add(-time.Minute)
+    local := topology.Node{Device: localHostname, Interface: "ens1"}
+    // Two switches reporting the same name and port still make two series.
+    a.storeEdge(protocolLLDP, local, topology.Node{Device: "leaf01", Interface: "Ethernet1", ChassisID: "02:1c:73:00:00:01", TTL: 120}, t0)
+    a.storeEdge(protocolLLDP, local, topology.Node{Device: "leaf01", Interface: "Ethernet1", ChassisID: "02:1c:73:00:00:02", TTL: 10}, t0)
+
+    var method, path, contentType, body string
+    gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        data, _ := io.ReadAll(r.Body)
+        method, path, contentType, body = r.Method, r.URL.EscapedPath(), r.Header.Get("Content-Type"), string(data)
+        w.WriteHeader(http.StatusOK)
+    }))
+    defer gw.Close()
+
+    if err := pushMetrics(a, gw.Client(), gw.URL+"/", "gpu-6"); err != nil {
+        t.Fatalf("pushMetrics: %v", err)
+    }
+    if method != http.MethodPut || path != "/metrics/job/netgraph/instance/gpu-6" || contentType != metricsContentType {
+        t.Errorf("request = %s %s (%s), want PUT /metrics/job/netgraph/instance/gpu-6 (%s)", method, path, contentType, metricsContentType)
+    }
+    for _, series := range []string{
+        `netgraph_lldp_neighbor{interface="ens1",remote_device="leaf01",remote_chassis="02:1c:73:00:00:01",remote_port="Ethernet1",protocol="lldp"} 1`,
+        `netgraph_lldp_neighbor{interface="ens1",remote_device="leaf01",remote_chassis="02:1c:73:00:00:02",remote_port="Ethernet1",protocol="lldp"} 0`,
+        `netgraph_interface_neighbors{interface="ens1"} 1`,
+        `netgraph_interface_neighbors{interface="ens2"} 0`,
+        `netgraph_interface_capturing{interface="ens1"} 1`,
+        `netgraph_interface_capturing{interface="ens2"} 0`,
+        `netgraph_packets_total{interface="ens1",ethertype="0x88cc"} 3`,
+        `netgraph_parse_failures_total{interface="ens1",protocol="lldp"} 1`,
+        `netgraph_frames_decoded_total{interface="ens1"} 2`,
+        `netgraph_capture_errors_total{interface="ens1"} 1`,
+    } {
+        if !strings.Contains(body, series+"\n") {
+            t.Errorf("pushed metrics do not contain %s:\n%s", series, body)
+        }
+    }
+
+    // A gateway that rejects the push is an error.
+    reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.Error(w, "duplicate series", http.StatusBadRequest)
+    }))
+    defer reject.Close()
+    if err := pushMetrics(a, reject.Client(), reject.URL, "gpu-6"); err == nil || !strings.Contains(err.Error(), "duplicate series") {
+        t.Errorf("pushMetrics() error = %v, want the gateway's rejection", err)
+    }
+}
+
+func TestCheckLink(t *testing.T) {
+    jumbo := topology.Node{MaxFrameSize: 9216}
+    tests := []struct {
+        name          string
+        local, remote topology.Node
+        want          []string // "check severity local remote"
+    }{
+        {"consistent", topology.Node{MTU: 9000, SpeedMbps: 100000, Duplex: "full", VLANs: []int{200}},
+            topology.Node{MaxFrameSize: 9216, MACPHY: &lldp.MACPHY{SpeedMbps: 100000, Duplex: "full"},
+                VLANNames: []lldp.VLAN{{ID: 200, Name: "rdma"}}}, nil},
+        {"frame too small", topology.Node{MTU: 9000}, topology.Node{MaxFrameSize: 1518}, []string{"mtu error 9000 1518"}},
+        {"vlan tag needs 4 more bytes", topology.Node{MTU: 9198, VLANs: []int{200}}, jumbo, []string{"mtu error 9198 9216"}},
+        {"exact fit", topology.Node{MTU: 9198}, jumbo, nil},
+        {"jumbo port, 1500 MTU", topology.Node{MTU: 1500}, jumbo, []string{"mtu warning 1500 9216"}},
+        {"1500 MTU, baby giants", topology.Node{MTU: 1500}, topology.Node{MaxFrameSize: 1522}, nil},
+        {"speed", topology.Node{SpeedMbps: 100000}, topology.Node{MACPHY: &lldp.MACPHY{SpeedMbps: 40000}}, []string{"speed error 100000 40000"}},
+        {"duplex from MAC/PHY", topology.Node{Duplex: "full"}, topology.Node{MACPHY: &lldp.MACPHY{Duplex: "half"}}, []string{"duplex error full half"}},
+        {"duplex from CDP", topology.Node{Duplex: "full"}, topology.Node{Duplex: "half"}, []string{"duplex error full half"}},
+        {"tagged PVID", topology.Node{VLANs: []int{100}}, topology.Node{PortVLANID: 100}, []string{"vlan warning 100 100"}},
+        {"tagged native VLAN", topology.Node{VLANs: []int{100}}, topology.Node{NativeVLAN: 100}, []string{"vlan warning 100 100"}},
+        {"vlan not carried", topology.Node{VLANs: []int{200, 300}},
+            topology.Node{VLANNames: []lldp.VLAN{{ID: 200, Name: "rdma"}}}, []string{"vlan error 300 "}},
+        // Missing values on either end: nothing to compare.
+        {"no local MTU", topology.Node{}, topology.Node{MaxFrameSize: 1518}, nil},
+        {"no remote max frame size", topology.Node{MTU: 9000}, topology.Node{}, nil},
+        {"unknown remote speed", topology.Node{SpeedMbps: 100000}, topology.Node{MACPHY: &lldp.MACPHY{}}, nil},
+        {"no local speed", topology.Node{}, topology.Node{MACPHY: &lldp.MACPHY{SpeedMbps: 40000}}, nil},
+        {"no remote duplex", topology.Node{Duplex: "full"}, topology.Node{}, nil},
+        {"no remote VLAN names", topology.Node{VLANs: []int{300}}, topology.Node{PortVLANID: 1}, nil},
+    }
+    for _, tt := range tests {
+        var got []string
+        for _, f := range checkLink(tt.local, tt.remote) {
+            got = append(got, f.Check+" "+f.Severity+" "+f.Local+" "+f.Remote)
+        }
+        if !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%s: checkLink() = %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestPrintLinkFindings(t *testing.T) {
+    edges := []topology.Edge{
+        {Local: topology.Node{Device: "gpu-1", Interface: "ens1"}, Remote: topology.Node{Device: "leaf01", Interface: "Ethernet1"}},
+        {Local: topology.Node{Device: "gpu-1", Interface: "ens2"}, Remote: topology.Node{Device: "leaf01", Interface: "Ethernet2"}},
+    }
+    var buf bytes.Buffer
+    printLinkFindings(&buf, edges)
+    if got := buf.String(); got != "Link consistency: no mismatches on 2 links\n\n" {
+        t.Errorf("report = %q", got)
+    }
+
+    edges[1].Findings = checkLink(topology.Node{MTU: 9000, SpeedMbps: 100000}, topology.Node{MaxFrameSize: 1518, MACPHY: &lldp.MACPHY{SpeedMbps: 40000}})
+    buf.Reset()
+    printLinkFindings(&buf, edges)
+    for _, line := range []string{
+        "Link consistency: 2 errors, 0 warnings on 1 of 2 links:\n",
+        "  (gpu-1, ens2) -> (leaf01, Ethernet2) ERROR mtu: local MTU 9000 needs 9018-byte frames but the remote port's max frame size is 1518\n",
+        "  (gpu-1, ens2) -> (leaf01, Ethernet2) ERROR speed: local speed 100000 Mb/s, remote port operating at 40000 Mb/s\n",
+    } {
+        if !strings.Contains(buf.String(), line) {
+            t.Errorf("report does not contain %q:\n%s", line, buf.String())
+        }
+    }
+}
+
+func TestDCBXReport(t *testing.T) {
+    ets := func(bw3 int) *lldp.ETS {
+        return &lldp.ETS{PriorityTC: []int{0, 0, 0, 3, 4, 0, 0, 0}, Bandwidth: []int{50, 0, 0, bw3, 0, 0, 0, 0},
+            TSA: []string{"ets", "strict", "strict", "ets", "strict", "ets", "ets", "ets"}} // 0% is fine for a strict-priority class
+    }
+    roce := func(p int) []lldp.AppPriority {
+        return []lldp.AppPriority{{Priority: p, Selector: "udp", Protocol: lldp.RoCEv2UDPPort}}
+    }
+    edge := func(iface, protocol string, d *lldp.DCBX) topology.Edge {
+        return topology.Edge{Protocol: protocol, Local: topology.Node{Device: "gpu-1", Interface: iface},
+            Remote: topology.Node{Device: "leaf01", Interface: "Ethernet-" + iface, DCBX: d}}
+    }
+    edges := []topology.Edge{
+        edge("ens1", protocolLLDP, &lldp.DCBX{PFC: &lldp.PFC{Enabled: []int{3}}, ETS: ets(50), Applications: roce(3)}),
+        edge("ens2", protocolLLDP, &lldp.DCBX{PFC: &lldp.PFC{Enabled: []int{3}}, ETS: ets(0)}),
+        edge("ens3", protocolLLDP, &lldp.DCBX{PFC: &lldp.PFC{Enabled: []int{3, 4}}, ETS: ets(50), Applications: roce(4)}),
+        edge("ens4", protocolLLDP, &lldp.DCBX{PFC: &lldp.PFC{Enabled: []int{5}}}),
+        edge("ens5", protocolLLDP, nil),
+        edge("ens6", protocolCDP, nil),
+    }
+    problems := func(ports []dcbxPort) map[string]string {
+        out := make(map[string]string)
+        for _, p := range ports {
+            out[p.Local.Interface] = strings.Join(p.Problems, "; ")
+        }
+        return out
+    }
+
+    // Inferred: 3 is lossless on most ports; ports without DCBX are not checked.
+    expected, ports := dcbxReport(edges, -1)
+    want := map[string]string{
+        "ens1": "",
+        "ens2": "traffic class 3 has 0% ETS bandwidth",
+        "ens3": "unexpected lossless priorities [4]; RoCEv2 is mapped to priority 4",
+        "ens4": "priority 3 is not lossless; unexpected lossless priorities [5]",
+    }
+    if got := problems(ports); expected != 3 || !reflect.DeepEqual(got, want) {
+        t.Errorf("dcbxReport(-1) = %d, %q; want 3, %q", expected, got, want)
+    }
+    if p := ports[0]; p.TC != 3 || p.Bandwidth != 50 || p.RoCEPriority != 3 {
+        t.Errorf("ens1 = %+v, want tc 3, 50%%, RoCE on 3", p)
+    }
+    if p := ports[3]; p.TC != -1 || p.Bandwidth != -1 || p.RoCEPriority != -1 {
+        t.Errorf("ens4 = %+v, want unknown tc, bandwidth and RoCE priority", p)
+    }
+
+    // Explicit: every LLDP port is checked, including those that sent no DCBX.
+    expected, ports = dcbxReport(edges, 4)
+    want = map[string]string{
+        "ens1": "priority 4 is not lossless; unexpected lossless priorities [3]; RoCEv2 is mapped to priority 3",
+        "ens2": "priority 4 is not lossless; unexpected lossless priorities [3]",
+        "ens3": "unexpected lossless priorities [3]",
+        "ens4": "priority 4 is not lossless; unexpected lossless priorities [5]",
+        "ens5": "no PFC advertised",
+    }
+    if got := problems(ports); expected != 4 || !reflect.DeepEqual(got, want) {
+        t.Errorf("dcbxReport(4) = %d, %q; want 4, %q", expected, got, want)
+    }
+
+    // Nothing advertises PFC: nothing to infer.
+    if expected, ports := dcbxReport(edges[4:], -1); expected != -1 || ports != nil {
+        t.Errorf("dcbxReport(no PFC) = %d, %+v; want -1 and no ports", expected, ports)
+    }
+
+    var buf bytes.Buffer
+    expected, ports = dcbxReport(edges, -1)
+    printDCBXReport(&buf, expected, ports, true)
+    for _, line := range []string{
+        "DCBX lossless priority report (inferred priority 3):\n",
+        "  (gpu-1, ens1) -> (leaf01, Ethernet-ens1) pfc=[3] tc=3 bw=50% roce=3: OK\n",
+        "  (gpu-1, ens4) -> (leaf01, Ethernet-ens4) pfc=[5] tc=- bw=- roce=-: MISMATCH: priority 3 is not lossless; unexpected lossless priorities [5]\n",
+        "  1 of 4 ports OK\n",
+    } {
+        if !strings.Contains(buf.String(), line) {
+            t.Errorf("report does not contain %q:\n%s", line, buf.String())
+        }
+    }
+}
+
+// cdpFrame builds an 802.3/SNAP CDPv2 announcement with a holdtime of ttl seconds.
+func cdpFrame(t *testing.T, srcMAC string, ttl byte, tlvs map[uint16][]byte) gopacket.Packet {
+    t.Helper()
+    cdp := []byte{2, ttl, 0, 0}
+    for _, typ := range []uint16{0x0001, 0x0003, 0x0004, 0x0005, 0x0006, 0x000a, 0x000b, 0x0016} {
+        if v, ok := tlvs[typ]; ok {
+            cdp = append(cdp, byte(typ>>8), byte(typ), byte((len(v)+4)>>8), byte(len(v)+4))
+            cdp = append(cdp, v...)
+        }
+    }
+    mac, _ := net.ParseMAC(srcMAC)
+    frame := append([]byte{0x01, 0x00, 0x0c, 0xcc, 0xcc, 0xcc}, mac...)
+    frame = append(frame, byte((len(cdp)+8)>>8), byte(len(cdp)+8))
+    frame = append(frame, 0xaa, 0xaa, 0x03, 0x00, 0x00, 0x0c, 0x20, 0x00) // LLC + SNAP, protocol 0x2000
+    packet := gopacket.NewPacket(append(frame, cdp...), layers.LayerTypeEthernet, gopacket.Default)
+    packet.Metadata().Timestamp = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    return packet
+}
+
+func TestDecodeCDP(t *testing.T) {
+    fakeSysfs(t, nil, nil)
+    packet := cdpFrame(t, "00:3a:9c:00:01:09", 180, map[uint16][]byte{
+        0x0001: []byte("leaf03.example.com(FDO2130X0AB)"),
+        0x0003: []byte("Ethernet1/9"),
+        0x0004: {0x00, 0x00, 0x00, 0x28}, // switch, IGMP
+        0x0005: []byte("Cisco Nexus Operating System (NX-OS) Software, Version 10.3(4a)"),
+        0x0006: []byte("N9K-C93180YC-FX"),
+        0x000a: {0x00, 0x64}, // native VLAN 100
+        0x000b: {0x01},       // full duplex
+        0x0016: {0, 0, 0, 1, 0x01, 0x01, 0xcc, 0x00, 0x04, 10, 0, 0, 3},
+    })
+
+    ev := decodePacket("ens9", packet)
+    if ev.EtherType != cdpEtherType || ev.Failed != "" || ev.Edge == nil || ev.Neighbor == nil {
+        t.Fatalf("decodePacket() = %+v, want a CDP edge and neighbor", ev)
+    }
+    want := topology.Node{
+        Device:              "leaf03.example.com(FDO2130X0AB)",
+        Interface:           "Ethernet1/9",
+        MAC:                 "00:3a:9c:00:01:09",
+        TTL:                 180,
+        Capabilities:        []string{"switch", "igmp"},
+        ManagementAddresses: []string{"10.0.0.3"},
+        Platform:            "N9K-C93180YC-FX",
+        SoftwareVersion:     "Cisco Nexus Operating System (NX-OS) Software, Version 10.3(4a)",
+        NativeVLAN:          100,
+        Duplex:              "full",
+    }
+    if ev.Edge.Protocol != protocolCDP || !reflect.DeepEqual(ev.Edge.Remote, want) {
+        t.Errorf("remote =\n%+v\nwant\n%+v", ev.Edge.Remote, want)
+    }
+    if ev.Edge.Local.Interface != "ens9" || ev.Edge.Local.Device != localHostname {
+        t.Errorf("local = %+v", ev.Edge.Local)
+    }
+    if n := ev.Neighbor.Neighbor; ev.Neighbor.Key != "ens9-CDP-00:3a:9c:00:01:09" || n.Protocol != "CDP" ||
+        !strings.Contains(n.Details, "NativeVLAN=100") {
+        t.Errorf("neighbor = %+v", ev.Neighbor)
+    }
+
+    // The holdtime becomes the edge's TTL; CDP has no chassis ID, so the Device ID keys the link.
+    a := testAggregator(topology.InterfaceStatus{Name: "ens9", State: ifaceStateCapturing})
+    a.apply(ev)
+    edges := a.rawEdges()
+    if len(edges) != 1 || edges[0].Protocol != protocolCDP || !edges[0].LastSeen.Equal(packet.Metadata().Timestamp) {
+        t.Fatalf("edges = %+v, want one CDP edge", edges)
+    }
+    if key := newEdgeKey(edges[0].Local, edges[0].Remote); key.remoteChassis != want.Device {
+        t.Errorf("edge key = %+v, want the Device ID as the chassis", key)
+    }
+    if seen := edges[0].LastSeen; edgeExpired(edges[0], seen.Add(179*time.Second)) || !edgeExpired(edges[0], seen.Add(181*time.Second)) {
+        t.Errorf("edge does not expire after its 180s holdtime")
+    }
+
+    // Without the duplex TLV, duplex is unknown rather than half; without a Device ID the
+    // system name is used.
+    ev = decodePacket("ens9", cdpFrame(t, "00:3a:9c:00:01:09", 180, map[uint16][]byte{0x0003: []byte("Ethernet1/9")}))
+    if ev.Edge == nil || ev.Edge.Remote.Duplex != "" || ev.Edge.Remote.Device != "UnknownRemote" {
+        t.Errorf("remote = %+v, want an unknown device without duplex", ev.Edge)
+    }
+}
+
+func TestReplayCaptureFile(t *testing.T) {
+    old := offlineReplay
+    offlineReplay = true
+    t.Cleanup(func() { offlineReplay = old })
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+    // Both files hold leaf01 (TTL 120) at t0 and t0+60s and leaf02 (TTL 30) at t0+10s.
+    tests := []struct {
+        file, iface string
+        wantIface   string
+    }{
+        {"testdata/lldp.pcapng", "", "ens1np0"}, // named by the pcapng Interface Description Block
+        {"testdata/lldp.pcapng", "ens7", "ens7"},
+        {"testdata/lldp.pcap", "", "unknown"},
+        {"testdata/lldp.pcap", "ens7", "ens7"},
+    }
+    for _, tt := range tests {
+        resetCollection(t)
+        startReplayCollection(tt.file)
+        a := testAggregator()
+        last, err := replayCaptureFile(a, tt.file, tt.iface)
+        if err != nil {
+            t.Errorf("%s: replayCaptureFile: %v", tt.file, err)
+            continue
+        }
+        if !last.Equal(t0.Add(time.Minute)) {
+            t.Errorf("%s: last packet at %v, want %v", tt.file, last, t0.Add(time.Minute))
+        }
+        // Staleness is judged at the end of the capture, not at the time of the replay.
+        a.markStaleEdges(last)
+
+        edges := a.rawEdges()
+        if len(edges) != 2 {
+            t.Errorf("%s: edges = %+v, want leaf01 and leaf02", tt.file, edges)
+            continue
+        }
+        leaf01, leaf02 := edges[0], edges[1]
+        if leaf01.Local.Interface != tt.wantIface || leaf02.Local.Interface != tt.wantIface {
+            t.Errorf("%s (%q): local interfaces %q, %q, want %q", tt.file, tt.iface, leaf01.Local.Interface, leaf02.Local.Interface, tt.wantIface)
+        }
+        if leaf01.Remote.Device != "leaf01" || !leaf01.FirstSeen.Equal(t0) || !leaf01.LastSeen.Equal(t0.Add(time.Minute)) || leaf01.Frames != 2 || leaf01.Stale {
+            t.Errorf("%s: leaf01 = %+v, want two frames at the packet times and not stale", tt.file, leaf01)
+        }
+        if leaf02.Remote.Device != "leaf02" || !leaf02.LastSeen.Equal(t0.Add(10*time.Second)) || !leaf02.Stale {
+            t.Errorf("%s: leaf02 = %+v, want its TTL to have run out by the last packet", tt.file, leaf02)
+        }
+        if c := currentCollection(); !c.CaptureStart.Equal(t0) || !c.CaptureEnd.Equal(t0.Add(time.Minute)) ||
+            !reflect.DeepEqual(c.Interfaces, []string{tt.wantIface}) {
+            t.Errorf("%s: collection = %+v", tt.file, c)
+        }
+    }
+
+    if _, err := replayCaptureFile(testAggregator(), "testdata/missing.pcap", ""); err == nil {
+        t.Error("replayCaptureFile(missing) succeeded, want error")
+    }
+}
+
+// apiGet serves one GET request from the HTTP API and returns the recorded response.
+func apiGet(t *testing.T, a *aggregator, path string) *httptest.ResponseRecorder {
+    t.Helper()
+    rec := httptest.NewRecorder()
+    apiHandler(a).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+    if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+        t.Errorf("GET %s: Content-Type = %q, want application/json", path, ct)
+    }
+    return rec
+}
+
+func TestAPI(t *testing.T) {
+    fakeSysfs(t, nil, nil)
+    a := testAggregator(
+        topology.InterfaceStatus{Name: "ens1", MAC: "02:00:00:00:00:01", State: ifaceStateCapturing},
+        topology.InterfaceStatus{Name: "ens2", State: ifaceStateFailed, Error: "no such device"},
+    )
+    a.storeEdge(protocolLLDP, topology.Node{Device: "gpu-1", Interface: "ens1"},
+        topology.Node{Device: "leaf01", Interface: "Ethernet1", ChassisID: "02:1c:73:00:00:01", TTL: 120}, time.Now())
+
+    rec := apiGet(t, a, "/edges")
+    var edges []map[string]interface{}
+    if err := json.Unmarshal(rec.Body.Bytes(), &edges); rec.Code != http.StatusOK || err != nil {
+        t.Fatalf("GET /edges = %d, %v:\n%s", rec.Code, err, rec.Body)
+    }
+    if len(edges) != 1 {
+        t.Fatalf("GET /edges = %s, want one edge", rec.Body)
+    }
+    local, _ := edges[0]["local"].(map[string]interface{})
+    remote, _ := edges[0]["remote"].(map[string]interface{})
+    if _, stale := edges[0]["stale"]; stale || edges[0]["protocol"] != protocolLLDP || edges[0]["frames"] != 1.0 || local["interface"] != "ens1" ||
+        remote["device"] != "leaf01" || remote["chassis_id"] != "02:1c:73:00:00:01" || remote["ttl"] != 120.0 {
+        t.Errorf("GET /edges = %s", rec.Body)
+    }
+
+    rec = apiGet(t, a, "/interfaces")
+    var ifaces []topology.InterfaceStatus
+    if err := json.Unmarshal(rec.Body.Bytes(), &ifaces); rec.Code != http.StatusOK || err != nil {
+        t.Fatalf("GET /interfaces = %d, %v:\n%s", rec.Code, err, rec.Body)
+    }
+    if len(ifaces) != 2 || ifaces[0].Name != "ens1" || ifaces[0].State != ifaceStateCapturing ||
+        ifaces[1].State != ifaceStateFailed || ifaces[1].Error != "no such device" {
+        t.Errorf("GET /interfaces = %s", rec.Body)
+    }
+
+    health := func(wantCode int, wantStatus string, wantCapturing float64) {
+        t.Helper()
+        rec := apiGet(t, a, "/healthz")
+        var h map[string]interface{}
+        if err := json.Unmarshal(rec.Body.Bytes(), &h); err != nil {
+            t.Fatalf("GET /healthz: %v", err)
+        }
+        if rec.Code != wantCode || h["status"] != wantStatus || h["interfaces_capturing"] != wantCapturing ||
+            h["edges"] != 1.0 || h["hostname"] != localHostname {
+            t.Errorf("GET /healthz = %d %s, want %d %q", rec.Code, rec.Body, wantCode, wantStatus)
+        }
+    }
+    health(http.StatusOK, "ok", 1)
+    a.setInterfaceState("ens1", ifaceStateStopped, nil)
+    health(http.StatusServiceUnavailable, "no interface capturing", 0)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/stp"
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+)
+
+// checkSTPBlocked is the finding for a host link the bridge port at the other end blocks.
+const checkSTPBlocked = "stp-blocked"
+
+// recordSTP stores b as the latest BPDU received on ifName. Topology Change Notifications
+// and newly raised topology change flags are counted, but a TCN carries no port information
+// and does not replace the last configuration or RST BPDU. a.mu must be held.
+func (a *aggregator) recordSTP(ifName string, b stp.BPDU, seen time.Time) {
+    p, ok := a.stpPorts[ifName]
+    if !ok {
+        p = &topology.STPPort{BPDU: stp.BPDU{Type: stp.TypeTCN}}
+        a.stpPorts[ifName] = p
+    }
+    p.BPDUs++
+    if b.Type == stp.TypeTCN {
+        p.TopologyChanges++
+        return
+    }
+    if seen.Before(p.LastBPDU) {
+        return
+    }
+    if b.TopologyChange && !p.TopologyChange {
+        p.TopologyChanges++
+    }
+    p.BPDU, p.LastBPDU = b, seen
+}
+
+// stpSnapshot returns a copy of the spanning tree state of every interface.
+func (a *aggregator) stpSnapshot() map[string]topology.STPPort {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    out := make(map[string]topology.STPPort, len(a.stpPorts))
+    for name, p := range a.stpPorts {
+        out[name] = *p
+    }
+    return out
+}
+
+// attachSTP annotates the remote end of every edge with the BPDU its port sent on the link.
+func attachSTP(edges []topology.Edge, ports map[string]topology.STPPort) []topology.Edge {
+    for i := range edges {
+        p, ok := ports[edges[i].Local.Interface]
+        if !ok || p.Type == stp.TypeTCN {
+            continue
+        }
+        b := p.BPDU
+        edges[i].Remote.STP = &b
+    }
+    return edges
+}
+
+// stpFindings reports the local interfaces cabled to a bridge port that does not forward:
+// an alternate (or backup) port, which stays blocked while the tree is stable, or a
+// discarding one, which is either converging or blocked. Either way the link is up but
+// carries no traffic. Only what the BPDUs say is checked, and bridges normally send none
+// from blocked ports (802.1D never does, RSTP and MSTP stop once a port becomes alternate
+// or backup), so a port blocked for the whole capture goes unreported; one that blocks
+// during it shows up as discarding before its BPDUs stop.
+func stpFindings(ports map[string]topology.STPPort, edges []topology.Edge) []topology.Finding {
+    var findings []topology.Finding
+    for _, name := range stpPortNames(ports) {
+        p := ports[name]
+        if p.Type == stp.TypeTCN || !p.Blocked() {
+            continue
+        }
+        f := topology.Finding{
+            Check:    checkSTPBlocked,
+            Severity: topology.SeverityError,
+            Message: fmt.Sprintf("%s is cabled to an %s port of bridge %s%s: the link is up but the bridge does not forward on it",
+                name, p.Role, p.Bridge, stpNeighbor(name, edges)),
+            Local:    name,
+            Remote:   p.Bridge + " port " + p.Port,
+            Evidence: []string{stpEvidence(p)},
+        }
+        if p.Role != stp.RoleAlternate {
+            f.Severity = topology.SeverityWarning
+            f.Message = fmt.Sprintf("%s is cabled to a discarding %s port of bridge %s%s: the port is converging or blocked "+
+                "(blocked ports send no BPDUs, so if they stop the port stayed blocked)",
+                name, p.Role, p.Bridge, stpNeighbor(name, edges))
+        }
+        findings = append(findings, f)
+    }
+    return findings
+}
+
+// stpNeighbor names the LLDP/CDP neighbors heard on a local interface, as " (leaf01 Ethernet1)".
+func stpNeighbor(iface string, edges []topology.Edge) string {
+    var ports []string
+    for _, e := range edges {
+        if e.Local.Interface == iface {
+            ports = appendUnique(ports, e.Remote.Device+" "+e.Remote.Interface)
+        }
+    }
+    if len(ports) == 0 {
+        return ""
+    }
+    return " (" + strings.Join(ports, ", ") + ")"
+}
+
+// stpEvidence describes the latest BPDU of a port.
+func stpEvidence(p topology.STPPort) string {
+    return fmt.Sprintf("%s BPDU at %s: root %s cost %d, designated bridge %s port %s, role %s, state %s (%d BPDU(s))",
+        p.VersionName(), p.LastBPDU.Format(time.RFC3339), p.Root, p.RootPathCost, p.Bridge, p.Port, p.Role, p.State, p.BPDUs)
+}
+
+// stpPortNames returns the interfaces that received BPDUs, sorted.
+func stpPortNames(ports map[string]topology.STPPort) []string {
+    names := make([]string, 0, len(ports))
+    for name := range ports {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// printSTPReport prints the spanning tree state of every interface that received BPDUs,
+// marking the ones cabled to a blocked bridge port.
+func printSTPReport(w io.Writer, ports map[string]topology.STPPort, edges []topology.Edge) {
+    if len(ports) == 0 {
+        return
+    }
+    fmt.Fprintf(w, "Spanning tree (%d ports):\n", len(ports))
+    for _, name := range stpPortNames(ports) {
+        p := ports[name]
+        if p.Type == stp.TypeTCN {
+            fmt.Fprintf(w, "  %s: %d topology change notification(s), no configuration BPDU\n", name, p.TopologyChanges)
+            continue
+        }
+        fmt.Fprintf(w, "  %s: %s root %s cost %d, designated bridge %s port %s", name, p.VersionName(), p.Root, p.RootPathCost, p.Bridge, p.Port)
+        if p.Role != "" {
+            fmt.Fprintf(w, ", %s %s", p.Role, p.State)
+        }
+        if p.Region != "" {
+            fmt.Fprintf(w, ", region %s", p.Region)
+        }
+        if p.TopologyChanges > 0 {
+            fmt.Fprintf(w, ", %d topology change(s)", p.TopologyChanges)
+        }
+        fmt.Fprint(w, stpNeighbor(name, edges))
+        if p.Blocked() {
+            fmt.Fprint(w, " BLOCKED")
+        }
+        fmt.Fprintln(w)
+    }
+    findings := stpFindings(ports, edges)
+    for _, f := range findings {
+        fmt.Fprintf(w, "  %s %s: %s\n", strings.ToUpper(f.Severity), f.Check, f.Message)
+    }
+    if len(findings) == 0 {
+        fmt.Fprint(w, "  No blocked port seen; bridges send no BPDUs from alternate or backup ports, so a port blocked all along is not detected\n")
+    }
+    fmt.Fprintln(w)
+}
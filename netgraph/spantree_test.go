@@ -0,0 +1,122 @@
+package main
+
+import (
+    "bytes"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/stp"
+    "github.com/AMD-DC-GPU/ce/netgraph/topology"
+    "github.com/gopacket/gopacket"
+    "github.com/gopacket/gopacket/layers"
+)
+
+// stpPacket builds an 802.3 LLC frame carrying an RST BPDU (or a TCN when flags is tcn)
+// from bridge port portID of 02:1c:73:00:00:02, with root bridge 4096.02:1c:73:00:00:01.
+func stpPacket(t *testing.T, srcMAC string, flags byte, portID uint16, at time.Time) gopacket.Packet {
+    t.Helper()
+    bpdu := []byte{0, 0, stp.VersionSTP, stp.TypeTCN}
+    if flags != tcn {
+        bpdu = []byte{0, 0, stp.VersionRSTP, stp.TypeRST, flags,
+            0x10, 0x00, 0x02, 0x1c, 0x73, 0x00, 0x00, 0x01, // root
+            0x00, 0x00, 0x07, 0xd0, // root path cost 2000
+            0x80, 0x00, 0x02, 0x1c, 0x73, 0x00, 0x00, 0x02, // bridge
+            byte(portID >> 8), byte(portID),
+            0x00, 0x00, 0x14, 0x00, 0x02, 0x00, 0x0f, 0x00, 0x00}
+    }
+    mac, _ := net.ParseMAC(srcMAC)
+    eth := &layers.Ethernet{SrcMAC: mac, DstMAC: stp.MulticastMAC, EthernetType: layers.EthernetTypeLLC, Length: uint16(3 + len(bpdu))}
+    llc := &layers.LLC{DSAP: stp.LLCSAP, SSAP: stp.LLCSAP, Control: 0x03}
+    buf := gopacket.NewSerializeBuffer()
+    if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, eth, llc, gopacket.Payload(bpdu)); err != nil {
+        t.Fatal(err)
+    }
+    packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default)
+    packet.Metadata().Timestamp = at
+    return packet
+}
+
+const (
+    tcn              = 0xff // stpPacket: send a Topology Change Notification
+    stpDesignatedFwd = 0x7c // designated, learning, forwarding, agreement
+    stpAlternate     = 0x44 // alternate, discarding, agreement
+)
+
+func TestSpanningTree(t *testing.T) {
+    a := testAggregator(
+        topology.InterfaceStatus{Name: "eno1", State: ifaceStateCapturing},
+        topology.InterfaceStatus{Name: "eno2", State: ifaceStateCapturing},
+    )
+    t0 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    a.processPacket("eno1", stpPacket(t, "02:1c:73:00:00:11", stpDesignatedFwd, 0x8011, t0))
+    a.processPacket("eno1", stpPacket(t, "02:1c:73:00:00:11", stpDesignatedFwd|0x01, 0x8011, t0.Add(2*time.Second))) // TC raised
+    a.processPacket("eno1", stpPacket(t, "02:1c:73:00:00:11", stpDesignatedFwd|0x01, 0x8011, t0.Add(4*time.Second))) // ... still raised
+    a.processPacket("eno2", stpPacket(t, "02:1c:73:00:00:12", stpAlternate, 0x8012, t0))
+    a.processPacket("eno2", stpPacket(t, "02:1c:73:00:00:12", tcn, 0, t0.Add(time.Second)))
+    for _, p := range []struct{ iface, remote string }{{"eno1", "Ethernet17"}, {"eno2", "Ethernet18"}} {
+        a.storeEdge(protocolLLDP, topology.Node{Device: "gpu-1", Interface: p.iface},
+            topology.Node{Device: "leaf01", Interface: p.remote, ChassisID: "02:1c:73:00:00:02", TTL: 120}, t0)
+    }
+
+    ports := a.stpSnapshot()
+    eno1, eno2 := ports["eno1"], ports["eno2"]
+    if eno1.Role != stp.RoleDesignated || eno1.BPDUs != 3 || eno1.TopologyChanges != 1 || eno1.Blocked() {
+        t.Errorf("eno1 = %+v, want a forwarding designated port with one topology change", eno1)
+    }
+    // The TCN is counted but keeps the alternate port's BPDU.
+    if eno2.Role != stp.RoleAlternate || eno2.Port != "128.18" || eno2.BPDUs != 2 || eno2.TopologyChanges != 1 || !eno2.Blocked() {
+        t.Errorf("eno2 = %+v, want a blocked alternate port", eno2)
+    }
+    if st := a.interfacesSnapshot(); st[1].STP == nil || st[1].STP.Root != "4096.02:1c:73:00:00:01" || st[1].Decoded != 2 {
+        t.Errorf("eno2 status = %+v", st[1])
+    }
+    if n := a.neighborsSnapshot(); len(n) != 2 || n[0].Protocol != "STP" {
+        t.Errorf("neighborsSnapshot() = %+v, want the two bridge ports", n)
+    }
+
+    edges := a.edgesSnapshot(t0)
+    if edges[0].Remote.STP == nil || edges[0].Remote.STP.Blocked() || edges[1].Remote.STP == nil || !edges[1].Remote.STP.Blocked() {
+        t.Errorf("edges = %+v, want eno2's link behind a blocked port", edges)
+    }
+    findings := stpFindings(ports, edges)
+    if len(findings) != 1 || findings[0].Check != checkSTPBlocked || findings[0].Local != "eno2" ||
+        findings[0].Severity != topology.SeverityError || findings[0].Remote != "32768.02:1c:73:00:00:02 port 128.18" {
+        t.Errorf("stpFindings() = %+v", findings)
+    }
+
+    var buf bytes.Buffer
+    printSTPReport(&buf, ports, edges)
+    for _, line := range []string{
+        "eno1: rstp root 4096.02:1c:73:00:00:01 cost 2000, designated bridge 32768.02:1c:73:00:00:02 port 128.17, designated forwarding, 1 topology change(s) (leaf01 Ethernet17)\n",
+        "eno2: rstp root 4096.02:1c:73:00:00:01 cost 2000, designated bridge 32768.02:1c:73:00:00:02 port 128.18, alternate discarding, 1 topology change(s) (leaf01 Ethernet18) BLOCKED\n",
+        "ERROR stp-blocked: eno2 is cabled to an alternate port of bridge 32768.02:1c:73:00:00:02 (leaf01 Ethernet18)",
+    } {
+        if !strings.Contains(buf.String(), line) {
+            t.Errorf("report does not contain %q:\n%s", line, buf.String())
+        }
+    }
+    if strings.Contains(buf.String(), "No blocked port seen") {
+        t.Errorf("report claims no blocked port:\n%s", buf.String())
+    }
+
+    // Without a blocked port the report says what it can't see.
+    buf.Reset()
+    printSTPReport(&buf, map[string]topology.STPPort{"eno1": eno1}, edges)
+    if line := "No blocked port seen; bridges send no BPDUs from alternate or backup ports"; !strings.Contains(buf.String(), line) {
+        t.Errorf("report does not contain %q:\n%s", line, buf.String())
+    }
+}
+
+func TestSpanningTreeParseFailure(t *testing.T) {
+    a := testAggregator(topology.InterfaceStatus{Name: "eno1", State: ifaceStateCapturing})
+    packet := stpPacket(t, "02:1c:73:00:00:11", stpDesignatedFwd, 0x8011, time.Now())
+    data := append([]byte(nil), packet.Data()...)
+    data[17] = 0x07 // protocol identifier
+    a.processPacket("eno1", gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default))
+    st := a.interfacesSnapshot()[0]
+    if st.ParseFailures[protocolSTP] != 1 || st.PacketsByEtherType["0x0042"] != 1 || st.STP != nil {
+        t.Errorf("eno1 = %+v, want one STP parse failure", st)
+    }
+}
@@ -0,0 +1,186 @@
+// Package stp decodes IEEE 802.1D Spanning Tree, 802.1w Rapid Spanning Tree and 802.1s
+// Multiple Spanning Tree bridge protocol data units.
+//
+// BPDUs are 802.3 LLC frames (DSAP and SSAP 0x42) sent to 01:80:c2:00:00:00. Parse decodes
+// the configuration, RST and MST BPDUs that follow the LLC header; Topology Change
+// Notifications carry nothing but their type.
+package stp
+
+import (
+    "bytes"
+    "encoding/binary"
+    "fmt"
+    "net"
+)
+
+// LLCSAP is the LLC service access point of the Spanning Tree Protocol.
+const LLCSAP = 0x42
+
+// MulticastMAC is the Bridge Group Address BPDUs are sent to (01:80:c2:00:00:00).
+var MulticastMAC = net.HardwareAddr{0x01, 0x80, 0xc2, 0x00, 0x00, 0x00}
+
+// Protocol versions.
+const (
+    VersionSTP  = 0
+    VersionRSTP = 2
+    VersionMSTP = 3
+)
+
+// BPDU types.
+const (
+    TypeConfig = 0x00
+    TypeRST    = 0x02 // RST and MST BPDUs
+    TypeTCN    = 0x80 // Topology Change Notification
+)
+
+// Port roles carried in the flags of RST and MST BPDUs.
+const (
+    RoleMaster     = "master" // MSTP: the port towards the CIST root of another region
+    RoleAlternate  = "alternate"
+    RoleRoot       = "root"
+    RoleDesignated = "designated"
+)
+
+// Port states carried in the flags of RST and MST BPDUs.
+const (
+    StateDiscarding = "discarding"
+    StateLearning   = "learning"
+    StateForwarding = "forwarding"
+)
+
+// Lengths of the BPDU types, from the protocol identifier on.
+const (
+    tcnLen    = 4
+    configLen = 35
+    rstLen    = 36
+    mstLen    = 102 // up to the CIST remaining hops, without MSTI configuration messages
+)
+
+// BPDU is a decoded BPDU. Root, Bridge and Port describe the sender's view of the tree:
+// the root bridge, the sender itself (the designated bridge of the link) and its port.
+// Bridge IDs are written as "<priority>.<MAC>", port IDs as "<priority>.<number>".
+type BPDU struct {
+    Version int `json:"version"` // VersionSTP, VersionRSTP or VersionMSTP
+    Type    int `json:"type"`
+
+    Root         string `json:"root,omitempty"`
+    RootPathCost uint32 `json:"root_path_cost"`
+    Bridge       string `json:"bridge,omitempty"`
+    Port         string `json:"port,omitempty"`
+
+    // Role and State are only sent in RST and MST BPDUs. The alternate role also covers backup ports.
+    Role  string `json:"role,omitempty"`
+    State string `json:"state,omitempty"`
+
+    TopologyChange    bool `json:"topology_change,omitempty"`
+    TopologyChangeAck bool `json:"topology_change_ack,omitempty"`
+    Proposal          bool `json:"proposal,omitempty"`
+    Agreement         bool `json:"agreement,omitempty"`
+
+    // Timers, in seconds.
+    MessageAge   int `json:"message_age"`
+    MaxAge       int `json:"max_age"`
+    HelloTime    int `json:"hello_time"`
+    ForwardDelay int `json:"forward_delay"`
+
+    // MSTP only: the MST region and the root of the CIST within it.
+    Region         string `json:"region,omitempty"`
+    RegionRevision int    `json:"region_revision,omitempty"`
+    RegionalRoot   string `json:"regional_root,omitempty"`
+}
+
+// VersionName returns "stp", "rstp" or "mstp".
+func (b BPDU) VersionName() string {
+    switch b.Version {
+    case VersionSTP:
+        return "stp"
+    case VersionRSTP:
+        return "rstp"
+    case VersionMSTP:
+        return "mstp"
+    }
+    return fmt.Sprintf("version %d", b.Version)
+}
+
+// Blocked reports whether the sending port is an alternate (or backup) port or discarding,
+// so frames sent towards it are not forwarded. Plain STP BPDUs carry neither, and are only
+// sent by designated ports. RSTP and MSTP alternate and backup ports normally send no BPDUs
+// either, so a received BPDU rarely shows one: what Blocked mostly catches is a designated
+// port that is still discarding while it converges, or a bridge that does send from blocked ports.
+func (b BPDU) Blocked() bool {
+    return b.Role == RoleAlternate || b.State == StateDiscarding
+}
+
+// Parse decodes a BPDU from the payload that follows the LLC header.
+func Parse(payload []byte) (BPDU, error) {
+    var b BPDU
+    if len(payload) < tcnLen {
+        return b, fmt.Errorf("stp: short BPDU (%d bytes)", len(payload))
+    }
+    if id := binary.BigEndian.Uint16(payload[0:2]); id != 0 {
+        return b, fmt.Errorf("stp: unknown protocol identifier 0x%04x", id)
+    }
+    b.Version, b.Type = int(payload[2]), int(payload[3])
+    switch b.Type {
+    case TypeTCN:
+        return b, nil
+    case TypeConfig:
+        if len(payload) < configLen {
+            return b, fmt.Errorf("stp: short configuration BPDU (%d bytes)", len(payload))
+        }
+    case TypeRST:
+        if len(payload) < rstLen {
+            return b, fmt.Errorf("stp: short RST BPDU (%d bytes)", len(payload))
+        }
+    default:
+        return b, fmt.Errorf("stp: unknown BPDU type 0x%02x", b.Type)
+    }
+
+    // [flags][root ID x8][root path cost x4][bridge ID x8][port ID x2][message age x2][max age x2][hello x2][forward delay x2]
+    flags := payload[4]
+    b.TopologyChange = flags&0x01 != 0
+    b.TopologyChangeAck = flags&0x80 != 0
+    b.Root = bridgeID(payload[5:13])
+    b.RootPathCost = binary.BigEndian.Uint32(payload[13:17])
+    b.Bridge = bridgeID(payload[17:25])
+    b.Port = portID(binary.BigEndian.Uint16(payload[25:27]))
+    b.MessageAge = int(binary.BigEndian.Uint16(payload[27:29]) / 256)
+    b.MaxAge = int(binary.BigEndian.Uint16(payload[29:31]) / 256)
+    b.HelloTime = int(binary.BigEndian.Uint16(payload[31:33]) / 256)
+    b.ForwardDelay = int(binary.BigEndian.Uint16(payload[33:35]) / 256)
+    if b.Type == TypeConfig {
+        return b, nil
+    }
+
+    b.Proposal = flags&0x02 != 0
+    b.Agreement = flags&0x40 != 0
+    b.Role = [4]string{RoleMaster, RoleAlternate, RoleRoot, RoleDesignated}[flags>>2&0x03]
+    switch {
+    case flags&0x20 != 0:
+        b.State = StateForwarding
+    case flags&0x10 != 0:
+        b.State = StateLearning
+    default:
+        b.State = StateDiscarding
+    }
+
+    // [version 1 length][version 3 length x2][MST configuration ID x51][CIST internal root path cost x4][CIST bridge ID x8][remaining hops]
+    if b.Version >= VersionMSTP && len(payload) >= mstLen {
+        // Within a region the bridge ID field holds the CIST regional root.
+        b.RegionalRoot = b.Bridge
+        b.Bridge = bridgeID(payload[93:101])
+        b.Region = string(bytes.TrimRight(payload[39:71], "\x00"))
+        b.RegionRevision = int(binary.BigEndian.Uint16(payload[71:73]))
+    }
+    return b, nil
+}
+
+// bridgeID formats an 8-byte bridge ID: the priority (including the system ID extension) and MAC.
+func bridgeID(b []byte) string {
+    return fmt.Sprintf("%d.%s", binary.BigEndian.Uint16(b[0:2]), net.HardwareAddr(b[2:8]))
+}
+
+// portID formats a port ID: a 4-bit priority in steps of 16 and a 12-bit port number.
+func portID(id uint16) string {
+    return fmt.Sprintf("%d.%d", id>>12*16, id&0x0fff)
+}
@@ -0,0 +1,110 @@
+package stp
+
+import (
+    "testing"
+)
+
+// bpdu builds a configuration or RST BPDU as carried after the LLC header.
+func bpdu(version, typ, flags byte, root, bridge [8]byte, cost uint32, port uint16) []byte {
+    b := []byte{0, 0, version, typ, flags}
+    b = append(b, root[:]...)
+    b = append(b, byte(cost>>24), byte(cost>>16), byte(cost>>8), byte(cost))
+    b = append(b, bridge[:]...)
+    b = append(b, byte(port>>8), byte(port))
+    b = append(b, 0x01, 0x00, 0x14, 0x00, 0x02, 0x00, 0x0f, 0x00) // message age 1, max age 20, hello 2, forward delay 15
+    if typ == TypeRST {
+        b = append(b, 0) // version 1 length
+    }
+    return b
+}
+
+var (
+    testRootID = [8]byte{0x10, 0x00, 0x02, 0x1c, 0x73, 0x00, 0x00, 0x01}
+    senderID   = [8]byte{0x80, 0x01, 0x02, 0x1c, 0x73, 0x00, 0x00, 0x02}
+)
+
+func TestParse(t *testing.T) {
+    // An alternate, discarding port with an agreement.
+    b, err := Parse(bpdu(VersionRSTP, TypeRST, 0x44, testRootID, senderID, 2000, 0x8011))
+    if err != nil {
+        t.Fatalf("Parse: %v", err)
+    }
+    want := BPDU{
+        Version: VersionRSTP, Type: TypeRST,
+        Root: "4096.02:1c:73:00:00:01", RootPathCost: 2000, Bridge: "32769.02:1c:73:00:00:02", Port: "128.17",
+        Role: RoleAlternate, State: StateDiscarding, Agreement: true,
+        MessageAge: 1, MaxAge: 20, HelloTime: 2, ForwardDelay: 15,
+    }
+    if b != want {
+        t.Errorf("Parse() =\n%+v\nwant\n%+v", b, want)
+    }
+    if !b.Blocked() || b.VersionName() != "rstp" {
+        t.Errorf("Blocked() = %v, VersionName() = %q, want true, rstp", b.Blocked(), b.VersionName())
+    }
+
+    // A designated, forwarding port signalling a topology change.
+    b, err = Parse(bpdu(VersionRSTP, TypeRST, 0x3d, testRootID, senderID, 0, 0x8001))
+    if err != nil {
+        t.Fatalf("Parse: %v", err)
+    }
+    if b.Role != RoleDesignated || b.State != StateForwarding || !b.TopologyChange || b.Blocked() {
+        t.Errorf("Parse() = %+v, want a designated forwarding port with TC", b)
+    }
+
+    // Plain STP carries no role or state.
+    b, err = Parse(bpdu(VersionSTP, TypeConfig, 0, testRootID, senderID, 4, 0x8002))
+    if err != nil {
+        t.Fatalf("Parse: %v", err)
+    }
+    if b.Role != "" || b.State != "" || b.Blocked() || b.Port != "128.2" {
+        t.Errorf("Parse() = %+v, want a config BPDU without a role", b)
+    }
+
+    b, err = Parse([]byte{0, 0, VersionSTP, TypeTCN})
+    if err != nil || b.Type != TypeTCN {
+        t.Errorf("Parse(TCN) = %+v, %v", b, err)
+    }
+}
+
+func TestParseMSTP(t *testing.T) {
+    cist := [8]byte{0x80, 0x00, 0x02, 0x1c, 0x73, 0x00, 0x00, 0x09}
+    payload := bpdu(VersionMSTP, TypeRST, 0x7c, testRootID, senderID, 20000, 0x8003)
+    payload = append(payload, 0, 64) // version 3 length
+    payload = append(payload, 0)     // configuration ID format selector
+    name := make([]byte, 32)
+    copy(name, "dc1-frontend")
+    payload = append(payload, name...)
+    payload = append(payload, 0, 7)                // revision
+    payload = append(payload, make([]byte, 16)...) // configuration digest
+    payload = append(payload, 0, 0, 0x4e, 0x20)    // CIST internal root path cost
+    payload = append(payload, cist[:]...)
+    payload = append(payload, 20) // remaining hops
+
+    b, err := Parse(payload)
+    if err != nil {
+        t.Fatalf("Parse: %v", err)
+    }
+    if b.VersionName() != "mstp" || b.Region != "dc1-frontend" || b.RegionRevision != 7 ||
+        b.Bridge != "32768.02:1c:73:00:00:09" || b.RegionalRoot != "32769.02:1c:73:00:00:02" ||
+        b.Role != RoleDesignated || b.State != StateForwarding {
+        t.Errorf("Parse() = %+v", b)
+    }
+}
+
+func TestParseErrors(t *testing.T) {
+    tests := []struct {
+        name    string
+        payload []byte
+    }{
+        {"short", []byte{0, 0, 0}},
+        {"protocol", []byte{0, 1, VersionSTP, TypeTCN}},
+        {"type", []byte{0, 0, VersionSTP, 0x33}},
+        {"short config", bpdu(VersionSTP, TypeConfig, 0, testRootID, senderID, 4, 1)[:20]},
+        {"short rst", bpdu(VersionRSTP, TypeRST, 0, testRootID, senderID, 4, 1)[:35]},
+    }
+    for _, tt := range tests {
+        if _, err := Parse(tt.payload); err == nil {
+            t.Errorf("%s: Parse succeeded, want error", tt.name)
+        }
+    }
+}
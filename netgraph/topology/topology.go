@@ -0,0 +1,507 @@
+// Package topology holds the data model shared by netgraph, gendot and gentopo:
+// the snapshots (edges, neighbors and capture state) netgraph writes, the devices.json
+// inventory, and helpers to load, save and validate them.
+package topology
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/AMD-DC-GPU/ce/netgraph/lacp"
+    "github.com/AMD-DC-GPU/ce/netgraph/lldp"
+    "github.com/AMD-DC-GPU/ce/netgraph/stp"
+)
+
+// Node represents one end of a link, e.g. (device=switch1, interface=Eth0/1, mac=aa:bb:cc...).
+type Node struct {
+    Device    string `json:"device"`
+    Interface string `json:"interface"`
+    MAC       string `json:"mac,omitempty"`
+
+    // The fields below are only filled in for remote nodes learned via LLDP or CDP.
+    ChassisID           string   `json:"chassis_id,omitempty"`
+    ChassisIDSubtype    string   `json:"chassis_id_subtype,omitempty"`
+    PortIDSubtype       string   `json:"port_id_subtype,omitempty"`
+    TTL                 uint16   `json:"ttl,omitempty"`
+    PortDescription     string   `json:"port_description,omitempty"`
+    SystemDescription   string   `json:"system_description,omitempty"`
+    Capabilities        []string `json:"capabilities,omitempty"`
+    EnabledCapabilities []string `json:"enabled_capabilities,omitempty"`
+    ManagementAddresses []string `json:"management_addresses,omitempty"`
+
+    // LLDP IEEE 802.1 / 802.3 organizationally-specific fields.
+    PortVLANID      uint16                `json:"port_vlan_id,omitempty"`
+    ProtocolVLANIDs []uint16              `json:"protocol_vlan_ids,omitempty"`
+    VLANNames       []lldp.VLAN           `json:"vlan_names,omitempty"`
+    LinkAggregation *lldp.LinkAggregation `json:"link_aggregation,omitempty"`
+    MACPHY          *lldp.MACPHY          `json:"mac_phy,omitempty"`
+    MaxFrameSize    uint16                `json:"max_frame_size,omitempty"`
+    DCBX            *lldp.DCBX            `json:"dcbx,omitempty"`
+
+    // CDP-only fields.
+    Platform        string `json:"platform,omitempty"`
+    SoftwareVersion string `json:"software_version,omitempty"`
+    NativeVLAN      uint16 `json:"native_vlan,omitempty"`
+
+    // Duplex is advertised by CDP for remote nodes and read from sysfs for local ones.
+    Duplex string `json:"duplex,omitempty"`
+
+    // Local-only link settings, read from sysfs during a live capture.
+    MTU       int      `json:"mtu,omitempty"`
+    SpeedMbps int      `json:"speed_mbps,omitempty"`
+    VLANs     []int    `json:"vlans,omitempty"` // IDs of VLAN subinterfaces stacked on this interface
+    NIC       *NIC     `json:"nic,omitempty"`
+    IPs       []string `json:"ips,omitempty"`  // local: configured addresses; remote: learned from ARP
+    GPU       *GPU     `json:"gpu,omitempty"`  // GPU whose traffic uses this NIC (its rail)
+    Bond      string   `json:"bond,omitempty"` // bond (LAG) master the interface is enslaved to
+
+    // LACP is the port's LACP information: for remote nodes the LACPDU actor, for local
+    // nodes the partner information the remote port holds about us.
+    LACP *lacp.Port `json:"lacp,omitempty"`
+
+    // STP is the latest BPDU the remote bridge port sent on the link.
+    STP *stp.BPDU `json:"stp,omitempty"`
+}
+
+// GPU affinity methods, as recorded in GPU.Via.
+const (
+    GPUViaPCIe = "pcie" // GPU and NIC share an upstream PCIe bridge/switch
+    GPUViaNUMA = "numa" // only GPU on the NIC's NUMA node without a closer NIC
+)
+
+// GPU identifies the GPU closest to a local NIC.
+// Index is the GPU's position among the node's AMD GPUs in PCI address order.
+type GPU struct {
+    Index      int    `json:"index"`
+    PCIAddress string `json:"pci_address"`
+    Via        string `json:"via"`
+}
+
+// NIC is the local inventory of a network interface, read from sysfs.
+// Virtual interfaces have no driver, PCI address or NUMA node.
+type NIC struct {
+    Driver         string `json:"driver,omitempty"`
+    Firmware       string `json:"firmware,omitempty"`
+    PCIAddress     string `json:"pci_address,omitempty"` // PCI BDF, e.g. 0000:79:00.0
+    NUMANode       int    `json:"numa_node"`             // -1 when unknown or not on a NUMA system
+    OperState      string `json:"operstate,omitempty"`
+    CarrierChanges int    `json:"carrier_changes"`
+    RDMADevice     string `json:"rdma_device,omitempty"` // e.g. ionic_0
+}
+
+// Edge links two Nodes (Local -> Remote).
+// Repeated advertisements for the same link update one Edge rather than adding new ones.
+type Edge struct {
+    Local     Node      `json:"local"`
+    Remote    Node      `json:"remote"`
+    Protocol  string    `json:"protocol"` // discovery protocol the edge was learned from ("lldp" or "cdp")
+    FirstSeen time.Time `json:"first_seen"`
+    LastSeen  time.Time `json:"last_seen"`
+    Frames    int       `json:"frames"`          // number of advertisements received for this link
+    Stale     bool      `json:"stale,omitempty"` // advertised TTL expired without a refresh
+    Findings  []Finding `json:"findings,omitempty"`
+    LAG       *LAG      `json:"lag,omitempty"` // logical link aggregation this edge is a member of
+}
+
+// LAG is a logical link aggregation (bond) bundling several local interfaces, as negotiated
+// with LACP. ID is the bond name, or the local LACP system and key when the bond is unknown.
+// PartnerSystems lists the LACP system IDs the members are aggregated with; MLAG is set when
+// the members land on more than one partner system or remote device.
+type LAG struct {
+    ID             string   `json:"id"`
+    Device         string   `json:"device"`
+    Bond           string   `json:"bond,omitempty"`
+    System         string   `json:"system,omitempty"` // local LACP system ID
+    Key            int      `json:"key"`
+    Members        []string `json:"members"`
+    RemoteDevices  []string `json:"remote_devices,omitempty"`
+    PartnerSystems []string `json:"partner_systems"`
+    MLAG           bool     `json:"mlag,omitempty"`
+}
+
+// Neighbor is a neighbor seen on a local interface by a protocol that doesn't produce an
+// edge (ARP, IPv6 ND, LACP, STP), or a CDP announcement.
+type Neighbor struct {
+    InterfaceName string `json:"interface"`
+    SourceMAC     string `json:"source_mac"`
+    IP            string `json:"ip,omitempty"` // sender IP for ARP, bound address for ND
+    Protocol      string `json:"protocol"`
+    Details       string `json:"details"` // Could store more structured info
+}
+
+// InterfaceStatus reports the capture state of one local interface.
+type InterfaceStatus struct {
+    Name        string    `json:"name"`
+    MAC         string    `json:"mac,omitempty"`
+    State       string    `json:"state"` // "capturing", "failed" or "stopped"
+    Error       string    `json:"error,omitempty"`
+    Advertising bool      `json:"advertising_lldp,omitempty"`
+    Packets     uint64    `json:"packets"` // frames received
+    LastPacket  time.Time `json:"last_packet"`
+
+    Decoded uint64 `json:"decoded"`           // frames that added a neighbor, edge, binding, LACP or STP state
+    Dropped uint64 `json:"dropped,omitempty"` // frames netgraph dropped because it fell behind
+    Errors  uint64 `json:"errors,omitempty"`  // parse failures plus capture failures
+
+    PacketsByEtherType map[string]uint64 `json:"packets_by_ethertype,omitempty"` // keyed by "0x88cc" etc.
+    ParseFailures      map[string]uint64 `json:"parse_failures,omitempty"`       // keyed by protocol
+
+    // Kernel/libpcap counters from pcap Stats(), refreshed every few seconds.
+    PcapReceived  int `json:"pcap_received"`
+    PcapDropped   int `json:"pcap_dropped"`
+    PcapIfDropped int `json:"pcap_if_dropped"`
+
+    NIC *NIC `json:"nic,omitempty"` // local inventory, read when the capture starts
+
+    FirstNeighbor time.Time `json:"first_neighbor"`   // when the first LLDP/CDP neighbor was learned
+    Silent        bool      `json:"silent,omitempty"` // -until-complete gave up on it despite carrier
+
+    LinkTransitions []LinkTransition `json:"link_transitions,omitempty"` // link changes seen while capturing
+
+    STP *STPPort `json:"stp,omitempty"` // spanning tree state of the bridge port the interface is cabled to
+}
+
+// STPPort is the spanning tree state a local interface learned from the BPDUs it received:
+// the root bridge, the designated bridge and the role and state of the bridge port.
+type STPPort struct {
+    stp.BPDU
+    LastBPDU        time.Time `json:"last_bpdu"`
+    BPDUs           uint64    `json:"bpdus"`
+    TopologyChanges uint64    `json:"topology_changes,omitempty"` // TCNs and newly raised TC flags
+}
+
+// LinkTransition is a change of an interface's link state during the capture.
+type LinkTransition struct {
+    Time  time.Time `json:"time"`
+    State string    `json:"state"` // "up", "down" or "removed"
+}
+
+// SchemaVersion is the version of the Snapshot format written by this package.
+// Legacy files holding a bare array of edges load as version 0.
+const SchemaVersion = 1
+
+// Snapshot is what netgraph writes: the results of one collection on one host, wrapped
+// with the metadata needed to tell snapshots apart once they are merged or archived.
+type Snapshot struct {
+    SchemaVersion int               `json:"schema_version"`
+    Collection    Collection        `json:"collection"`
+    Edges         []Edge            `json:"edges"`
+    Neighbors     []Neighbor        `json:"neighbors"`
+    Interfaces    []InterfaceStatus `json:"interfaces"`
+    Findings      []Finding         `json:"findings,omitempty"` // port anomalies: multiple neighbors, loops, neighbor changes, STP blocking, silent ports
+    L3            *L3Report         `json:"l3,omitempty"`       // only when IP/MAC bindings or IPv6 routers were seen
+}
+
+// Collection describes how, where and when a snapshot was taken. Source is "live" or the
+// capture file that was replayed; the capture times are those of the first and last
+// replayed packet for a replay.
+type Collection struct {
+    Tool         string    `json:"tool"`
+    ToolVersion  string    `json:"tool_version"`
+    Hostname     string    `json:"hostname"`
+    MachineID    string    `json:"machine_id,omitempty"`
+    Source       string    `json:"source"`
+    CaptureStart time.Time `json:"capture_start"`
+    CaptureEnd   time.Time `json:"capture_end"`
+    Interfaces   []string  `json:"interfaces"` // interfaces captured on
+    BPFFilter    string    `json:"bpf_filter,omitempty"`
+    Backend      string    `json:"capture_backend,omitempty"` // pcap or afpacket
+}
+
+// Finding severities.
+const (
+    SeverityError   = "error"
+    SeverityWarning = "warning"
+)
+
+// Finding is a problem detected on a link, e.g. the two ends disagreeing on MTU.
+// Local and Remote hold the values that were compared.
+type Finding struct {
+    Check    string   `json:"check"` // what was checked: "mtu", "speed", "duplex", "vlan", ...
+    Severity string   `json:"severity"`
+    Message  string   `json:"message"`
+    Local    string   `json:"local,omitempty"`
+    Remote   string   `json:"remote,omitempty"`
+    Evidence []string `json:"evidence,omitempty"` // observations that led to the finding
+}
+
+// Binding is an IP to MAC mapping observed on a local interface, e.g. from an ARP packet.
+type Binding struct {
+    IP        string    `json:"ip"`
+    MAC       string    `json:"mac"`
+    Interface string    `json:"interface"`        // local interface it was seen on
+    Device    string    `json:"device,omitempty"` // host that saw it
+    Source    string    `json:"source"`           // "arp", "garp" (gratuitous ARP), "nd" (IPv6 neighbor discovery), "local" (configured here)
+    FirstSeen time.Time `json:"first_seen"`
+    LastSeen  time.Time `json:"last_seen"`
+    Count     int       `json:"count"`
+}
+
+// Router is an IPv6 router heard sending Router Advertisements on a local interface.
+// Lifetime is the default router lifetime in seconds; 0 means the router is not a default router.
+type Router struct {
+    Interface   string    `json:"interface"`
+    Device      string    `json:"device,omitempty"`
+    IP          string    `json:"ip"`
+    MAC         string    `json:"mac,omitempty"`
+    Lifetime    int       `json:"lifetime"`
+    HopLimit    int       `json:"hop_limit,omitempty"`
+    Managed     bool      `json:"managed,omitempty"`      // addresses come from DHCPv6
+    OtherConfig bool      `json:"other_config,omitempty"` // other configuration comes from DHCPv6
+    MTU         int       `json:"mtu,omitempty"`
+    Prefixes    []Prefix  `json:"prefixes,omitempty"`
+    FirstSeen   time.Time `json:"first_seen"`
+    LastSeen    time.Time `json:"last_seen"`
+    Count       int       `json:"count"`
+}
+
+// Prefix is a prefix advertised in a Router Advertisement. Lifetimes are in seconds.
+type Prefix struct {
+    Prefix            string `json:"prefix"`
+    OnLink            bool   `json:"on_link"`
+    Autonomous        bool   `json:"autonomous"` // hosts may configure addresses in it with SLAAC
+    ValidLifetime     uint32 `json:"valid_lifetime"`
+    PreferredLifetime uint32 `json:"preferred_lifetime"`
+}
+
+// L3Report is the L3 view of one host: the IP/MAC bindings and IPv6 routers it saw, the
+// subnets of its interfaces and the IP findings (duplicate and off-subnet IPs).
+type L3Report struct {
+    Bindings []Binding `json:"bindings"`
+    Routers  []Router  `json:"routers"`
+    Subnets  []Subnet  `json:"subnets"`
+    Findings []Finding `json:"findings"`
+}
+
+// Subnet summarizes the L3 neighbors seen on one local interface.
+// Inferred is the smallest prefix (no shorter than /16) covering every IPv4 neighbor seen, if there is one.
+// Advertised lists the on-link prefixes routers announced on the interface.
+type Subnet struct {
+    Interface  string   `json:"interface"`
+    Configured []string `json:"configured,omitempty"`
+    Inferred   string   `json:"inferred,omitempty"`
+    Advertised []string `json:"advertised,omitempty"`
+    Neighbors  int      `json:"neighbors"`
+    OffSubnet  int      `json:"off_subnet,omitempty"` // neighbors outside every configured (or advertised) subnet
+}
+
+// NodeBindings returns a binding for every IP on every node of edges that has a MAC,
+// so IP conflicts can be looked for across the outputs of many hosts.
+func NodeBindings(edges []Edge) []Binding {
+    var bindings []Binding
+    for _, e := range edges {
+        for _, n := range []Node{e.Local, e.Remote} {
+            if n.MAC == "" {
+                continue
+            }
+            for _, ip := range n.IPs {
+                bindings = append(bindings, Binding{IP: ip, MAC: n.MAC, Interface: n.Interface, Device: n.Device})
+            }
+        }
+    }
+    return bindings
+}
+
+// FindDuplicateIPs reports every IP address that is bound to more than one MAC address.
+func FindDuplicateIPs(bindings []Binding) []Finding {
+    macs := make(map[string]map[string][]Binding)
+    var ips []string
+    for _, b := range bindings {
+        if macs[b.IP] == nil {
+            macs[b.IP] = make(map[string][]Binding)
+            ips = append(ips, b.IP)
+        }
+        macs[b.IP][b.MAC] = append(macs[b.IP][b.MAC], b)
+    }
+    sort.Strings(ips)
+
+    var findings []Finding
+    for _, ip := range ips {
+        if len(macs[ip]) < 2 {
+            continue
+        }
+        var evidence []string
+        for mac, bs := range macs[ip] {
+            for _, b := range bs {
+                where := b.Interface
+                if b.Device != "" {
+                    where = b.Device + " " + where
+                }
+                line := fmt.Sprintf("%s on %s", mac, where)
+                if b.Source != "" {
+                    line += " (" + b.Source + ")"
+                }
+                evidence = append(evidence, line)
+            }
+        }
+        sort.Strings(evidence)
+        findings = append(findings, Finding{
+            Check:    "duplicate-ip",
+            Severity: SeverityError,
+            Message:  fmt.Sprintf("%s is used by %d MAC addresses", ip, len(macs[ip])),
+            Remote:   ip,
+            Evidence: evidence,
+        })
+    }
+    return findings
+}
+
+// Device types recognised in devices.json.
+const (
+    DeviceTypeServer = "server"
+    DeviceTypeSwitch = "switch"
+)
+
+// DeviceInfo holds the data for each device from devices.json.
+// Rack is normally assigned by gentopo from connectivity, but may be given in the file.
+type DeviceInfo struct {
+    Device  string `json:"device"`
+    Type    string `json:"type"`
+    Subtype string `json:"subtype,omitempty"`
+    Rack    string `json:"rack,omitempty"`
+}
+
+// LoadEdges reads the edges of a netgraph JSON file, either a snapshot or a legacy bare array of edges.
+func LoadEdges(path string) ([]Edge, error) {
+    s, err := LoadSnapshot(path)
+    if err != nil {
+        return nil, err
+    }
+    return s.Edges, nil
+}
+
+// LoadSnapshot reads a netgraph JSON file. A legacy bare array of edges is returned as a
+// version 0 snapshot with no metadata; snapshots newer than SchemaVersion are rejected.
+func LoadSnapshot(path string) (Snapshot, error) {
+    var s Snapshot
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return s, err
+    }
+    if trimmed := bytes.TrimLeft(data, " \t\r\n"); len(trimmed) > 0 && trimmed[0] == '[' {
+        if err := json.Unmarshal(data, &s.Edges); err != nil {
+            return s, fmt.Errorf("%s: %w", path, err)
+        }
+        return s, nil
+    }
+    if err := json.Unmarshal(data, &s); err != nil {
+        return s, fmt.Errorf("%s: %w", path, err)
+    }
+    if s.SchemaVersion > SchemaVersion {
+        return s, fmt.Errorf("%s: schema version %d is newer than the supported %d", path, s.SchemaVersion, SchemaVersion)
+    }
+    return s, nil
+}
+
+// MarshalSnapshot encodes s in the indented form netgraph writes, stamped with SchemaVersion.
+// Nil sections are written as empty arrays so readers always get valid input.
+func MarshalSnapshot(s Snapshot) ([]byte, error) {
+    s.SchemaVersion = SchemaVersion
+    if s.Collection.Interfaces == nil {
+        s.Collection.Interfaces = []string{}
+    }
+    if s.Edges == nil {
+        s.Edges = []Edge{}
+    }
+    if s.Neighbors == nil {
+        s.Neighbors = []Neighbor{}
+    }
+    if s.Interfaces == nil {
+        s.Interfaces = []InterfaceStatus{}
+    }
+    return json.MarshalIndent(s, "", "  ")
+}
+
+// SaveSnapshot writes s to path as produced by MarshalSnapshot.
+func SaveSnapshot(path string, s Snapshot) error {
+    data, err := MarshalSnapshot(s)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0644)
+}
+
+// MarshalEdges encodes edges in the indented form netgraph writes.
+// A nil slice is written as an empty array so readers always get valid input.
+func MarshalEdges(edges []Edge) ([]byte, error) {
+    if edges == nil {
+        edges = []Edge{}
+    }
+    return json.MarshalIndent(edges, "", "  ")
+}
+
+// SaveEdges writes edges to path as produced by MarshalEdges.
+func SaveEdges(path string, edges []Edge) error {
+    data, err := MarshalEdges(edges)
+    if err != nil {
+        return err
+    }
+    return os.WriteFile(path, data, 0644)
+}
+
+// LoadDevices reads a devices.json file.
+func LoadDevices(path string) ([]DeviceInfo, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var devices []DeviceInfo
+    if err := json.Unmarshal(data, &devices); err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    return devices, nil
+}
+
+// DeviceMap indexes devices by name.
+func DeviceMap(devices []DeviceInfo) map[string]DeviceInfo {
+    m := make(map[string]DeviceInfo, len(devices))
+    for _, d := range devices {
+        m[d.Device] = d
+    }
+    return m
+}
+
+// ValidateEdges checks that every edge names a device and interface on both ends.
+// All problems are reported together.
+func ValidateEdges(edges []Edge) error {
+    var errs []error
+    for i, e := range edges {
+        if e.Local.Device == "" || e.Local.Interface == "" {
+            errs = append(errs, fmt.Errorf("edge %d: local end needs device and interface, got (%q, %q)",
+                i, e.Local.Device, e.Local.Interface))
+        }
+        if e.Remote.Device == "" || e.Remote.Interface == "" {
+            errs = append(errs, fmt.Errorf("edge %d: remote end needs device and interface, got (%q, %q)",
+                i, e.Remote.Device, e.Remote.Interface))
+        }
+    }
+    return errors.Join(errs...)
+}
+
+// ValidateDevices checks devices.json entries: each needs a unique name and a known type.
+// All problems are reported together.
+func ValidateDevices(devices []DeviceInfo) error {
+    var errs []error
+    seen := make(map[string]bool)
+    for i, d := range devices {
+        if d.Device == "" {
+            errs = append(errs, fmt.Errorf("device %d: missing name", i))
+            continue
+        }
+        if seen[d.Device] {
+            errs = append(errs, fmt.Errorf("device %q: listed more than once", d.Device))
+        }
+        seen[d.Device] = true
+        if t := strings.ToLower(d.Type); t != DeviceTypeServer && t != DeviceTypeSwitch {
+            errs = append(errs, fmt.Errorf("device %q: unknown type %q (want %q or %q)",
+                d.Device, d.Type, DeviceTypeServer, DeviceTypeSwitch))
+        }
+    }
+    return errors.Join(errs...)
+}
@@ -0,0 +1,214 @@
+package topology
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestSaveLoadEdges(t *testing.T) {
+    seen := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    edges := []Edge{{
+        Local:     Node{Device: "gpu-node-01", Interface: "ens1f0np0", MAC: "02:00:00:00:00:01"},
+        Remote:    Node{Device: "leaf01", Interface: "Ethernet1/1", ChassisID: "00:1c:73:aa:bb:cc", ChassisIDSubtype: "mac-address", TTL: 120},
+        Protocol:  "lldp",
+        FirstSeen: seen,
+        LastSeen:  seen.Add(time.Minute),
+        Frames:    3,
+    }}
+
+    path := filepath.Join(t.TempDir(), "edges.json")
+    if err := SaveEdges(path, edges); err != nil {
+        t.Fatalf("SaveEdges: %v", err)
+    }
+    got, err := LoadEdges(path)
+    if err != nil {
+        t.Fatalf("LoadEdges: %v", err)
+    }
+    if !reflect.DeepEqual(got, edges) {
+        t.Errorf("LoadEdges() =\n%+v\nwant\n%+v", got, edges)
+    }
+}
+
+func TestMarshalEdgesNil(t *testing.T) {
+    data, err := MarshalEdges(nil)
+    if err != nil {
+        t.Fatalf("MarshalEdges: %v", err)
+    }
+    if string(data) != "[]" {
+        t.Errorf("MarshalEdges(nil) = %s, want []", data)
+    }
+}
+
+// Files written before edges carried protocol and timestamps must still load.
+func TestLoadLegacyEdges(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "old.json")
+    legacy := `[{"local":{"device":"gpu-node-01","interface":"eth0","mac":"02:00:00:00:00:01"},
+                 "remote":{"device":"leaf01","interface":"Ethernet1/1","mac":""}}]`
+    if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+        t.Fatal(err)
+    }
+    edges, err := LoadEdges(path)
+    if err != nil {
+        t.Fatalf("LoadEdges: %v", err)
+    }
+    if len(edges) != 1 || edges[0].Remote.Device != "leaf01" || edges[0].Protocol != "" {
+        t.Errorf("LoadEdges() = %+v", edges)
+    }
+}
+
+func TestSaveLoadSnapshot(t *testing.T) {
+    start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+    snap := Snapshot{
+        Collection: Collection{
+            Tool: "netgraph", ToolVersion: "v1.2.0", Hostname: "gpu-node-01", MachineID: "0123456789abcdef",
+            Source: "live", CaptureStart: start, CaptureEnd: start.Add(30 * time.Second),
+            Interfaces: []string{"ens1", "ens2"}, BPFFilter: "ether proto 0x88cc",
+        },
+        Edges: []Edge{{Local: Node{Device: "gpu-node-01", Interface: "ens1"}, Remote: Node{Device: "leaf01", Interface: "Ethernet1"},
+            Protocol: "lldp", FirstSeen: start, LastSeen: start, Frames: 1}},
+        Neighbors:  []Neighbor{{InterfaceName: "ens1", SourceMAC: "02:00:00:00:00:aa", IP: "10.1.0.254", Protocol: "ARP"}},
+        Interfaces: []InterfaceStatus{{Name: "ens1", State: "stopped", Packets: 12, LastPacket: start}},
+    }
+
+    path := filepath.Join(t.TempDir(), "snapshot.json")
+    if err := SaveSnapshot(path, snap); err != nil {
+        t.Fatalf("SaveSnapshot: %v", err)
+    }
+    got, err := LoadSnapshot(path)
+    if err != nil {
+        t.Fatalf("LoadSnapshot: %v", err)
+    }
+    snap.SchemaVersion = SchemaVersion
+    if !reflect.DeepEqual(got, snap) {
+        t.Errorf("LoadSnapshot() =\n%+v\nwant\n%+v", got, snap)
+    }
+    if edges, err := LoadEdges(path); err != nil || !reflect.DeepEqual(edges, snap.Edges) {
+        t.Errorf("LoadEdges(snapshot) = %+v, %v", edges, err)
+    }
+}
+
+func TestMarshalSnapshotEmpty(t *testing.T) {
+    data, err := MarshalSnapshot(Snapshot{})
+    if err != nil {
+        t.Fatalf("MarshalSnapshot: %v", err)
+    }
+    for _, want := range []string{`"schema_version": 1`, `"edges": []`, `"neighbors": []`, `"interfaces": []`} {
+        if !strings.Contains(string(data), want) {
+            t.Errorf("MarshalSnapshot(empty) = %s, missing %s", data, want)
+        }
+    }
+}
+
+func TestLoadSnapshotLegacyAndFuture(t *testing.T) {
+    dir := t.TempDir()
+    legacy := filepath.Join(dir, "legacy.json")
+    if err := os.WriteFile(legacy, []byte("\n  [{\"local\":{\"device\":\"a\",\"interface\":\"eth0\"},\"remote\":{\"device\":\"b\",\"interface\":\"eth1\"}}]"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    s, err := LoadSnapshot(legacy)
+    if err != nil || s.SchemaVersion != 0 || len(s.Edges) != 1 || s.Collection.Hostname != "" {
+        t.Errorf("LoadSnapshot(legacy) = %+v, %v", s, err)
+    }
+
+    future := filepath.Join(dir, "future.json")
+    if err := os.WriteFile(future, []byte(`{"schema_version": 99, "edges": []}`), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := LoadEdges(future); err == nil || !strings.Contains(err.Error(), "schema version 99") {
+        t.Errorf("LoadEdges(future) error = %v, want a schema version error", err)
+    }
+}
+
+func TestLoadEdgesErrors(t *testing.T) {
+    dir := t.TempDir()
+    if _, err := LoadEdges(filepath.Join(dir, "missing.json")); err == nil {
+        t.Error("LoadEdges(missing) succeeded, want error")
+    }
+    bad := filepath.Join(dir, "bad.json")
+    if err := os.WriteFile(bad, []byte("{not json"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    _, err := LoadEdges(bad)
+    if err == nil || !strings.Contains(err.Error(), bad) {
+        t.Errorf("LoadEdges(bad) error = %v, want one naming %s", err, bad)
+    }
+}
+
+func TestLoadDevices(t *testing.T) {
+    path := filepath.Join(t.TempDir(), "devices.json")
+    data := `[{"device":"leaf01","type":"switch","subtype":"leaf"},
+              {"device":"gpu-node-01","type":"server","rack":"r1"}]`
+    if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+        t.Fatal(err)
+    }
+    devices, err := LoadDevices(path)
+    if err != nil {
+        t.Fatalf("LoadDevices: %v", err)
+    }
+    m := DeviceMap(devices)
+    if m["leaf01"].Subtype != "leaf" || m["gpu-node-01"].Rack != "r1" {
+        t.Errorf("DeviceMap() = %+v", m)
+    }
+    if err := ValidateDevices(devices); err != nil {
+        t.Errorf("ValidateDevices: %v", err)
+    }
+}
+
+func TestValidateEdges(t *testing.T) {
+    edges := []Edge{
+        {Local: Node{Device: "a", Interface: "eth0"}, Remote: Node{Device: "b", Interface: "eth1"}},
+        {Local: Node{Device: "a"}, Remote: Node{Interface: "eth1"}},
+    }
+    err := ValidateEdges(edges)
+    if err == nil {
+        t.Fatal("ValidateEdges succeeded, want error")
+    }
+    for _, want := range []string{"edge 1: local", "edge 1: remote"} {
+        if !strings.Contains(err.Error(), want) {
+            t.Errorf("ValidateEdges error %q does not mention %q", err, want)
+        }
+    }
+    if err := ValidateEdges(edges[:1]); err != nil {
+        t.Errorf("ValidateEdges(valid) = %v", err)
+    }
+}
+
+func TestValidateDevices(t *testing.T) {
+    devices := []DeviceInfo{
+        {Device: "leaf01", Type: "Switch"},
+        {Device: "leaf01", Type: "switch"},
+        {Device: "pdu01", Type: "power"},
+        {Type: "server"},
+    }
+    err := ValidateDevices(devices)
+    if err == nil {
+        t.Fatal("ValidateDevices succeeded, want error")
+    }
+    for _, want := range []string{`"leaf01": listed more than once`, `"pdu01": unknown type`, "device 3: missing name"} {
+        if !strings.Contains(err.Error(), want) {
+            t.Errorf("ValidateDevices error %q does not mention %q", err, want)
+        }
+    }
+}
+
+func TestFindDuplicateIPs(t *testing.T) {
+    edges := []Edge{
+        {Local: Node{Device: "gpu-1", Interface: "ens1", MAC: "02:00:00:00:01:01", IPs: []string{"10.1.0.11"}},
+            Remote: Node{Device: "leaf01", Interface: "Ethernet1", MAC: "02:00:00:00:00:aa", IPs: []string{"10.1.0.254"}}},
+        {Local: Node{Device: "gpu-2", Interface: "ens1", MAC: "02:00:00:00:02:01", IPs: []string{"10.1.0.11"}},
+            Remote: Node{Device: "leaf01", Interface: "Ethernet2", MAC: "02:00:00:00:00:aa", IPs: []string{"10.1.0.254"}}},
+    }
+    findings := FindDuplicateIPs(NodeBindings(edges))
+    if len(findings) != 1 {
+        t.Fatalf("FindDuplicateIPs() = %+v, want one finding", findings)
+    }
+    f := findings[0]
+    want := []string{"02:00:00:00:01:01 on gpu-1 ens1", "02:00:00:00:02:01 on gpu-2 ens1"}
+    if f.Check != "duplicate-ip" || f.Remote != "10.1.0.11" || !reflect.DeepEqual(f.Evidence, want) {
+        t.Errorf("finding = %+v", f)
+    }
+}